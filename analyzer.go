@@ -0,0 +1,214 @@
+package ctxize
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/mod/semver"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+)
+
+// Analyzer reports the declaration and the calls of the function specified by -func flag,
+// with suggested fixes which add the variable specified by -var flag as App.Rewrite does.
+// This lets ctxize run as an analysis pass of gopls or other drivers.
+var Analyzer = newAnalyzer()
+
+func newAnalyzer() *analysis.Analyzer {
+	a := &analysis.Analyzer{
+		Name: "ctxize",
+		Doc:  "report functions and calls to be rewritten to take ctx",
+	}
+
+	funcSpec := a.Flags.String("func", "", "target function, in form of <pkg>[.<type>].<name>")
+	varSpec := a.Flags.String("var", "ctx context.Context = context.TODO()", `inserted variable spec; must be in form of "<name> <path>.<type> = <expr>"`)
+
+	a.Run = func(pass *analysis.Pass) (interface{}, error) {
+		return nil, runAnalyzer(pass, *funcSpec, *varSpec)
+	}
+
+	return a
+}
+
+func runAnalyzer(pass *analysis.Pass, funcSpecString, varSpecString string) error {
+	if funcSpecString == "" {
+		return nil
+	}
+
+	spec, err := ParseFuncSpec(funcSpecString)
+	if err != nil {
+		return err
+	}
+	spec.pkg = &packages.Package{PkgPath: spec.PkgPath}
+
+	varSpec, err := ParseVarSpec(varSpecString)
+	if err != nil {
+		return err
+	}
+	varSpec.varTypeObj = lookupImportedType(pass.Pkg, varSpec.PkgPath, varSpec.TypeName, map[*types.Package]bool{})
+
+	for _, file := range pass.Files {
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Body == nil {
+				continue
+			}
+
+			if f, ok := pass.TypesInfo.Defs[funcDecl.Name].(*types.Func); ok && spec.matches(f) {
+				pass.Report(declDiagnostic(file, funcDecl, spec, varSpec))
+			}
+
+			ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+				callExpr, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+
+				var id *ast.Ident
+				switch fun := callExpr.Fun.(type) {
+				case *ast.Ident:
+					id = fun
+				case *ast.SelectorExpr:
+					id = fun.Sel
+				default:
+					return true
+				}
+
+				if f, ok := pass.TypesInfo.Uses[id].(*types.Func); ok && spec.matches(f) {
+					pass.Report(callDiagnostic(pass, file, funcDecl, callExpr, spec, varSpec))
+				}
+
+				return true
+			})
+		}
+	}
+
+	return nil
+}
+
+func declDiagnostic(file *ast.File, funcDecl *ast.FuncDecl, spec FuncSpec, varSpec *VarSpec) analysis.Diagnostic {
+	param := fmt.Sprintf("%s %s", varSpec.Name, varTypeString(file, varSpec))
+	text := param
+	if len(funcDecl.Type.Params.List) > 0 {
+		text += ", "
+	}
+
+	edits := []analysis.TextEdit{
+		{Pos: funcDecl.Type.Params.Opening + 1, End: funcDecl.Type.Params.Opening + 1, NewText: []byte(text)},
+	}
+	edits = append(edits, importEdits(file, varSpec.PkgPath)...)
+
+	return analysis.Diagnostic{
+		Pos:     funcDecl.Name.Pos(),
+		Message: fmt.Sprintf("%s should take %s", spec, param),
+		SuggestedFixes: []analysis.SuggestedFix{
+			{Message: fmt.Sprintf("Add %s to parameters", param), TextEdits: edits},
+		},
+	}
+}
+
+// callDiagnostic reports callExpr in funcDecl. The suggested fix is complete by itself, declaring
+// the variable and importing its package if required, as fixes may be applied one by one.
+func callDiagnostic(pass *analysis.Pass, file *ast.File, funcDecl *ast.FuncDecl, callExpr *ast.CallExpr, spec FuncSpec, varSpec *VarSpec) analysis.Diagnostic {
+	var varName string
+	scope := pass.TypesInfo.Scopes[funcDecl.Type]
+	if varSpec.varTypeObj != nil && scope != nil {
+		varName = varSpec.lookupEnclosing(pass.Pkg.Scope().Innermost(callExpr.Pos()), scope, callExpr.Pos())
+	}
+
+	var edits []analysis.TextEdit
+	if varName == "" {
+		varName = varSpec.Name
+		if scope == nil || scope.Lookup(varSpec.Name) == nil {
+			stub := fmt.Sprintf("\n%s := %s\n", varSpec.Name, varSpec.InitExpr)
+			edits = append(edits, analysis.TextEdit{Pos: funcDecl.Body.Lbrace + 1, End: funcDecl.Body.Lbrace + 1, NewText: []byte(stub)})
+			edits = append(edits, importEdits(file, varSpec.PkgPath)...)
+		}
+	}
+
+	text := varName
+	if len(callExpr.Args) > 0 {
+		text += ", "
+	}
+	edits = append(edits, analysis.TextEdit{Pos: callExpr.Lparen + 1, End: callExpr.Lparen + 1, NewText: []byte(text)})
+
+	return analysis.Diagnostic{
+		Pos:     callExpr.Pos(),
+		Message: fmt.Sprintf("call of %s should pass %s", spec, varName),
+		SuggestedFixes: []analysis.SuggestedFix{
+			{Message: fmt.Sprintf("Pass %s", varName), TextEdits: edits},
+		},
+	}
+}
+
+// importEdits returns edits to import path to file, if not yet imported.
+func importEdits(file *ast.File, path string) []analysis.TextEdit {
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err == nil && p == path {
+			return nil
+		}
+	}
+
+	return []analysis.TextEdit{
+		{Pos: file.Name.End(), End: file.Name.End(), NewText: []byte(fmt.Sprintf("\n\nimport %q", path))},
+	}
+}
+
+// varTypeString returns the type of varSpec as referred in file, qualified by the name
+// the package is imported as, or by the name of the package if not imported yet.
+func varTypeString(file *ast.File, varSpec *VarSpec) string {
+	for _, imp := range file.Imports {
+		if p, err := strconv.Unquote(imp.Path.Value); err != nil || p != varSpec.PkgPath || imp.Name == nil {
+			continue
+		}
+		switch imp.Name.Name {
+		case ".":
+			return varSpec.TypeName
+		case "_":
+		default:
+			return imp.Name.Name + "." + varSpec.TypeName
+		}
+	}
+
+	if varSpec.varTypeObj != nil {
+		return varSpec.varTypeObj.Pkg().Name() + "." + varSpec.TypeName
+	}
+
+	// not imported even indirectly, so guessed from the path, eg. "yaml" of "gopkg.in/yaml.v3"
+	name := path.Base(varSpec.PkgPath)
+	if semver.IsValid(name) && path.Dir(varSpec.PkgPath) != "." {
+		name = path.Base(path.Dir(varSpec.PkgPath))
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	return strings.ReplaceAll(name, "-", "_") + "." + varSpec.TypeName
+}
+
+// lookupImportedType finds type named typeName in package path among pkg and its transitive imports.
+func lookupImportedType(pkg *types.Package, path, typeName string, seen map[*types.Package]bool) types.Object {
+	if seen[pkg] {
+		return nil
+	}
+	seen[pkg] = true
+
+	if pkg.Path() == path {
+		if obj, ok := pkg.Scope().Lookup(typeName).(*types.TypeName); ok {
+			return obj
+		}
+		return nil
+	}
+
+	for _, imp := range pkg.Imports() {
+		if obj := lookupImportedType(imp, path, typeName, seen); obj != nil {
+			return obj
+		}
+	}
+
+	return nil
+}
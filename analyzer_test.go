@@ -0,0 +1,129 @@
+package ctxize
+
+import (
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer(t *testing.T) {
+	err := Analyzer.Flags.Set("func", "a.F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("func", "")
+
+	dir, err := filepath.Abs(filepath.Join("testdata", "analyzer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analysistest.RunWithSuggestedFixes(t, dir, Analyzer, "a")
+}
+
+func TestAnalyzer_importAlias(t *testing.T) {
+	err := Analyzer.Flags.Set("func", "b.F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("func", "")
+
+	dir, err := filepath.Abs(filepath.Join("testdata", "analyzer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analysistest.RunWithSuggestedFixes(t, dir, Analyzer, "b")
+}
+
+func TestAnalyzer_notImported(t *testing.T) {
+	err := Analyzer.Flags.Set("func", "d.F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("func", "")
+
+	err = Analyzer.Flags.Set("var", "tr example.com/trace/v2.Tracer = trace.New()")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("var", "ctx context.Context = context.TODO()")
+
+	dir, err := filepath.Abs(filepath.Join("testdata", "analyzer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	analysistest.Run(t, dir, Analyzer, "d")
+}
+
+func TestAnalyzer_selfContainedFixes(t *testing.T) {
+	err := Analyzer.Flags.Set("func", "c.F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer Analyzer.Flags.Set("func", "")
+
+	dir, err := filepath.Abs(filepath.Join("testdata", "analyzer"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := analysistest.Run(t, dir, Analyzer, "c")
+
+	// each fix is applied alone
+	var got []string
+	for _, r := range results {
+		for _, diag := range r.Diagnostics {
+			if !strings.HasPrefix(diag.Message, "call of ") {
+				continue
+			}
+
+			for _, fix := range diag.SuggestedFixes {
+				edits := fix.TextEdits
+				sort.SliceStable(edits, func(i, j int) bool { return edits[i].Pos < edits[j].Pos })
+
+				file := r.Pass.Fset.File(diag.Pos)
+				src, err := os.ReadFile(file.Name())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var b []byte
+				last := 0
+				for _, edit := range edits {
+					b = append(b, src[last:file.Offset(edit.Pos)]...)
+					b = append(b, edit.NewText...)
+					last = file.Offset(edit.End)
+				}
+				b = append(b, src[last:]...)
+
+				content, err := format.Source(b)
+				if err != nil {
+					t.Fatalf("%s: %s\n%s", fix.Message, err, b)
+				}
+				got = append(got, string(content))
+			}
+		}
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 fixes but got %d", len(got))
+	}
+	for i, content := range got {
+		for _, line := range []string{
+			`import "context"`,
+			"ctx := context.TODO()",
+			[]string{"F(ctx, 1)", "F(ctx, 2)"}[i],
+		} {
+			if !strings.Contains(content, line) {
+				t.Errorf("fix %d should contain %q:\n%s", i, line, content)
+			}
+		}
+	}
+}
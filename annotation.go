@@ -0,0 +1,231 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+const (
+	injectAnnotation = "//ctxize:inject"
+	ctxizeDirective  = "//go:ctxize"
+)
+
+// annotatedFunc is a function declaration annotated to be rewritten.
+type annotatedFunc struct {
+	funcSpec FuncSpec
+	varSpec  *VarSpec
+	comment  *ast.Comment
+}
+
+// RewriteAnnotated rewrites functions in packages pkgPaths annotated like below:
+//
+//	//ctxize:inject "ctx context.Context = context.TODO()"
+//	func F() { ... }
+//
+// The argument of the annotation is a var spec string quoted as a Go string;
+// if omitted, app.VarSpec is used.
+// All of pkgPaths and the packages of the annotated var specs must be loaded by Load()
+// beforehand. If no pkgPaths given, all loaded packages are scanned.
+func (app *App) RewriteAnnotated(pkgPaths ...string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	funcs, err := app.findAnnotatedFuncs(injectAnnotation, true, pkgPaths)
+	if err != nil {
+		return err
+	}
+
+	return app.rewriteAnnotatedFuncs(funcs)
+}
+
+// RewriteFromAnnotations is like RewriteAnnotated but reads directives like below,
+// whose argument is a var spec string not quoted:
+//
+//	//go:ctxize ctx context.Context = context.TODO()
+//	func F() { ... }
+//
+// The directives are removed from the output after rewriting.
+func (app *App) RewriteFromAnnotations(pkgPaths ...string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	funcs, err := app.findAnnotatedFuncs(ctxizeDirective, false, pkgPaths)
+	if err != nil {
+		return err
+	}
+
+	err = app.rewriteAnnotatedFuncs(funcs)
+	if err != nil {
+		return err
+	}
+
+	for _, f := range funcs {
+		app.removeComment(f.comment)
+	}
+
+	return nil
+}
+
+// rewriteAnnotatedFuncs rewrites funcs in order, each with its own var spec if any.
+func (app *App) rewriteAnnotatedFuncs(funcs []annotatedFunc) error {
+	defaultVarSpec := app.VarSpec
+	defer func() { app.VarSpec = defaultVarSpec }()
+
+	for _, f := range funcs {
+		if f.varSpec == nil {
+			app.VarSpec = defaultVarSpec
+		} else {
+			if err := app.resolveVarSpec(f.varSpec); err != nil {
+				return err
+			}
+			app.VarSpec = f.varSpec
+		}
+
+		if err := app.rewrite(f.funcSpec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeComment removes comment c from the file containing it.
+// The rest of the comment group is moved down, so that doc comments stay adjacent to declarations.
+func (app *App) removeComment(c *ast.Comment) {
+	file := app.markModified(c.Pos())
+	if file == nil {
+		return
+	}
+
+	groups := file.Comments[:0]
+	for _, cg := range file.Comments {
+		var texts []string
+		found := false
+		for _, x := range cg.List {
+			if x == c {
+				found = true
+			} else {
+				texts = append(texts, x.Text)
+			}
+		}
+		if !found {
+			groups = append(groups, cg)
+			continue
+		}
+
+		// trailing empty lines, which separated c from the doc
+		for len(texts) > 0 && texts[len(texts)-1] == "//" {
+			texts = texts[:len(texts)-1]
+		}
+
+		list := cg.List[len(cg.List)-len(texts):]
+		for i, text := range texts {
+			list[i].Text = text
+		}
+		cg.List = list
+		if len(cg.List) > 0 {
+			groups = append(groups, cg)
+		}
+	}
+	file.Comments = groups
+
+	ast.Inspect(file, func(n ast.Node) bool {
+		if funcDecl, ok := n.(*ast.FuncDecl); ok && funcDecl.Doc != nil && len(funcDecl.Doc.List) == 0 {
+			funcDecl.Doc = nil
+		}
+		return true
+	})
+}
+
+// findAnnotatedFuncs collects function declarations in pkgPaths which have
+// a doc comment line starting with annotation, whose argument is a var spec quoted if quoted is true.
+func (app *App) findAnnotatedFuncs(annotation string, quoted bool, pkgPaths []string) ([]annotatedFunc, error) {
+	var funcs []annotatedFunc
+	seen := map[string]bool{}
+
+	for _, pkg := range app.pkgs {
+		if len(pkgPaths) > 0 && !containsString(pkgPaths, pkg.PkgPath) {
+			continue
+		}
+
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				funcDecl, ok := decl.(*ast.FuncDecl)
+				if !ok || funcDecl.Doc == nil {
+					continue
+				}
+
+				for _, c := range funcDecl.Doc.List {
+					if c.Text != annotation && !strings.HasPrefix(c.Text, annotation+" ") {
+						continue
+					}
+
+					pos := app.position(c.Pos())
+					if seen[pos.String()] {
+						break
+					}
+					seen[pos.String()] = true
+
+					var varSpec *VarSpec
+					if arg := strings.TrimSpace(strings.TrimPrefix(c.Text, annotation)); arg != "" {
+						var err error
+						s := arg
+						if quoted {
+							s, err = strconv.Unquote(arg)
+							if err != nil {
+								return nil, xerrors.Errorf("%s: parsing annotation: %w", pos, err)
+							}
+						}
+						varSpec, err = ParseVarSpec(s)
+						if err != nil {
+							return nil, xerrors.Errorf("%s: %w", pos, err)
+						}
+					}
+
+					funcSpec := FuncSpec{
+						PkgPath:  pkg.PkgPath,
+						FuncName: funcDecl.Name.Name,
+					}
+					if f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func); ok {
+						if recv := f.Type().(*types.Signature).Recv(); recv != nil {
+							if named, ok := derefType(recv.Type()).(*types.Named); ok {
+								funcSpec.TypeName = named.Obj().Name()
+							}
+						}
+					}
+
+					debugf("%s: found annotation", pos)
+
+					funcs = append(funcs, annotatedFunc{funcSpec: funcSpec, varSpec: varSpec, comment: c})
+					break
+				}
+			}
+		}
+	}
+
+	return funcs, nil
+}
+
+func derefType(t types.Type) types.Type {
+	if p, ok := t.(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return t
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
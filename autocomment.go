@@ -0,0 +1,70 @@
+package ctxize
+
+import (
+	"fmt"
+	"sort"
+
+	"go/ast"
+	"go/token"
+)
+
+const autoComment = "// ctxize:auto"
+
+// annotateCallSite adds autoComment at the end of the line of callExpr in file,
+// unless the line already has one.
+func (app *App) annotateCallSite(file *ast.File, callExpr *ast.CallExpr) {
+	tf := app.Config.Fset.File(callExpr.Pos())
+	if tf == nil {
+		return
+	}
+
+	line := tf.Line(callExpr.End())
+	end := token.Pos(tf.Base() + tf.Size())
+	if line < tf.LineCount() {
+		end = tf.LineStart(line+1) - 1
+	}
+
+	for _, cg := range file.Comments {
+		for _, c := range cg.List {
+			if c.Text == autoComment && tf.Line(c.Pos()) == line {
+				return
+			}
+		}
+	}
+
+	file.Comments = append(file.Comments, &ast.CommentGroup{
+		List: []*ast.Comment{{Slash: end, Text: autoComment}},
+	})
+	sort.Slice(file.Comments, func(i, j int) bool {
+		return file.Comments[i].Pos() < file.Comments[j].Pos()
+	})
+}
+
+// commentSkippedCallSite adds a TODO comment with reason above the line of the call at pos,
+// which is skipped, for developers to find and fix it manually.
+func (app *App) commentSkippedCallSite(pos token.Pos, reason string) {
+	file := app.markModified(pos)
+	if file == nil {
+		return
+	}
+
+	tf := app.Config.Fset.File(pos)
+	line := tf.Line(pos)
+	text := fmt.Sprintf("// TODO(goctxize): add %s here (%s)", app.VarSpec.Name, reason)
+
+	for _, cg := range file.Comments {
+		for _, c := range cg.List {
+			if c.Text == text && tf.Line(c.Pos()) == line {
+				return
+			}
+		}
+	}
+
+	// the comment at the start of the line is printed on its own line before the statement
+	file.Comments = append(file.Comments, &ast.CommentGroup{
+		List: []*ast.Comment{{Slash: tf.LineStart(line), Text: text}},
+	})
+	sort.Slice(file.Comments, func(i, j int) bool {
+		return file.Comments[i].Pos() < file.Comments[j].Pos()
+	})
+}
@@ -0,0 +1,97 @@
+package ctxize
+
+import (
+	"sort"
+
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// RewriteAll rewrites the functions specified by specs as Rewrite does for each,
+// resolving their packages all at once beforehand.
+// Functions calling others in specs are rewritten after them, whatever the order of specs,
+// so that the variable declared in a caller by rewriting its callees is replaced by its parameter.
+func (app *App) RewriteAll(specs []FuncSpec) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	resolved := make([]FuncSpec, 0, len(specs))
+	for _, spec := range specs {
+		if spec.FuncNameRE != nil {
+			return xerrors.Errorf("func name patterns cannot be rewritten by RewriteAll: %s", spec.FuncNameRE)
+		}
+
+		var err error
+		spec.pkg, err = app.resolvePackage(spec.PkgPath)
+		if err != nil {
+			return err
+		}
+
+		if spec.Version != "" {
+			spec, err = app.resolveVersion(spec)
+			if err != nil {
+				return err
+			}
+		}
+
+		resolved = append(resolved, spec)
+	}
+
+	for _, spec := range app.calleesFirst(resolved) {
+		err := app.rewriteResolved(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// calleesFirst sorts specs so that the functions called by others come before them,
+// keeping the order of specs otherwise, eg. of mutually recursive functions.
+func (app *App) calleesFirst(specs []FuncSpec) []FuncSpec {
+	// indices of specs called by each spec
+	callees := make([][]int, len(specs))
+	for i, spec := range specs {
+		funcDecl, err := app.findFuncDecl(spec)
+		if err != nil {
+			// reported on rewriting
+			continue
+		}
+
+		for id, obj := range spec.pkg.TypesInfo.Uses {
+			f, ok := obj.(*types.Func)
+			if !ok || id.Pos() < funcDecl.Pos() || funcDecl.End() <= id.Pos() {
+				continue
+			}
+			for j, callee := range specs {
+				if j != i && callee.matches(f) {
+					callees[i] = append(callees[i], j)
+				}
+			}
+		}
+		sort.Ints(callees[i])
+	}
+
+	sorted := make([]FuncSpec, 0, len(specs))
+	visited := make([]bool, len(specs))
+	var visit func(i int)
+	visit = func(i int) {
+		if visited[i] {
+			return
+		}
+		visited[i] = true
+		for _, j := range callees[i] {
+			visit(j)
+		}
+		sorted = append(sorted, specs[i])
+	}
+	for i := range specs {
+		visit(i)
+	}
+
+	return sorted
+}
@@ -0,0 +1,210 @@
+package ctxize
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// BlameDatabaseFile is the name of the file at the top level of git repositories,
+// to which RewritePreservingGitBlame records the original authorship of the lines it changes.
+const BlameDatabaseFile = ".goctxize-blame.json"
+
+// BlameEntry is an entry of BlameDatabaseFile: a change made by rewriting and the commit
+// which last modified the line before the change. File is relative to the top level
+// of the repository.
+type BlameEntry struct {
+	Change
+	Commit     string `json:"commit"`
+	Author     string `json:"author"`
+	AuthorMail string `json:"authorMail"`
+	AuthorTime int64  `json:"authorTime"`
+}
+
+// blameLine is the authorship of a line reported by "git blame --porcelain".
+type blameLine struct {
+	commit, author, authorMail string
+	authorTime                 int64
+}
+
+// RewritePreservingGitBlame rewrites the function specified by spec as Rewrite does, and records
+// the authorship of the lines of the declaration and the call sites changed, as reported by
+// "git blame" before the files are written, to BlameDatabaseFile in the repository.
+// Once the rewrite is committed, "git blame" attributes the lines to that commit; the entries,
+// matched by their After fields, tell who wrote them originally.
+// Lines added by the rewrite, declaring the variable, and uncommitted lines are not recorded.
+func (app *App) RewritePreservingGitBlame(spec FuncSpec) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	n := len(app.changes)
+
+	err := app.rewrite(spec)
+	if err != nil {
+		return err
+	}
+
+	entries := map[string][]BlameEntry{} // by top level of repository
+	blames := map[string]map[int]blameLine{}
+	for _, change := range app.changes[n:] {
+		if change.Kind == ChangeStub {
+			continue
+		}
+
+		filename := change.File
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(app.Config.Dir, filename)
+		}
+
+		lines, ok := blames[filename]
+		if !ok {
+			lines, err = gitBlame(filename)
+			if err != nil {
+				return err
+			}
+			blames[filename] = lines
+		}
+
+		b, ok := lines[change.Line]
+		if !ok || strings.Trim(b.commit, "0") == "" {
+			continue
+		}
+
+		top, err := gitTopLevel(filepath.Dir(filename))
+		if err != nil {
+			return err
+		}
+
+		entry := BlameEntry{
+			Change:     change,
+			Commit:     b.commit,
+			Author:     b.author,
+			AuthorMail: b.authorMail,
+			AuthorTime: b.authorTime,
+		}
+		if rel, err := filepath.Rel(top, filename); err == nil {
+			entry.File = filepath.ToSlash(rel)
+		}
+		entries[top] = append(entries[top], entry)
+	}
+
+	if app.DryRun {
+		return nil
+	}
+
+	for top, ee := range entries {
+		err := appendBlameDatabase(filepath.Join(top, BlameDatabaseFile), ee)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// appendBlameDatabase appends entries to the database at filename, creating it if not exists.
+func appendBlameDatabase(filename string, entries []BlameEntry) error {
+	var existing []BlameEntry
+	b, err := os.ReadFile(filename)
+	if err == nil {
+		err = json.Unmarshal(b, &existing)
+		if err != nil {
+			return xerrors.Errorf("%s: %w", filename, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	b, err = json.MarshalIndent(append(existing, entries...), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append(b, '\n'), 0644)
+}
+
+// gitTopLevel returns the top level directory of the git repository containing dir.
+func gitTopLevel(dir string) (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", xerrors.Errorf("git rev-parse --show-toplevel in %s%s: %w", dir, exitErrorStderr(err), err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// gitBlame runs "git blame --porcelain" on filename and returns the authorship by line numbers.
+func gitBlame(filename string) (map[int]blameLine, error) {
+	cmd := exec.Command("git", "blame", "--porcelain", "--", filepath.Base(filename))
+	cmd.Dir = filepath.Dir(filename)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, xerrors.Errorf("git blame %s%s: %w", filename, exitErrorStderr(err), err)
+	}
+
+	lines := map[int]blameLine{}
+	commits := map[string]*blameLine{}
+
+	var current *blameLine
+	var lineNum int
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		text := s.Text()
+
+		// the content of the line, which ends the entry
+		if strings.HasPrefix(text, "\t") {
+			if current != nil {
+				lines[lineNum] = *current
+			}
+			continue
+		}
+
+		// "<commit> <original line> <final line> [<lines in group>]" starts an entry,
+		// followed by "<key> <value>" headers for the first entry of each commit
+		fields := strings.Fields(text)
+		if len(fields) >= 3 && len(fields[0]) >= 40 && strings.Trim(fields[0], "0123456789abcdef") == "" {
+			lineNum, _ = strconv.Atoi(fields[2])
+			current = commits[fields[0]]
+			if current == nil {
+				current = &blameLine{commit: fields[0]}
+				commits[fields[0]] = current
+			}
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+		key, value, _ := strings.Cut(text, " ")
+		switch key {
+		case "author":
+			current.author = value
+		case "author-mail":
+			current.authorMail = strings.Trim(value, "<>")
+		case "author-time":
+			current.authorTime, _ = strconv.ParseInt(value, 10, 64)
+		}
+	}
+
+	return lines, s.Err()
+}
+
+// exitErrorStderr returns the standard error output of the command failed with err, if any,
+// to be appended to error messages.
+func exitErrorStderr(err error) string {
+	if ee, ok := err.(*exec.ExitError); ok && len(ee.Stderr) > 0 {
+		return ": " + strings.TrimSpace(string(ee.Stderr))
+	}
+	return ""
+}
@@ -0,0 +1,75 @@
+package ctxize
+
+import (
+	"fmt"
+	"strings"
+
+	"go/token"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// PluginABIChangeError is returned when the function to be rewritten is an exported
+// one of a package built as a plugin, unless App.AcknowledgePluginABIChange is set.
+// Hosts looking up the symbol by plugin.Lookup and asserting its type fail after
+// the rewrite, until they are updated and rebuilt along with the plugin.
+type PluginABIChangeError struct {
+	PkgPath  string
+	FuncName string
+}
+
+func (e *PluginABIChangeError) Error() string {
+	return fmt.Sprintf(
+		"%s is built as a plugin and rewriting %s changes its ABI; set AcknowledgePluginABIChange to proceed",
+		e.PkgPath, e.FuncName,
+	)
+}
+
+// checkPluginABI returns PluginABIChangeError if the function specified by spec
+// is exported by a plugin package and the change is not acknowledged.
+func (app *App) checkPluginABI(spec FuncSpec) error {
+	if app.AcknowledgePluginABIChange || !token.IsExported(spec.FuncName) || spec.TypeName != "" && !token.IsExported(spec.TypeName) || !app.isPluginPackage(spec.pkg) {
+		return nil
+	}
+
+	return &PluginABIChangeError{PkgPath: spec.PkgPath, FuncName: spec.String()}
+}
+
+// isPluginPackage reports whether pkg is built with -buildmode=plugin, as told by
+// app.Config.BuildFlags or by go:generate directives in its files.
+// Plugins must be main packages.
+func (app *App) isPluginPackage(pkg *packages.Package) bool {
+	if pkg.Name != "main" {
+		return false
+	}
+
+	if hasPluginBuildMode(app.Config.BuildFlags) {
+		return true
+	}
+
+	for _, file := range pkg.Syntax {
+		for _, cg := range file.Comments {
+			for _, c := range cg.List {
+				if !strings.HasPrefix(c.Text, "//go:generate ") {
+					continue
+				}
+				if hasPluginBuildMode(strings.Fields(c.Text)) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// hasPluginBuildMode reports whether args have "-buildmode=plugin" or "-buildmode plugin".
+func hasPluginBuildMode(args []string) bool {
+	for i, arg := range args {
+		arg = "-" + strings.TrimLeft(arg, "-")
+		if arg == "-buildmode=plugin" || arg == "-buildmode" && i+1 < len(args) && args[i+1] == "plugin" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package ctxize
+
+import (
+	"bytes"
+	"sort"
+
+	"go/ast"
+	"go/format"
+	"go/token"
+)
+
+// Change describes a change made by rewriting, for reporting.
+type Change struct {
+	File   string `json:"file"`
+	Kind   string `json:"kind"` // one of ChangeDecl, ChangeCall and ChangeStub
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// Kinds of Change.
+const (
+	ChangeDecl = "decl" // the variable is added to the parameters of a function
+	ChangeCall = "call" // the variable is passed to a call
+	ChangeStub = "stub" // the variable is declared in a caller function
+)
+
+// Changes returns the changes made so far, in order.
+func (app *App) Changes() []Change {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return append([]Change(nil), app.changes...)
+}
+
+// changedFiles returns the files, sorted, of the changes made after the first n,
+// and of the files generated or updated since generated, a copy of app.generated.
+func (app *App) changedFiles(n int, generated map[string][]byte) []string {
+	seen := map[string]bool{}
+	for _, c := range app.changes[n:] {
+		seen[c.File] = true
+	}
+	for filename, content := range app.generated {
+		if old, ok := generated[filename]; !ok || !bytes.Equal(old, content) {
+			seen[filename] = true
+		}
+	}
+
+	filenames := make([]string, 0, len(seen))
+	for filename := range seen {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	return filenames
+}
+
+// recordChange records a change of kind at pos, with the source before and after the change.
+func (app *App) recordChange(kind string, pos token.Pos, before, after string) {
+	p := app.position(pos)
+	change := Change{
+		File:   p.Filename,
+		Kind:   kind,
+		Line:   p.Line,
+		Column: p.Column,
+		Before: before,
+		After:  after,
+	}
+	app.changes = append(app.changes, change)
+	app.reportChange(change)
+}
+
+// nodeString formats node as Go source, or returns "" on failure.
+func (app *App) nodeString(node ast.Node) string {
+	if node == nil {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, app.Config.Fset, node); err != nil {
+		debugf("BUG: formatting %T: %s", node, err)
+		return ""
+	}
+	return buf.String()
+}
+
+// funcSignature returns a copy of funcDecl without its body and doc, to be formatted.
+func funcSignature(funcDecl *ast.FuncDecl) *ast.FuncDecl {
+	return &ast.FuncDecl{
+		Recv: funcDecl.Recv,
+		Name: funcDecl.Name,
+		Type: funcDecl.Type,
+	}
+}
@@ -0,0 +1,69 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// channelContext finds a variable received from a channel before pos,
+// whose field named app.ChannelContextField satisfies the variable type,
+// and returns the selector expression of the field eg. "msg.Ctx".
+func (app *App) channelContext(pkg *packages.Package, pos token.Pos) ast.Expr {
+	if app.ChannelContextField == "" {
+		return nil
+	}
+
+	iface, ok := app.VarSpec.varType().Underlying().(*types.Interface)
+	if !ok {
+		return nil
+	}
+
+	for scope := pkg.Types.Scope().Innermost(pos); scope != nil && scope != pkg.Types.Scope(); scope = scope.Parent() {
+		for _, name := range scope.Names() {
+			v, ok := scope.Lookup(name).(*types.Var)
+			if !ok || !v.Pos().IsValid() || v.Pos() > pos || !app.isReceivedFromChan(pkg.TypesInfo, v) {
+				continue
+			}
+
+			obj, _, _ := types.LookupFieldOrMethod(v.Type(), true, v.Pkg(), app.ChannelContextField)
+			if field, ok := obj.(*types.Var); ok && field.IsField() && types.Implements(field.Type(), iface) && app.VarSpec.validate(field) {
+				return &ast.SelectorExpr{
+					X:   ast.NewIdent(name),
+					Sel: ast.NewIdent(field.Name()),
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// isReceivedFromChan reports whether v is defined by receiving from a channel,
+// either by "v := <-ch" or "for v := range ch".
+func (app *App) isReceivedFromChan(info *types.Info, v *types.Var) bool {
+	path := app.pathEnclosing(v.Pos())
+	if len(path) < 2 {
+		return false
+	}
+
+	switch stmt := path[1].(type) {
+	case *ast.AssignStmt:
+		if len(stmt.Rhs) != 1 || len(stmt.Lhs) == 0 || stmt.Lhs[0] != path[0] {
+			return false
+		}
+		unary, ok := stmt.Rhs[0].(*ast.UnaryExpr)
+		return ok && unary.Op == token.ARROW
+
+	case *ast.RangeStmt:
+		if stmt.Key != path[0] {
+			return false
+		}
+		_, ok := info.TypeOf(stmt.X).Underlying().(*types.Chan)
+		return ok
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package ctxize
+
+import (
+	"path/filepath"
+
+	"go/token"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// Clone returns a copy of app which can be rewritten independently of app,
+// eg. in another goroutine. As the AST and type information cannot be shared,
+// the packages are loaded again, with the current contents of the files modified
+// or generated in app given as an overlay.
+// Load must have been called on app beforehand.
+func (app *App) Clone() (*App, error) {
+	conf := *app.Config // copy
+	conf.Fset = token.NewFileSet()
+	conf.Overlay = map[string][]byte{}
+	for filename, content := range app.Config.Overlay {
+		conf.Overlay[filename] = content
+	}
+
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	// plugins are run by Each of the clone
+	err := app.each(func(filename string, content []byte) error {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(app.Config.Dir, filename)
+		}
+		conf.Overlay[filename] = content
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	clone := app.withOptions(&conf)
+
+	err = clone.Load(app.pkgPaths...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range clone.pkgs {
+		for _, file := range pkg.Syntax {
+			if _, ok := conf.Overlay[conf.Fset.Position(file.Pos()).Filename]; ok {
+				clone.modified[file] = true
+			}
+		}
+	}
+	for name := range app.rewritten {
+		clone.rewritten[name] = true
+	}
+	for _, spec := range app.rewrittenSpecs {
+		spec.pkg, err = clone.resolvePackage(spec.PkgPath)
+		if err != nil {
+			return nil, err
+		}
+		clone.rewrittenSpecs = append(clone.rewrittenSpecs, spec)
+	}
+	clone.plugins = append([]namedPlugin(nil), app.plugins...)
+	clone.reporter = app.reporter
+	clone.changes = append([]Change(nil), app.changes...)
+
+	return clone, nil
+}
+
+// withOptions returns a new App, not loaded yet, with conf and the options of app.
+func (app *App) withOptions(conf *packages.Config) *App {
+	varSpec := *app.VarSpec // copy
+
+	return &App{
+		Config:                     conf,
+		VarSpec:                    &varSpec,
+		Exclude:                    append([]string(nil), app.Exclude...),
+		ExcludePackagePatterns:     append([]string(nil), app.ExcludePackagePatterns...),
+		ChannelContextField:        app.ChannelContextField,
+		NoStub:                     app.NoStub,
+		InferContext:               app.InferContext,
+		AnnotateCallSites:          app.AnnotateCallSites,
+		PreCheckers:                app.PreCheckers,
+		RewriteComment:             app.RewriteComment,
+		ConflictResolver:           app.ConflictResolver,
+		RewritePreservingComments:  app.RewritePreservingComments,
+		AcknowledgePluginABIChange: app.AcknowledgePluginABIChange,
+		RewriteWithShadowCheck:     app.RewriteWithShadowCheck,
+		RewriteNoImport:            app.RewriteNoImport,
+		TemplateFiles:              append([]string(nil), app.TemplateFiles...),
+		ExcludeExternalTests:       app.ExcludeExternalTests,
+		SQLCMode:                   app.SQLCMode,
+		WireMode:                   app.WireMode,
+		DryRun:                     app.DryRun,
+		DiffMode:                   app.DiffMode,
+		NamedArgMode:               app.NamedArgMode,
+		BeforeRewrite:              app.BeforeRewrite,
+		AfterRewrite:               app.AfterRewrite,
+		PropagateToInterfaces:      app.PropagateToInterfaces,
+		RewriteCompatMode:          app.RewriteCompatMode,
+	}
+}
@@ -0,0 +1,44 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// outermostFuncLit returns the outermost function literal enclosing pos if pos is
+// not in any function declaration, eg. in an initializer of a package-level variable.
+func (app *App) outermostFuncLit(pos token.Pos) *ast.FuncLit {
+	var lit *ast.FuncLit
+	for _, node := range app.pathEnclosing(pos) {
+		switch node := node.(type) {
+		case *ast.FuncDecl:
+			return nil
+		case *ast.FuncLit:
+			lit = node
+		}
+	}
+
+	return lit
+}
+
+// rewriteClosureCallSite rewrites the call at pos inside nested function literals
+// outside of function declarations, passing the variable found in any of the
+// enclosing literals. As there is no function body to declare a stub in,
+// it is an error if none is found.
+func (app *App) rewriteClosureCallSite(pkg *packages.Package, lit *ast.FuncLit, pos token.Pos) error {
+	scope := pkg.TypesInfo.Scopes[lit.Type]
+	if scope == nil {
+		return xerrors.Errorf("%s: BUG: no Scope found", app.Config.Fset.Position(pos))
+	}
+
+	if app.existingVarExpr(pkg, scope, pos) == nil {
+		p := app.position(pos)
+		return &NoContextInScopeError{Filename: p.Filename, Line: p.Line}
+	}
+
+	_, err := app.rewriteCallExpr(pkg, scope, pos)
+	return err
+}
@@ -1,16 +1,51 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 
 	"github.com/motemen/go-ctxize"
 )
 
-// goctxize [-var "ctx context.Context = context.TODO()"] path/to/pkg[.Type].Func [<pkg>...]
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// expandResponseFiles replaces arguments of form "@<file>" with
+// the whitespace-separated contents of the file.
+func expandResponseFiles(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(arg[1:])
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, strings.Fields(string(content))...)
+	}
+
+	return expanded, nil
+}
+
+// goctxize [-var "ctx context.Context = context.TODO()"] [-exclude file.go:line] [-exclude-pattern pkg/...] [-no-stub] [-shim] [-report-only changes.json] [-check] path/to/pkg[.Type].Func [<pkg>...]
+// goctxize [-var "ctx context.Context = context.TODO()"] [-exclude file.go:line] [-exclude-pattern pkg/...] [-no-stub] [-report-only changes.json] -from-stdin [<pkg>...]
 func main() {
 	log.SetPrefix("goctxize: ")
 	log.SetFlags(0)
@@ -20,41 +55,160 @@ func main() {
 		"ctx context.Context = context.TODO()",
 		`inserted variable spec; must be in form of "<name> <path>.<type> = <expr>"`,
 	)
+	noStub := flag.Bool("no-stub", false, "fail on call sites without any variable to pass, instead of declaring one")
+	shim := flag.Bool("shim", false, "rename the rewritten function to <name>WithContext and generate a deprecated shim of the original name")
+	fromStdin := flag.Bool("from-stdin", false, "read target funcs from stdin, one per line; arguments are taken as packages of callers")
+	inferContext := flag.Bool("experimental-infer-context", false, "find the variable to pass by data-flow analysis, including local ones")
+	dryRun := flag.Bool("n", false, "print the files to be changed with the numbers of lines added and deleted, without modifying them")
+	diff := flag.Bool("diff", false, "print unified diffs of the changes, to be applied by patch -p1, without modifying source files")
+	preview := flag.Bool("preview", false, "print side-by-side comparisons of the changes, fitted to $COLUMNS, without modifying source files")
+	reportOnly := flag.String("report-only", "", "write changes to the file as JSON, or as a Markdown table if it ends with .md, without modifying source files")
+	annotate := flag.Bool("annotate", false, `mark rewritten call sites with "// ctxize:auto" comments`)
+	check := flag.Bool("check", false, "exit with status 0 if the function already takes the variable, or 1 otherwise, without rewriting")
+	sqlc := flag.Bool("sqlc", false, "warn about changes made in files generated by sqlc, which are lost on regeneration")
+	wire := flag.Bool("wire", false, `add the variable to Wire injectors building the function, and run "wire gen" for them`)
+	namedArgs := flag.Bool("named-args", false, "replace the parameters of the function with a struct generated of them, to be passed by names as F(FArgs{Ctx: ctx, X: x})")
+	ackPlugin := flag.Bool("acknowledge-plugin-abi-change", false, "rewrite exported functions of packages built with -buildmode=plugin, whose ABI changes")
+	var excludes stringsFlag
+	flag.Var(&excludes, "exclude", `call site to leave untouched, in form of "<file>:<line>"; can be specified multiple times`)
+	var excludePatterns stringsFlag
+	flag.Var(&excludePatterns, "exclude-pattern", `pattern of packages whose call sites are left untouched, eg. "example.com/legacy/..."; can be specified multiple times`)
+	var templates stringsFlag
+	flag.Var(&templates, "template", "file of template generating Go code, whose calls are rewritten by text replacement; can be specified multiple times")
 	flag.Usage = func() {
 		fmt.Fprintln(flag.CommandLine.Output(), "usage: goctxize [flags] path/to/pkg[.Type].Func [<pkg>...]")
+		fmt.Fprintln(flag.CommandLine.Output(), "       goctxize [flags] -from-stdin [<pkg>...]")
 		flag.PrintDefaults()
 	}
-	flag.Parse()
+	args, err := expandResponseFiles(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	flag.CommandLine.Parse(args)
 
 	varSpec, err := ctxize.ParseVarSpec(*varSpecString)
 	if err != nil {
 		log.Fatalf("parsing -var: %s", err)
 	}
 
-	args := flag.Args()
+	args = flag.Args()
 
-	if len(args) == 0 {
-		flag.Usage()
-		os.Exit(2)
+	app := ctxize.App{
+		VarSpec:                    varSpec,
+		Exclude:                    excludes,
+		ExcludePackagePatterns:     excludePatterns,
+		NoStub:                     *noStub,
+		InferContext:               *inferContext,
+		AnnotateCallSites:          *annotate,
+		AcknowledgePluginABIChange: *ackPlugin,
+		TemplateFiles:              templates,
+		SQLCMode:                   *sqlc,
+		WireMode:                   *wire,
+		DryRun:                     *dryRun,
+		DiffMode:                   *diff,
+		NamedArgMode:               *namedArgs,
+		RewriteCompatMode:          *shim,
 	}
 
-	spec, err := ctxize.ParseFuncSpec(args[0])
-	if err != nil {
-		log.Fatal(err)
+	if *fromStdin {
+		if *check {
+			log.Fatal("-check cannot be used with -from-stdin")
+		}
+
+		err = app.RewriteFromReader(os.Stdin, args)
+		if err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		if len(args) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		spec, err := ctxize.ParseFuncSpec(args[0])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = app.Load(append([]string{spec.PkgPath}, args[1:]...)...)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if *check {
+			ok, err := app.IsRewritten(spec)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if !ok {
+				os.Exit(1)
+			}
+			return
+		}
+
+		err = app.Rewrite(spec)
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	app := ctxize.App{
-		VarSpec: varSpec,
+	if app.DryRun {
+		changes, err := app.FileChanges()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, c := range changes {
+			added, deleted := c.LineCounts()
+			fmt.Printf("%s: +%d -%d\n", c.Filename, added, deleted)
+		}
+		fmt.Printf("%d files changed\n", len(changes))
+		return
 	}
 
-	err = app.Load(append([]string{spec.PkgPath}, args[1:]...)...)
-	if err != nil {
-		log.Fatal(err)
+	if app.DiffMode {
+		err = app.Each(func(filename string, diff []byte) error {
+			_, err := os.Stdout.Write(diff)
+			return err
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
 	}
 
-	err = app.Rewrite(spec)
-	if err != nil {
-		log.Fatal(err)
+	if *preview {
+		width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+		if err != nil {
+			width = 160
+		}
+
+		out, err := app.PreviewSideBySide(width)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Print(out)
+		return
+	}
+
+	if *reportOnly != "" {
+		var report []byte
+		if strings.HasSuffix(*reportOnly, ".md") {
+			report = []byte(strings.TrimSuffix(app.RewriteReport().ToMarkdown(), "\n"))
+		} else {
+			report, err = json.MarshalIndent(app.Changes(), "", "  ")
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		err = ioutil.WriteFile(*reportOnly, append(report, '\n'), 0666)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		return
 	}
 
 	err = app.Each(func(filename string, content []byte) error {
@@ -63,4 +217,16 @@ func main() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	err = app.RegenerateWire()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, w := range app.TemplateWarnings() {
+		log.Printf("%s: %s: %s", w.Pos, w.Severity, w.Message)
+	}
+	for _, w := range app.SQLCWarnings() {
+		log.Printf("%s: %s: %s", w.Pos, w.Severity, w.Message)
+	}
 }
@@ -0,0 +1,63 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/xerrors"
+)
+
+// resolveStubConflict updates the declaration of the variable added to funcDecl by a previous rewrite
+// with the expression returned by app.ConflictResolver, if the declaration disagrees with
+// the one to be added for the call at pos.
+func (app *App) resolveStubConflict(funcDecl *ast.FuncDecl, pos token.Pos) error {
+	assign, ok := app.stubs[funcDecl]
+	if !ok || app.ConflictResolver == nil {
+		return nil
+	}
+
+	existing := app.nodeString(assign.Rhs[0])
+
+	init, _ := app.stubInitExpr(funcDecl)
+	expr, err := parser.ParseExpr(init)
+	if err != nil {
+		return xerrors.Errorf("parsing %q: %w", init, err)
+	}
+	stub := app.nodeString(expr)
+	if stub == existing {
+		return nil
+	}
+
+	resolved := app.ConflictResolver(existing, stub)
+	debugf("%s: stub conflict: %s vs %s; resolved to %s", app.position(funcDecl.Pos()), existing, stub, resolved)
+	if resolved == existing {
+		return nil
+	}
+
+	varSpec := *app.VarSpec
+	varSpec.InitExpr, varSpec.InitExprForBackground, varSpec.ResultExpr = resolved, "", ""
+	initPkgPaths, err := app.resolveInitPkgPaths(&varSpec)
+	if err != nil {
+		return err
+	}
+
+	expr, err = parser.ParseExpr(resolved)
+	if err != nil {
+		return xerrors.Errorf("parsing %q: %w", resolved, err)
+	}
+	clearPos(expr)
+
+	before := app.nodeString(assign)
+	assign.Rhs[0] = expr
+	app.recordChange(ChangeStub, funcDecl.Body.Lbrace, before, app.nodeString(assign))
+
+	if file := app.markModified(pos); file != nil && !app.RewriteNoImport {
+		for _, path := range initPkgPaths {
+			astutil.AddImport(app.Config.Fset, file, path)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package ctxize
+
+import (
+	"go/token"
+	"go/types"
+)
+
+// rewriteConstraints rewrites the methods of named interfaces used as type constraints
+// in the loaded packages, which are the same as the method specified by spec and
+// satisfied by its receiver type, along with calls of them on values of type parameters.
+func (app *App) rewriteConstraints(spec FuncSpec) error {
+	if spec.TypeName == "" {
+		return nil
+	}
+
+	var method *types.Func
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			method = f
+			break
+		}
+	}
+	if method == nil {
+		return nil
+	}
+	recv := method.Type().(*types.Signature).Recv().Type()
+	if types.IsInterface(recv) {
+		return nil
+	}
+
+	var specs []FuncSpec
+	seen := map[token.Pos]bool{}
+	for _, pkg := range app.pkgs {
+		for _, obj := range pkg.TypesInfo.Defs {
+			tn, ok := obj.(*types.TypeName)
+			if !ok {
+				continue
+			}
+			tp, ok := tn.Type().(*types.TypeParam)
+			if !ok {
+				continue
+			}
+			named, ok := tp.Constraint().(*types.Named)
+			if !ok || named.Obj().Pkg() == nil || seen[named.Obj().Pos()] {
+				continue
+			}
+			seen[named.Obj().Pos()] = true
+
+			iface, ok := named.Underlying().(*types.Interface)
+			if !ok || !constrainsMethod(iface, method) {
+				continue
+			}
+			if !types.Satisfies(recv, iface) && !types.Satisfies(types.NewPointer(recv), iface) {
+				continue
+			}
+
+			specs = append(specs, FuncSpec{
+				PkgPath:  named.Obj().Pkg().Path(),
+				TypeName: named.Obj().Name(),
+				FuncName: method.Name(),
+			})
+		}
+	}
+
+	for _, s := range specs {
+		var err error
+		s.pkg, err = app.resolvePackage(s.PkgPath)
+		if err != nil {
+			debugf("%s: skipping constraint: %s", s, err)
+			continue
+		}
+
+		debugf("%s: rewriting constraint of %s", s, spec)
+		if err := app.rewriteResolved(s); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// constrainsMethod reports whether iface explicitly declares a method of the same
+// name and signature as method.
+func constrainsMethod(iface *types.Interface, method *types.Func) bool {
+	for i := 0; i < iface.NumExplicitMethods(); i++ {
+		m := iface.ExplicitMethod(i)
+		if m.Name() == method.Name() && types.Identical(m.Type(), method.Type()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,97 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/types"
+)
+
+// LintCoverage reports the fraction of functions in packages pkgPaths which
+// already accept the variable, among those calling any function accepting it.
+// A function is said to accept the variable if any of its parameters is of
+// the type specified by VarSpec.
+// If no pkgPaths given, the packages given to Load are scanned. If no function
+// calls such functions, the coverage is 1.
+func (app *App) LintCoverage(pkgPaths ...string) (float64, error) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var total, covered int
+	seen := map[string]bool{}
+
+	for _, pkg := range app.pkgs {
+		if !app.isScanned(pkg, pkgPaths) {
+			continue
+		}
+
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				funcDecl, ok := decl.(*ast.FuncDecl)
+				if !ok || funcDecl.Body == nil {
+					continue
+				}
+
+				// test variants of packages share the same files
+				pos := app.position(funcDecl.Pos())
+				if seen[pos.String()] {
+					continue
+				}
+				seen[pos.String()] = true
+
+				if !app.callsAccepting(pkg.TypesInfo, funcDecl.Body) {
+					continue
+				}
+
+				total++
+
+				f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+				if ok && app.accepts(f.Type().(*types.Signature)) {
+					covered++
+				} else {
+					debugf("%s: func %s does not accept %s", pos, funcDecl.Name.Name, app.VarSpec.Name)
+				}
+			}
+		}
+	}
+
+	if total == 0 {
+		return 1, nil
+	}
+
+	return float64(covered) / float64(total), nil
+}
+
+// callsAccepting reports whether node contains any call to a function accepting the variable.
+func (app *App) callsAccepting(info *types.Info, node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		if found {
+			return false
+		}
+
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+		if tv, ok := info.Types[call.Fun]; ok && !tv.IsType() {
+			if sig, ok := tv.Type.Underlying().(*types.Signature); ok && app.accepts(sig) {
+				found = true
+			}
+		}
+
+		return !found
+	})
+
+	return found
+}
+
+// accepts reports whether sig has a parameter of the variable type.
+func (app *App) accepts(sig *types.Signature) bool {
+	params := sig.Params()
+	for i := 0; i < params.Len(); i++ {
+		if types.Identical(params.At(i).Type(), app.VarSpec.varType()) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -6,10 +6,13 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 
 	"go/ast"
 	"go/format"
@@ -17,6 +20,7 @@ import (
 	"go/token"
 	"go/types"
 
+	"golang.org/x/tools/go/analysis"
 	"golang.org/x/tools/go/ast/astutil"
 	"golang.org/x/tools/go/packages"
 	"golang.org/x/xerrors"
@@ -31,26 +35,217 @@ type VarSpec struct {
 	PkgPath string
 	// name of the type of the variable eg "Context"
 	TypeName string
+	// if set, the variable is a pointer to the type, eg. "c *gin.Context"
+	Pointer bool
+	// position of the parameter added to functions and the argument added to calls:
+	// 0 to insert it first, -1 to append it last, or N to insert it after the Nth parameter
+	Position int
+	// if set, the parameter added to functions is "done <-chan struct{}" instead of the variable,
+	// and callers pass "<name>.Done()" of the variable found or declared, eg. "ctx.Done()"
+	ChanType bool
+	// type arguments to instantiate TypeName with, if it is generic,
+	// eg. types.Typ[types.Int] for "Tracer[int]". Named types must be
+	// of the packages loaded by App, and be importable where the variable is declared.
+	TypeArgs []types.Type
 	// initialization expression of the variable on the caller side
 	InitExpr string
+	// name of the secondary result of InitExpr, if any, eg. "span"
+	// for InitExpr "trace.Start(context.Background(), "op")" which returns (ctx, span)
+	ResultName string
+	// expression deferred after the initialization, eg. "span.End()"
+	ResultExpr string
+	// if set, initialization expression used instead of InitExpr in callers launched
+	// as goroutines, which may outlive the variable of their launchers,
+	// eg. "context.Background()"
+	InitExprForBackground string
+	// if set, the type of parameters of callers, in form of "<path>.<type>", eg. "net/http.Request",
+	// whose method FactoryMethod, eg. "Context", initializes the variable instead of InitExpr,
+	// eg. "r.Context()" for callers having "r *http.Request"
+	FactoryReceiver string
+	FactoryMethod   string
+	// if non-nil, only existing variables for which Validator returns true
+	// are passed, eg. to accept only parameters or variables named "ctx"
+	Validator func(types.Object) bool
 
 	// resolved package information pointed by PkgPath
 	pkg *packages.Package
 
 	// type object of PkgPath.TypeName
 	varTypeObj types.Object
+
+	// PkgPath.TypeName instantiated with TypeArgs, if any
+	instType types.Type
+
+	// import paths of packages referred to by InitExpr, InitExprForBackground and ResultExpr
+	initPkgPaths []string
 }
 
 // App is an entry point of go-ctxize
 type App struct {
-	Config   *packages.Config
-	VarSpec  *VarSpec
+	Config  *packages.Config
+	VarSpec *VarSpec
+	// Exclude is a list of call sites, in form of "<filename>:<line>", which
+	// are left untouched even if they call the function being rewritten.
+	Exclude []string
+	// ExcludePackagePatterns is a list of patterns of import paths, matched by path.Match,
+	// of packages whose call sites are left untouched. A pattern ending with "/..."
+	// matches to the package and its subpackages, eg. "example.com/legacy/...".
+	ExcludePackagePatterns []string
+	// ChannelContextField, if set, is the name of the field of values received from channels
+	// which can be passed as the variable, eg. "Ctx" for "msg := <-ch; F(msg.Ctx)".
+	ChannelContextField string
+	// NoStub makes Rewrite fail with NoContextInScopeError for call sites
+	// without any variable to pass, instead of declaring one by InitExpr.
+	NoStub bool
+	// InferContext enables experimental data-flow analysis, instead of the lookup in
+	// the function scope, to find the variable most recently assigned on all paths
+	// to each call site.
+	InferContext bool
+	// AnnotateCallSites makes rewritten call sites marked by "// ctxize:auto" comments
+	// at the end of their lines, for reviewers to find them.
+	AnnotateCallSites bool
+	// PreCheckers are analyzers run before rewriting each function on the packages to be modified.
+	// If any of them reports diagnostics in the files to be modified, the rewrite fails
+	// with PreCheckFailedError.
+	PreCheckers []*analysis.Analyzer
+	// RewriteComment makes call sites skipped, because they are excluded or there is no variable
+	// to pass with NoStub set, marked by "// TODO(goctxize): add ctx here" comments with the reasons,
+	// instead of left silently or failing.
+	RewriteComment bool
+	// ConflictResolver, if set, is called when the variable is to be declared in a function
+	// where it is already declared by a previous rewrite with another initialization expression,
+	// eg. after VarSpec is changed, with the existing one and the new one, eg. "context.TODO()"
+	// and "tracer.NewContext()". The declaration is updated to the expression returned.
+	// If not set, the existing declaration is kept.
+	ConflictResolver func(existingStub, newStub string) string
+	// BeforeRewrite, if set, is called with each function to be rewritten before any changes are made,
+	// eg. to check if the function is still on the migration list. If it returns an error,
+	// the function and its callers are left untouched, and the rewrite goes on.
+	BeforeRewrite func(spec FuncSpec) error
+	// AfterRewrite, if set, is called with each function rewritten successfully and the files,
+	// relative to Config.Dir, modified or generated by the rewrite, eg. to run linters on them.
+	// It is called once the rewrite is done, so it may call Each to see the contents.
+	AfterRewrite func(spec FuncSpec, modified []string)
+	// PropagateToInterfaces makes Rewrite of a method also rewrite the methods of the same name
+	// of interface types in the loaded packages which the receiver type implements, along with
+	// their callers, so that the type keeps implementing them. Other implementations are not rewritten.
+	PropagateToInterfaces bool
+	// RewriteCompatMode makes Rewrite keep the functions rewritten compatible for callers outside
+	// the loaded packages, by renaming them to "<name>WithContext" along with the callers rewritten
+	// and generating deprecated shims of the original names, as GenerateShim does.
+	RewriteCompatMode bool
+	// RewritePreservingComments makes the variable inserted to call sites positioned
+	// right after the opening parenthesis or the preceding argument, so that comments
+	// and line breaks around the arguments, eg. "F(\n\t1, // x\n)", stay with the arguments
+	// they belong to.
+	RewritePreservingComments bool
+	// AcknowledgePluginABIChange allows rewriting exported functions of packages built
+	// as plugins, which Rewrite refuses with PluginABIChangeError otherwise.
+	AcknowledgePluginABIChange bool
+	// RewriteWithShadowCheck makes Each fail with ShadowError, before visiting any files,
+	// if the variable declared or passed by the rewrite shadows or is shadowed by another
+	// variable of the same name in the rewritten files, eg. "ctx" declared in a block.
+	RewriteWithShadowCheck bool
+	// RewriteNoImport skips adding imports of the variable type and the packages referred to
+	// by its initialization, relying on the existing imports of the files modified, eg. in codebases
+	// where all packages already import "context".
+	RewriteNoImport bool
+	// TemplateFiles are files of templates generating Go code, eg. for text/template,
+	// in which calls to the functions rewritten are rewritten by text replacement as well,
+	// on a best-effort basis. The rewritten templates are visited by Each, and the calls are
+	// reported by TemplateWarnings.
+	TemplateFiles []string
+	// ExcludeExternalTests makes Load leave out external test packages, eg. "foo_test",
+	// which are loaded and rewritten by default. Callers in them declare the variable
+	// by "context.Background()" if it is context.Context, as tests are the roots of calls.
+	ExcludeExternalTests bool
+	// SQLCMode enables SQLCWarnings, which reports the changes made in files generated by sqlc.
+	// Such files are rewritten as any other files.
+	SQLCMode bool
+	// WireMode makes the variable added to the parameters of Wire injectors, in files constrained
+	// by "wireinject", which build the functions rewritten directly or by provider sets.
+	// RegenerateWire runs "wire gen" for them after the files are written.
+	WireMode bool
+	// DryRun makes App write no files by itself: RewritePreservingGitBlame does not update
+	// the database and RegenerateWire does nothing. Each visits the contents as usual,
+	// which callers should not write either, but may show by FileChanges.
+	DryRun bool
+	// DiffMode makes Each visit unified diffs, with "--- a/<file>" and "+++ b/<file>" headers,
+	// from the original contents of the files to the new ones, instead of the new contents,
+	// eg. to be applied by patch -p1 or ApplyPatch.
+	DiffMode bool
+	// NamedArgMode makes Rewrite replace the parameters of the function, along with the variable,
+	// with a struct type generated of them, named "<func>Args" ("<type><method>Args" for methods),
+	// so that the callers pass them by their names as "pkg.F(pkg.FArgs{Ctx: ctx, X: x})".
+	NamedArgMode bool
+
 	modified map[*ast.File]bool
-	pkgs     []*packages.Package
+	// contents of files newly generated, keyed by filename
+	generated map[string][]byte
+	pkgs      []*packages.Package
+	// package patterns given to Load
+	pkgPaths []string
+
+	// qualified names of functions already rewritten
+	rewritten map[string]bool
+	// functions rewritten, in order
+	rewrittenSpecs []FuncSpec
+	// qualified names of functions whose declarations are rewritten, possibly
+	// along with only some of their callers by RewritePartial
+	declRewritten map[string]bool
+	// positions of the functions called in the calls rewritten
+	rewrittenCalls map[token.Pos]bool
+
+	// plugins registered by RegisterPlugin, in order
+	plugins []namedPlugin
+
+	// channel registered by RewriteReporter
+	reporter chan<- ChangeEvent
+	// function being rewritten, for reporting
+	current FuncSpec
+
+	// set by RewriteForFiber
+	fiber bool
+
+	// if non-nil, only call sites at these positions are rewritten
+	selected []token.Position
+
+	// changes made so far, for reporting
+	changes []Change
+
+	// declarations of the variable added to functions, by ensureVar
+	stubs map[*ast.FuncDecl]*ast.AssignStmt
+	// arguments inserted to calls, for validation
+	insertedArgs []insertedArg
+	// calls rewritten in TemplateFiles
+	templateWarnings []ValidationWarning
+	// directories of Wire injectors rewritten
+	wireDirs map[string]bool
+
+	// if non-nil, failures at call sites are collected here instead of returned
+	failures []RewriteFailure
+
+	// index of the parameter to insert the variable at
+	argIndex int
+
+	// calls of AfterRewrite pending until Rewrite releases mu
+	afterRewrites []afterRewrite
+
+	// guards the AST and the states above, taken by every exported method;
+	// exclusively by those rewriting or loading, eg. Rewrite, and shared by those reading, eg. Each
+	mu sync.RWMutex
 }
 
 // Load prepares required objects and start loading packages given.
-func (app *App) Load(pkgPaths ...string) (err error) {
+func (app *App) Load(pkgPaths ...string) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	return app.load(pkgPaths...)
+}
+
+// load is Load without locking.
+func (app *App) load(pkgPaths ...string) (err error) {
 	if app.VarSpec == nil {
 		app.VarSpec = &VarSpec{
 			Name:     "ctx",
@@ -66,7 +261,7 @@ func (app *App) Load(pkgPaths ...string) (err error) {
 		}
 	}
 
-	app.Config.Mode = packages.LoadAllSyntax
+	app.Config.Mode = packages.LoadAllSyntax | packages.NeedModule
 
 	if app.Config.Fset == nil {
 		app.Config.Fset = token.NewFileSet()
@@ -80,27 +275,129 @@ func (app *App) Load(pkgPaths ...string) (err error) {
 	}
 
 	app.modified = map[*ast.File]bool{}
+	app.generated = map[string][]byte{}
+	app.rewritten = map[string]bool{}
+	app.rewrittenSpecs = nil
+	app.declRewritten = map[string]bool{}
+	app.rewrittenCalls = map[token.Pos]bool{}
+	app.changes = nil
+	app.stubs = map[*ast.FuncDecl]*ast.AssignStmt{}
+	app.insertedArgs = nil
+	app.templateWarnings = nil
+	app.wireDirs = nil
+	app.pkgPaths = pkgPaths
 
 	app.pkgs, err = packages.Load(app.Config, append([]string{app.VarSpec.PkgPath}, pkgPaths...)...)
 	if err != nil {
 		return
 	}
+	if app.ExcludeExternalTests {
+		app.pkgs = withoutExternalTests(app.pkgs)
+	}
+
+	err = app.resolveVarSpec(app.VarSpec)
+	return
+}
 
-	varPkg, err := app.resolvePackage(app.VarSpec.PkgPath)
+// resolveVarSpec fills package and type information of varSpec from loaded packages.
+func (app *App) resolveVarSpec(varSpec *VarSpec) error {
+	varPkg, err := app.resolvePackage(varSpec.PkgPath)
 	if err != nil {
-		return
+		return err
 	}
 
-	app.VarSpec.pkg = varPkg
-	app.VarSpec.varTypeObj = varPkg.Types.Scope().Lookup(app.VarSpec.TypeName)
-	if app.VarSpec.varTypeObj == nil {
-		err = xerrors.Errorf("cannot find type %s in package %s", app.VarSpec.TypeName, varPkg.PkgPath)
+	varSpec.pkg = varPkg
+	varSpec.varTypeObj = varPkg.Types.Scope().Lookup(varSpec.TypeName)
+	if varSpec.varTypeObj == nil {
+		return xerrors.Errorf("cannot find type %s in package %s", varSpec.TypeName, varPkg.PkgPath)
 	}
 
-	return
+	varSpec.instType, err = instantiateVarType(varSpec)
+	if err != nil {
+		return err
+	}
+
+	if (varSpec.FactoryReceiver == "") != (varSpec.FactoryMethod == "") {
+		return xerrors.New("FactoryReceiver and FactoryMethod must be specified together")
+	}
+
+	if varSpec.ChanType {
+		done := types.NewSignatureType(nil, nil, nil, nil, types.NewTuple(types.NewVar(token.NoPos, nil, "", types.NewChan(types.RecvOnly, types.NewStruct(nil, nil)))), false)
+		if m, _, _ := types.LookupFieldOrMethod(varSpec.varType(), true, varSpec.pkg.Types, "Done"); m == nil || !types.Identical(m.Type(), done) {
+			return xerrors.Errorf("type %s must have method Done() <-chan struct{} for ChanType", varSpec.TypeName)
+		}
+	}
+
+	varSpec.initPkgPaths, err = app.resolveInitPkgPaths(varSpec)
+	return err
+}
+
+// resolveInitPkgPaths finds the packages referred to by the initialization expressions of varSpec,
+// eg. "example.com/ctxreg" for "contextpkg.Current()", among loaded packages and their dependencies.
+// Packages not found are ignored.
+func (app *App) resolveInitPkgPaths(varSpec *VarSpec) ([]string, error) {
+	names := map[string]bool{}
+	for _, s := range []string{varSpec.InitExpr, varSpec.InitExprForBackground, varSpec.ResultExpr} {
+		if s == "" {
+			continue
+		}
+
+		expr, err := parser.ParseExpr(s)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing %q: %w", s, err)
+		}
+		ast.Inspect(expr, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if id, ok := sel.X.(*ast.Ident); ok {
+					names[id.Name] = true
+				}
+			}
+			return true
+		})
+	}
+
+	delete(names, varSpec.Name)
+	delete(names, varSpec.ResultName)
+
+	pathsByName := map[string]map[string]bool{}
+	packages.Visit(app.pkgs, nil, func(pkg *packages.Package) {
+		if names[pkg.Name] {
+			if pathsByName[pkg.Name] == nil {
+				pathsByName[pkg.Name] = map[string]bool{}
+			}
+			pathsByName[pkg.Name][pkg.PkgPath] = true
+		}
+	})
+
+	var paths []string
+	for name := range names {
+		if name == varSpec.pkg.Name {
+			paths = append(paths, varSpec.pkg.PkgPath)
+			continue
+		}
+		if len(pathsByName[name]) != 1 {
+			debugf("cannot resolve package %s referred to by initialization of %s", name, varSpec.Name)
+			continue
+		}
+		for path := range pathsByName[name] {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	return paths, nil
 }
 
+// resolvePackage returns the loaded package of path. Packages given to Load are looked up
+// without loading by path, which may be a relative pattern, eg. ".", again.
 func (app *App) resolvePackage(path string) (*packages.Package, error) {
+	for _, pkg := range app.pkgs {
+		// test variants have IDs like "foo [foo.test]"
+		if pkg.ID == path {
+			return pkg, nil
+		}
+	}
+
 	var conf = *app.Config // copy
 	conf.Mode = packages.LoadFiles
 	conf.Tests = false
@@ -125,8 +422,71 @@ func (app *App) resolvePackage(path string) (*packages.Package, error) {
 	return nil, xerrors.Errorf("cannot resolve package %q", path)
 }
 
-// Each visits all files modified along with their new contents.
+// isScanned reports whether pkg is one of pkgPaths, or if no pkgPaths given, one of the packages
+// given to Load, excluding the package of VarSpec, which is loaded along with them.
+func (app *App) isScanned(pkg *packages.Package, pkgPaths []string) bool {
+	if len(pkgPaths) > 0 {
+		return containsString(pkgPaths, pkg.PkgPath)
+	}
+
+	return pkg.PkgPath != app.VarSpec.PkgPath || containsString(app.pkgPaths, pkg.PkgPath)
+}
+
+// Each visits all files modified or generated along with their new contents.
+// If any plugins are registered, the contents are transformed by them beforehand.
+// If DiffMode is set, unified diffs from the original contents are visited instead.
+// Each may be called concurrently with each other, but Rewrite waits for them to finish,
+// so callback must not call Rewrite.
 func (app *App) Each(callback func(filename string, content []byte) error) error {
+	if app.DiffMode {
+		return app.eachContent(app.diffCallback(callback))
+	}
+
+	return app.eachContent(callback)
+}
+
+// eachContent is Each visiting the new contents regardless of DiffMode.
+func (app *App) eachContent(callback func(filename string, content []byte) error) error {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	if app.RewriteWithShadowCheck {
+		err := app.checkShadows()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(app.plugins) == 0 {
+		return app.each(callback)
+	}
+
+	contents := map[string][]byte{}
+	err := app.each(func(filename string, content []byte) error {
+		contents[filename] = content
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = app.runPlugins(contents)
+	if err != nil {
+		return err
+	}
+
+	for filename, content := range contents {
+		err := callback(filename, content)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// each visits all files modified or generated along with their new contents, without plugins run.
+func (app *App) each(callback func(filename string, content []byte) error) error {
 	fset := app.Config.Fset
 	for file := range app.modified {
 		filename := app.position(file.Pos()).Filename
@@ -148,6 +508,13 @@ func (app *App) Each(callback func(filename string, content []byte) error) error
 		}
 	}
 
+	for filename, content := range app.generated {
+		err := callback(filename, content)
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -174,23 +541,264 @@ func ParseVarSpec(s string) (*VarSpec, error) {
 // prepend variable specified by VarSpec to functions and calls
 // specified by spec.
 // Before calling this method, Init() must be called.
+// Rewrite may be called from multiple goroutines; the calls are serialized.
 func (app *App) Rewrite(spec FuncSpec) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	return app.rewrite(spec)
+}
+
+// rewrite is Rewrite without locking.
+func (app *App) rewrite(spec FuncSpec) error {
 	var err error
 	spec.pkg, err = app.resolvePackage(spec.PkgPath)
 	if err != nil {
 		return err
 	}
 
-	err = app.rewriteFuncDecl(spec)
+	if spec.FuncNameRE != nil {
+		return app.rewriteMatching(spec)
+	}
+
+	if spec.Version != "" {
+		spec, err = app.resolveVersion(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return app.rewriteResolved(spec)
+}
+
+// rewriteResolved rewrites the function specified by spec, whose package is already resolved,
+// and its callers.
+func (app *App) rewriteResolved(spec FuncSpec) error {
+	if app.rewritten[spec.String()] {
+		debugf("%s: already rewritten", spec)
+		return nil
+	}
+
+	extracts, err := app.extractsVar(spec)
 	if err != nil {
 		return err
 	}
+	if extracts {
+		debugf("%s: source of %s; skipped", spec, app.VarSpec.Name)
+		return nil
+	}
+
+	// rewritten by RewritePartial before, so only the call sites are left to rewrite
+	declRewritten := app.declRewritten[spec.String()]
+
+	// declared taking the variable when loaded, eg. written by a previous run,
+	// so only the call sites not passing it yet are left to rewrite
+	declaredIndex := -1
+	if !declRewritten {
+		declaredIndex = app.declaredArgIndex(spec)
+	}
+
+	if app.BeforeRewrite != nil {
+		if err := app.BeforeRewrite(spec); err != nil {
+			debugf("%s: skipped by BeforeRewrite: %v", spec, err)
+			return nil
+		}
+	}
+
+	if declaredIndex != -1 {
+		debugf("%s: already takes %s; rewriting call sites only", spec, app.VarSpec.Name)
+		defer func(argIndex int) { app.argIndex = argIndex }(app.argIndex)
+		app.argIndex = declaredIndex
+	} else if app.VarSpec.Position != 0 {
+		defer func(argIndex int) { app.argIndex = argIndex }(app.argIndex)
+		argIndex, err := app.positionArgIndex(spec)
+		if err != nil {
+			return err
+		}
+		app.argIndex = argIndex
+	}
+
+	numChanges := len(app.changes)
+	generated := make(map[string][]byte, len(app.generated))
+	for filename, content := range app.generated {
+		generated[filename] = content
+	}
+
+	defer func(current FuncSpec) { app.current = current }(app.current)
+	app.current = spec
+
+	if declaredIndex != -1 {
+		app.declRewritten[spec.String()] = true
+		app.rewrittenSpecs = append(app.rewrittenSpecs, spec)
+		declRewritten = true
+	}
+
+	if !declRewritten {
+		err = app.checkPluginABI(spec)
+		if err != nil {
+			return err
+		}
+
+		err = app.preCheck(spec)
+		if err != nil {
+			return err
+		}
+
+		err = app.rewriteFuncDecl(spec)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = app.rewriteCallers(spec)
 	if err != nil {
 		return err
 	}
 
+	if !declRewritten {
+		err = app.rewriteDependents(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	if app.selected == nil {
+		app.rewritten[spec.String()] = true
+	}
+
+	if app.AfterRewrite != nil {
+		app.afterRewrites = append(app.afterRewrites, afterRewrite{spec: spec, modified: app.changedFiles(numChanges, generated)})
+	}
+
+	return nil
+}
+
+// rewriteDependents rewrites what depends on the declaration of the function specified by spec,
+// already rewritten along with its callers, eg. templates calling it and constraints it satisfies.
+func (app *App) rewriteDependents(spec FuncSpec) error {
+	err := app.rewriteTemplates(spec)
+	if err != nil {
+		return err
+	}
+
+	err = app.rewriteWireInjectors(spec)
+	if err != nil {
+		return err
+	}
+
+	err = app.rewriteNamedArgs(spec)
+	if err != nil {
+		return err
+	}
+
+	app.declRewritten[spec.String()] = true
+	if app.selected == nil {
+		app.rewritten[spec.String()] = true
+	}
+	app.rewrittenSpecs = append(app.rewrittenSpecs, spec)
+
+	err = app.rewriteConstraints(spec)
+	if err != nil {
+		return err
+	}
+
+	if app.RewriteCompatMode {
+		if app.NamedArgMode || app.argIndex != 0 {
+			return xerrors.Errorf("func %s cannot have its shim generated unless taking %s first", spec, app.VarSpec.Name)
+		}
+		err = app.generateShim(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// afterRewrite is a call of AfterRewrite made by runAfterRewrite.
+type afterRewrite struct {
+	spec     FuncSpec
+	modified []string
+}
+
+// runAfterRewrite calls AfterRewrite with the functions rewritten so far, without holding mu
+// so that it can call Each.
+func (app *App) runAfterRewrite() {
+	app.mu.Lock()
+	pending := app.afterRewrites
+	app.afterRewrites = nil
+	app.mu.Unlock()
+
+	for _, r := range pending {
+		app.AfterRewrite(r.spec, r.modified)
+	}
+}
+
+// RewritePartial rewrites the function specified by spec as Rewrite does,
+// but only the call sites at positions are rewritten, leaving others untouched.
+// Positions are matched by their filenames and lines; filenames may be either
+// absolute or relative to the working directory.
+// RewritePartial may be called again with other positions to rewrite more call sites.
+func (app *App) RewritePartial(spec FuncSpec, positions []token.Position) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	app.selected = positions
+	if app.selected == nil {
+		app.selected = []token.Position{}
+	}
+	defer func() { app.selected = nil }()
+
+	return app.rewrite(spec)
+}
+
+// RewriteMatching rewrites all functions in package pkgPath whose names match re.
+// It is a shorthand for Rewrite(FuncSpec{PkgPath: pkgPath, FuncNameRE: re}).
+func (app *App) RewriteMatching(pkgPath string, re *regexp.Regexp) error {
+	return app.Rewrite(FuncSpec{PkgPath: pkgPath, FuncNameRE: re})
+}
+
+// rewriteMatching expands spec with FuncNameRE to the specs of each function matched
+// and rewrites them one by one. Functions already rewritten are skipped.
+// As Rewrite does, it is an error if any of them returns the variable under ModeAuto,
+// which is reported before rewriting any.
+func (app *App) rewriteMatching(spec FuncSpec) error {
+	specs := map[string]FuncSpec{}
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			s := FuncSpec{
+				PkgPath:  spec.PkgPath,
+				TypeName: spec.TypeName,
+				FuncName: f.Name(),
+				Mode:     spec.Mode,
+				pkg:      spec.pkg,
+			}
+			if _, err := app.extractsVar(s); err != nil {
+				return err
+			}
+			specs[s.String()] = s
+		}
+	}
+	if len(specs) == 0 {
+		return xerrors.Errorf("could not find declaration of func matching %s in package %s", spec.FuncNameRE, spec.PkgPath)
+	}
+
+	names := make([]string, 0, len(specs))
+	for name := range specs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := app.rewrite(specs[name]); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -200,6 +808,19 @@ type FuncSpec struct {
 	TypeName string
 	FuncName string
 
+	// FuncNameRE, if set, is used instead of FuncName to match
+	// multiple functions (or methods of TypeName) at once.
+	FuncNameRE *regexp.Regexp
+
+	// Version, if set, selects one of the functions of multiple historical signatures,
+	// named FuncName followed by Version, eg. "V1" for "FuncV1". The function of the version
+	// must not take the variable yet.
+	Version string
+
+	// Mode is one of ModeAuto, ModeInject and ModeExtract, telling whether the function
+	// is to take the variable or is a source of it.
+	Mode string
+
 	// resolved package information pointed by PkgPath
 	pkg *packages.Package
 }
@@ -220,23 +841,52 @@ func ParseFuncSpec(s string) (spec FuncSpec, err error) {
 }
 
 func (s FuncSpec) String() string {
+	if s.FuncNameRE != nil {
+		return fmt.Sprintf("%s./%s/", s.owner(), s.FuncNameRE)
+	}
+
+	return fmt.Sprintf("%s.%s", s.owner(), s.FuncName)
+}
+
+// owner returns the qualified name of the package or the type which the function belongs to.
+func (s FuncSpec) owner() string {
 	if s.TypeName == "" {
-		return fmt.Sprintf("%s.%s", s.pkg.PkgPath, s.FuncName)
+		return s.pkg.PkgPath
 	}
 
-	return fmt.Sprintf("%s.%s.%s", s.pkg.PkgPath, s.TypeName, s.FuncName)
+	return fmt.Sprintf("%s.%s", s.pkg.PkgPath, s.TypeName)
 }
 
 // matches takes function object and checks if it matches to the specification.
 // For method cases, "pkg.Typ.Meth" matches either "func (pkg.Typ) Meth()" or "func (*pkg.Type) Meth()".
+// The receiver type is compared by its package and name, so that unexported
+// and generic types are matched as well.
 func (s FuncSpec) matches(funcType *types.Func) bool {
-	recv := funcType.Type().(*types.Signature).Recv()
-	if recv != nil {
-		x := types.TypeString(recv.Type(), nil) + "." + funcType.Name()
-		return strings.TrimLeft(x, "*") == s.String()
+	// methods of instantiated or embedded types are compared by their declarations
+	funcType = funcType.Origin()
+
+	if funcType.Pkg() == nil || funcType.Pkg().Path() != s.pkg.PkgPath {
+		return false
+	}
+
+	typeName := ""
+	if recv := funcType.Type().(*types.Signature).Recv(); recv != nil {
+		named, ok := derefType(recv.Type()).(*types.Named)
+		if !ok {
+			return false
+		}
+		typeName = named.Obj().Name()
+	}
+
+	if typeName != s.TypeName {
+		return false
+	}
+
+	if s.FuncNameRE != nil {
+		return s.FuncNameRE.MatchString(funcType.Name())
 	}
 
-	return funcType.Pkg().Path()+"."+funcType.Name() == s.String()
+	return funcType.Name() == s.FuncName
 }
 
 func (app *App) position(pos token.Pos) token.Position {
@@ -248,16 +898,23 @@ func (app *App) position(pos token.Pos) token.Position {
 }
 
 func (app *App) findNodeEnclosing(pos token.Pos, pred func(ast.Node) bool) ast.Node {
+	for _, node := range app.pathEnclosing(pos) {
+		if pred(node) {
+			return node
+		}
+	}
+
+	return nil
+}
+
+// pathEnclosing returns AST nodes enclosing pos, from the innermost to the file.
+func (app *App) pathEnclosing(pos token.Pos) []ast.Node {
 	for _, pkg := range app.pkgs {
 		for _, file := range pkg.Syntax {
 			f := app.Config.Fset.File(file.Pos())
 			if f.Base() <= int(pos) && int(pos) < f.Base()+f.Size() {
 				path, _ := astutil.PathEnclosingInterval(file, pos, pos)
-				for _, node := range path {
-					if pred(node) {
-						return node
-					}
-				}
+				return path
 			}
 		}
 	}
@@ -267,8 +924,13 @@ func (app *App) findNodeEnclosing(pos token.Pos, pred func(ast.Node) bool) ast.N
 
 // rewriteCallExpr rewrites function call expression at pos to add ctx (or any other specified) to the first argument
 // This function examines scope if it already has any safisfying value according to ctx's type (eg. context.Context).
-func (app *App) rewriteCallExpr(scope *types.Scope, pos token.Pos) (usedExisting bool, err error) {
-	callExpr, ok := app.findNodeEnclosing(pos, func(n ast.Node) (ok bool) { _, ok = n.(*ast.CallExpr); return }).(*ast.CallExpr)
+func (app *App) rewriteCallExpr(pkg *packages.Package, scope *types.Scope, pos token.Pos) (usedExisting bool, err error) {
+	// the call whose function part contains pos, so that calls inside the receiver
+	// like "(*T)(v).F()" or calls taking F as an argument are not taken
+	callExpr, ok := app.findNodeEnclosing(pos, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		return ok && call.Fun.Pos() <= pos && pos < call.Fun.End()
+	}).(*ast.CallExpr)
 	if !ok {
 		err = xerrors.Errorf("BUG: %s: could not find function call expression", app.position(pos))
 		return
@@ -276,105 +938,360 @@ func (app *App) rewriteCallExpr(scope *types.Scope, pos token.Pos) (usedExisting
 
 	debugf("%s: found caller", app.position(pos))
 
-	// if varType is an interface, use satisfying variable, if any
+	arg := app.existingVarExpr(pkg, scope, pos)
+	if arg != nil {
+		usedExisting = true
+	} else {
+		arg = ast.NewIdent(app.VarSpec.Name)
+	}
 
-	var varName string
-	if iface, ok := app.VarSpec.varTypeObj.Type().Underlying().(*types.Interface); ok {
-		for _, name := range scope.Names() {
-			obj := scope.Lookup(name)
-			if types.Implements(obj.Type(), iface) {
-				varName = name
-				usedExisting = true
-				break
-			}
-		}
+	before := app.nodeString(callExpr)
+
+	passed := arg
+	if app.VarSpec.ChanType {
+		passed = &ast.CallExpr{Fun: &ast.SelectorExpr{X: arg, Sel: ast.NewIdent("Done")}}
 	}
 
-	if varName == "" {
-		varName = app.VarSpec.Name
+	if app.RewritePreservingComments {
+		setPos(passed, argPos(callExpr, app.argIndex))
 	}
 
-	callExpr.Args = append(
-		[]ast.Expr{
-			ast.NewIdent(varName),
-		},
-		callExpr.Args...,
-	)
+	callExpr.Args = insertExpr(callExpr.Args, app.argIndex, passed)
+	app.insertedArgs = append(app.insertedArgs, insertedArg{call: callExpr, arg: arg})
+	app.rewrittenCalls[pos] = true
 
-	if file := app.markModified(callExpr.Pos()); file != nil {
-		if !usedExisting {
-			astutil.AddImport(app.Config.Fset, file, app.VarSpec.pkg.PkgPath)
-		}
+	app.recordChange(ChangeCall, callExpr.Pos(), before, app.nodeString(callExpr))
+
+	if file := app.markModified(callExpr.Pos()); file != nil && app.AnnotateCallSites {
+		app.annotateCallSite(file, callExpr)
 	}
 
 	return
 }
 
-// ensureVar adds variable declaration to the scope at pos
-func (app *App) ensureVar(pkg *packages.Package, scope *types.Scope, funcDecl *ast.FuncDecl, pos token.Pos) error {
-	if scope.Lookup(app.VarSpec.Name) != nil {
-		return nil
+// existingVarExpr returns an expression which can be passed as the variable at pos
+// without declaring a new one, or nil if none found.
+func (app *App) existingVarExpr(pkg *packages.Package, scope *types.Scope, pos token.Pos) ast.Expr {
+	if name := app.errgroupContext(pkg.TypesInfo, pos); name != "" {
+		return ast.NewIdent(name)
 	}
 
-	scope.Insert(types.NewVar(token.NoPos, pkg.Types, app.VarSpec.Name, app.VarSpec.varTypeObj.Type()))
+	if app.InferContext {
+		if name := app.inferContext(pkg, pos); name != "" {
+			return ast.NewIdent(name)
+		}
+	} else if name := app.VarSpec.lookupEnclosing(pkg.Types.Scope().Innermost(pos), scope, pos); name != "" {
+		return ast.NewIdent(name)
+	}
 
-	initExpr, err := parser.ParseExpr(app.VarSpec.InitExpr)
-	if err != nil {
-		return xerrors.Errorf("parsing %q: %w", app.VarSpec.InitExpr, err)
+	if expr := app.channelContext(pkg, pos); expr != nil {
+		return expr
 	}
 
-	funcDecl.Body.List = append(
-		[]ast.Stmt{
-			&ast.AssignStmt{
-				Lhs: []ast.Expr{ast.NewIdent(app.VarSpec.Name)},
-				Rhs: []ast.Expr{initExpr},
-				Tok: token.DEFINE,
-			},
-		},
-		funcDecl.Body.List...,
-	)
+	if expr := app.requestContext(pkg, pos); expr != nil {
+		return expr
+	}
+
+	if expr := app.grpcInterceptorContext(pkg, pos); expr != nil {
+		return expr
+	}
 
-	if file := app.markModified(pos); file != nil {
-		astutil.AddImport(app.Config.Fset, file, app.VarSpec.pkg.PkgPath)
+	if expr := app.fiberContext(pkg, pos); expr != nil {
+		return expr
+	}
+
+	if expr := app.derivedParentContext(pkg, pos); expr != nil {
+		return expr
 	}
 
 	return nil
 }
 
-func (app *App) findScope(pkg *packages.Package, pos token.Pos) (*types.Scope, *ast.FuncDecl, error) {
-	decl, ok := app.findNodeEnclosing(pos, func(n ast.Node) (ok bool) { _, ok = n.(*ast.FuncDecl); return }).(*ast.FuncDecl)
-	if !ok {
-		return nil, nil, xerrors.Errorf("%s: BUG: no surrounding FuncDecl found", app.Config.Fset.Position(pos))
+// lookupExisting returns the name of a variable in scope declared before pos which can be used as the variable,
+// if the variable type is an interface and any satisfying variable is found.
+func (s *VarSpec) lookupExisting(scope *types.Scope, pos token.Pos) string {
+	for _, name := range scope.Names() {
+		obj := scope.Lookup(name)
+		if obj.Pos() < pos && s.passable(obj.Type()) && s.validate(obj) {
+			return name
+		}
 	}
 
-	scope := pkg.TypesInfo.Scopes[decl.Type]
-	if scope == nil {
-		return nil, nil, xerrors.Errorf("%s: BUG: no Scope found", app.Config.Fset.Position(pos))
+	return ""
+}
+
+// passable reports whether a variable of typ can be passed as the variable, ie. typ implements
+// the variable type if it is an interface, or is identical to it otherwise, eg. *gin.Context.
+func (s *VarSpec) passable(typ types.Type) bool {
+	if iface, ok := s.varType().Underlying().(*types.Interface); ok {
+		return types.Implements(typ, iface)
 	}
 
-	return scope, decl, nil
+	return types.Identical(typ, s.varType())
 }
 
-// rewriteCallers rewrites calls to functions specified by spec
-// to add ctx as first argument.
-func (app *App) rewriteCallers(spec FuncSpec) error {
+// validate reports whether the existing variable obj can be passed, according to s.Validator.
+func (s *VarSpec) validate(obj types.Object) bool {
+	return s.Validator == nil || s.Validator(obj)
+}
+
+// lookupEnclosing is like lookupExisting but also looks up block scopes, eg. of if,
+// switch or select cases, from inner to funcScope, for variables declared before pos.
+func (s *VarSpec) lookupEnclosing(inner, funcScope *types.Scope, pos token.Pos) string {
+	for scope := inner; scope != nil && scope != funcScope; scope = scope.Parent() {
+		for _, name := range scope.Names() {
+			obj := scope.Lookup(name)
+			if obj.Pos() < pos && s.passable(obj.Type()) && s.validate(obj) {
+				return name
+			}
+		}
+	}
+
+	return s.lookupExisting(funcScope, pos)
+}
+
+// ensureVar adds variable declaration to the scope at pos, at the top of the function body,
+// or right before the defer statement at the top level including pos.
+func (app *App) ensureVar(pkg *packages.Package, scope *types.Scope, funcDecl *ast.FuncDecl, pos token.Pos) error {
+	if obj := scope.Lookup(app.VarSpec.Name); obj != nil {
+		// eg. "ctx int", which the variable cannot be declared over
+		if !types.AssignableTo(obj.Type(), app.VarSpec.varType()) {
+			p := app.position(pos)
+			return &NameCollisionError{
+				Filename: p.Filename,
+				Line:     p.Line,
+				Name:     obj.Name(),
+				Type:     types.TypeString(obj.Type(), types.RelativeTo(pkg.Types)),
+			}
+		}
+		return app.resolveStubConflict(funcDecl, pos)
+	}
+
+	if app.NoStub {
+		p := app.position(pos)
+		return &NoContextInScopeError{Filename: p.Filename, Line: p.Line}
+	}
+
+	scope.Insert(types.NewVar(token.NoPos, pkg.Types, app.VarSpec.Name, app.VarSpec.varType()))
+
+	init, initPkgPaths := app.stubInitExpr(funcDecl)
+	stmts, err := app.stubStmts(init)
+	if err != nil {
+		return err
+	}
+
+	app.renameShadowingVars(pkg, scope, funcDecl, initPkgPaths)
+
+	if i := topLevelDefer(funcDecl, pos); i > 0 {
+		list := append([]ast.Stmt{}, funcDecl.Body.List[:i]...)
+		list = append(list, stmts...)
+		funcDecl.Body.List = append(list, funcDecl.Body.List[i:]...)
+	} else {
+		funcDecl.Body.List = append(stmts, funcDecl.Body.List...)
+	}
+	app.stubs[funcDecl] = stmts[0].(*ast.AssignStmt)
+
+	var after []string
+	for _, stmt := range stmts {
+		after = append(after, app.nodeString(stmt))
+	}
+	app.recordChange(ChangeStub, funcDecl.Body.Lbrace, "", strings.Join(after, "\n"))
+
+	if file := app.markModified(pos); file != nil && !app.RewriteNoImport {
+		for _, path := range initPkgPaths {
+			astutil.AddImport(app.Config.Fset, file, path)
+		}
+	}
+
+	return nil
+}
+
+// stubInitExpr returns the initialization expression of the variable declared in funcDecl,
+// and the import paths of packages it refers to.
+func (app *App) stubInitExpr(funcDecl *ast.FuncDecl) (string, []string) {
+	if app.VarSpec.InitExprForBackground != "" && app.launchedAsGoroutine(funcDecl) {
+		debugf("%s: launched as goroutine", app.position(funcDecl.Pos()))
+		return app.VarSpec.InitExprForBackground, app.VarSpec.initPkgPaths
+	}
+
+	if init := app.factoryInitExpr(funcDecl); init != "" {
+		return init, nil
+	}
+
+	if obj := app.VarSpec.varTypeObj; obj.Pkg().Path() == "context" && obj.Name() == "Context" && app.VarSpec.ResultName == "" && app.inExternalTest(funcDecl) {
+		return "context.Background()", []string{"context"}
+	}
+
+	return app.VarSpec.InitExpr, app.VarSpec.initPkgPaths
+}
+
+// renameShadowingVars renames the parameters and results of funcDecl, in scope, which shadow
+// the packages of paths referred to by the stub, eg. "context" of "func F() (context error)",
+// by appending underscores to their names.
+func (app *App) renameShadowingVars(pkg *packages.Package, scope *types.Scope, funcDecl *ast.FuncDecl, paths []string) {
+	for _, path := range paths {
+		name := app.packageName(path)
+		v, ok := scope.Lookup(name).(*types.Var)
+		if !ok {
+			continue
+		}
+
+		newName := name + "_"
+		for isDeclaredUnder(scope, newName) {
+			newName += "_"
+		}
+
+		debugf("%s: renaming %s shadowing package %s to %s", app.position(v.Pos()), name, path, newName)
+
+		before := app.nodeString(funcDecl.Type)
+		for _, m := range []map[*ast.Ident]types.Object{pkg.TypesInfo.Defs, pkg.TypesInfo.Uses} {
+			for id, obj := range m {
+				if obj == v {
+					id.Name = newName
+				}
+			}
+		}
+		app.markModified(funcDecl.Pos())
+		app.recordChange(ChangeDecl, funcDecl.Type.Pos(), before, app.nodeString(funcDecl.Type))
+	}
+}
+
+// isDeclaredUnder reports whether name is declared in scope, its parents or its children.
+func isDeclaredUnder(scope *types.Scope, name string) bool {
+	if _, obj := scope.LookupParent(name, token.NoPos); obj != nil {
+		return true
+	}
+
+	var declared func(s *types.Scope) bool
+	declared = func(s *types.Scope) bool {
+		for i := 0; i < s.NumChildren(); i++ {
+			if c := s.Child(i); c.Lookup(name) != nil || declared(c) {
+				return true
+			}
+		}
+		return false
+	}
+	return declared(scope)
+}
+
+// stubStmts builds statements declaring the variable on the caller side by init,
+// which are "<name> := <init>" or "<name>, <result> := <init>; defer <result expr>".
+func (app *App) stubStmts(init string) ([]ast.Stmt, error) {
+	initExpr, err := parser.ParseExpr(init)
+	if err != nil {
+		return nil, xerrors.Errorf("parsing %q: %w", init, err)
+	}
+	// positions in the parsed source would break lines at random
+	clearPos(initExpr)
+
+	assign := &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent(app.VarSpec.Name)},
+		Rhs: []ast.Expr{initExpr},
+		Tok: token.DEFINE,
+	}
+	if app.VarSpec.ResultName != "" {
+		assign.Lhs = append(assign.Lhs, ast.NewIdent(app.VarSpec.ResultName))
+	}
+
+	stmts := []ast.Stmt{assign}
+
+	if app.VarSpec.ResultExpr != "" {
+		expr, err := parser.ParseExpr(app.VarSpec.ResultExpr)
+		if err != nil {
+			return nil, xerrors.Errorf("parsing %q: %w", app.VarSpec.ResultExpr, err)
+		}
+		clearPos(expr)
+		call, ok := expr.(*ast.CallExpr)
+		if !ok {
+			return nil, xerrors.Errorf("%q must be a function call", app.VarSpec.ResultExpr)
+		}
+		stmts = append(stmts, &ast.DeferStmt{Call: call})
+	}
+
+	return stmts, nil
+}
+
+// launchedAsGoroutine reports whether the function of funcDecl is launched
+// by any go statement, eg. "go worker()", in the loaded packages.
+func (app *App) launchedAsGoroutine(funcDecl *ast.FuncDecl) bool {
+	found := false
+	for _, pkg := range app.pkgs {
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				goStmt, ok := n.(*ast.GoStmt)
+				if !ok || found {
+					return !found
+				}
+
+				var id *ast.Ident
+				switch fun := astutil.Unparen(goStmt.Call.Fun).(type) {
+				case *ast.Ident:
+					id = fun
+				case *ast.SelectorExpr:
+					id = fun.Sel
+				}
+
+				// objects of test variants of packages differ, so are compared by positions
+				if obj := pkg.TypesInfo.Uses[id]; obj != nil && obj.Pos() == funcDecl.Name.Pos() {
+					found = true
+				}
+				return true
+			})
+			if found {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (app *App) findScope(pkg *packages.Package, pos token.Pos) (*types.Scope, *ast.FuncDecl, error) {
+	decl, ok := app.findNodeEnclosing(pos, func(n ast.Node) (ok bool) { _, ok = n.(*ast.FuncDecl); return }).(*ast.FuncDecl)
+	if !ok {
+		return nil, nil, xerrors.Errorf("%s: BUG: no surrounding FuncDecl found", app.Config.Fset.Position(pos))
+	}
+
+	scope := pkg.TypesInfo.Scopes[decl.Type]
+	if scope == nil {
+		return nil, nil, xerrors.Errorf("%s: BUG: no Scope found", app.Config.Fset.Position(pos))
+	}
+
+	return scope, decl, nil
+}
+
+// rewriteCallers rewrites calls to functions specified by spec
+// to add ctx as first argument.
+func (app *App) rewriteCallers(spec FuncSpec) error {
+	// test variants of packages share the syntax of the files with the packages,
+	// so the same call sites are found in both
+	seen := map[token.Pos]bool{}
+
 	for _, pkg := range app.pkgs {
+		if app.isExcludedPackage(pkg.PkgPath) {
+			debugf("%s: excluded", pkg.PkgPath)
+			continue
+		}
+
 		for id, obj := range pkg.TypesInfo.Uses {
 			if f, ok := obj.(*types.Func); ok && spec.matches(f) {
-				scope, funcDecl, err := app.findScope(pkg, id.Pos())
-				if err != nil {
-					return err
+				// calls rewritten by a previous RewritePartial are skipped
+				if seen[id.Pos()] || app.rewrittenCalls[id.Pos()] {
+					continue
 				}
+				seen[id.Pos()] = true
 
-				usedExisting, err := app.rewriteCallExpr(scope, id.Pos())
-				if err != nil {
-					return err
+				// as well as calls already passing the variable to the function declared taking it
+				if app.accepts(f.Type().(*types.Signature)) && app.passesVar(pkg, id.Pos()) {
+					debugf("%s: already passes %s", app.position(id.Pos()), app.VarSpec.Name)
+					continue
 				}
 
-				if !usedExisting {
-					if err := app.ensureVar(pkg, scope, funcDecl, id.Pos()); err != nil {
+				if err := app.rewriteCallSite(pkg, id.Pos()); err != nil {
+					if app.failures == nil {
 						return err
 					}
+					app.addFailure(id.Pos(), err)
 				}
 			}
 		}
@@ -383,56 +1300,432 @@ func (app *App) rewriteCallers(spec FuncSpec) error {
 	return nil
 }
 
+// rewriteCallSite rewrites the call at pos in pkg to pass the variable,
+// declaring it in the enclosing function if required.
+func (app *App) rewriteCallSite(pkg *packages.Package, pos token.Pos) error {
+	if app.isExcluded(pos) {
+		debugf("%s: excluded", app.position(pos))
+		if app.RewriteComment {
+			app.commentSkippedCallSite(pos, "excluded")
+		}
+		return nil
+	}
+	if !app.isSelected(pos) {
+		debugf("%s: not selected", app.position(pos))
+		return nil
+	}
+
+	if app.WireMode && app.isWireProvider(pkg, pos) {
+		debugf("%s: registered to wire", app.position(pos))
+		return nil
+	}
+
+	// also in package-level variables, eg. "var pool = sync.Pool{New: ...}"
+	err := app.checkPoolNew(pkg, pos)
+	if err != nil {
+		return err
+	}
+
+	if lit := app.outermostFuncLit(pos); lit != nil {
+		return app.rewriteClosureCallSite(pkg, lit, pos)
+	}
+
+	scope, funcDecl, err := app.findScope(pkg, pos)
+	if err != nil {
+		return err
+	}
+
+	err = app.checkSortInterfaceMethod(pkg, funcDecl, pos)
+	if err != nil {
+		return err
+	}
+
+	// checked before rewriting the call, which is left untouched
+	if app.NoStub && app.existingVarExpr(pkg, scope, pos) == nil && scope.Lookup(app.VarSpec.Name) == nil {
+		debugf("%s: no variable in scope", app.position(pos))
+		if app.RewriteComment {
+			app.commentSkippedCallSite(pos, "no variable in scope")
+			return nil
+		}
+		p := app.position(pos)
+		return &NoContextInScopeError{Filename: p.Filename, Line: p.Line}
+	}
+
+	usedExisting, err := app.rewriteCallExpr(pkg, scope, pos)
+	if err != nil {
+		return err
+	}
+
+	app.hoistStub(funcDecl, pos)
+
+	if !usedExisting {
+		return app.ensureVar(pkg, scope, funcDecl, pos)
+	}
+
+	// the variable declared by a previous rewrite may be used
+	return app.resolveStubConflict(funcDecl, pos)
+}
+
+// isExcludedPackage reports whether the package of pkgPath matches any of app.ExcludePackagePatterns.
+func (app *App) isExcludedPackage(pkgPath string) bool {
+	for _, pattern := range app.ExcludePackagePatterns {
+		if prefix := strings.TrimSuffix(pattern, "/..."); prefix != pattern {
+			for dir := pkgPath; dir != "." && dir != "/"; dir = path.Dir(dir) {
+				if ok, _ := path.Match(prefix, dir); ok {
+					return true
+				}
+			}
+			continue
+		}
+
+		if ok, _ := path.Match(pattern, pkgPath); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isExcluded reports whether the position pos is listed in app.Exclude.
+// A pattern without directory part matches to files of that name in any directory.
+func (app *App) isExcluded(pos token.Pos) bool {
+	p := app.position(pos)
+	for _, pattern := range app.Exclude {
+		i := strings.LastIndex(pattern, ":")
+		if i == -1 {
+			continue
+		}
+
+		filename, line := filepath.Clean(pattern[:i]), pattern[i+1:]
+		if line != strconv.Itoa(p.Line) {
+			continue
+		}
+
+		if filename == filepath.Clean(p.Filename) {
+			return true
+		}
+		if !strings.ContainsRune(filename, filepath.Separator) && filename == filepath.Base(p.Filename) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSelected reports whether the position pos is to be rewritten by RewritePartial.
+// It always returns true outside RewritePartial.
+func (app *App) isSelected(pos token.Pos) bool {
+	if app.selected == nil {
+		return true
+	}
+
+	p := app.position(pos)
+	abs := app.Config.Fset.Position(pos).Filename
+	for _, s := range app.selected {
+		if s.Line != p.Line {
+			continue
+		}
+
+		filename := filepath.Clean(s.Filename)
+		if filename == filepath.Clean(p.Filename) || filename == filepath.Clean(abs) {
+			return true
+		}
+	}
+
+	return false
+}
+
 // rewriteFuncDecls finds function declaration matching spec and modifies AST
 // to make the function to have ctx (or any other specified) as the first argument.
 func (app *App) rewriteFuncDecl(spec FuncSpec) error {
-	var funcDecl *ast.FuncDecl
-	for id, obj := range spec.pkg.TypesInfo.Defs {
-		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
-			var err error
-			_, funcDecl, err = app.findScope(spec.pkg, id.Pos())
-			if err != nil {
-				return err
-			}
-			break
-		}
+	if method := app.lookupInterfaceMethod(spec); method != nil {
+		return app.rewriteInterfaceMethod(method)
 	}
-	if funcDecl == nil {
-		return xerrors.Errorf("could not find declaration of func %s in package %s", spec.FuncName, spec.PkgPath)
+
+	funcDecl, err := app.findFuncDecl(spec)
+	if err != nil {
+		return err
+	}
+	if funcDecl.Body == nil {
+		return &BodylessDeclarationError{
+			Filename: app.position(funcDecl.Pos()).Filename,
+			FuncName: funcDecl.Name.Name,
+		}
 	}
 
 	debugf("%s: found definition", app.position(funcDecl.Pos()))
 
-	funcDecl.Type.Params.List = append(
-		[]*ast.Field{
-			{
-				Names: []*ast.Ident{
-					ast.NewIdent(app.VarSpec.Name),
-				},
-				Type: &ast.SelectorExpr{
-					Sel: ast.NewIdent(app.VarSpec.TypeName),
-					X:   ast.NewIdent(app.VarSpec.pkg.Name),
-				},
+	file := app.markModified(funcDecl.Pos())
+	if file == nil {
+		return xerrors.Errorf("BUG: %s: could not find file", app.position(funcDecl.Pos()))
+	}
+
+	before := app.nodeString(funcSignature(funcDecl))
+
+	funcDecl.Type.Params.List = app.insertVarField(file, funcDecl.Type.Params)
+
+	app.recordChange(ChangeDecl, funcDecl.Pos(), before, app.nodeString(funcSignature(funcDecl)))
+
+	// the variable declared in the function is not replaced by the channel
+	if !app.VarSpec.ChanType {
+		app.removeStubVarDecl(spec.pkg.TypesInfo, funcDecl)
+	}
+
+	app.addVarImport(file)
+
+	if app.PropagateToInterfaces {
+		if method, ok := spec.pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func); ok {
+			return app.propagateToInterfaces(method)
+		}
+	}
+
+	return nil
+}
+
+// BodylessDeclarationError is returned when the function to be rewritten has no body,
+// eg. is implemented in assembly, as its stub cannot be updated automatically.
+type BodylessDeclarationError struct {
+	Filename string
+	FuncName string
+}
+
+func (e *BodylessDeclarationError) Error() string {
+	return fmt.Sprintf("%s: func %s has no body; its implementation must be updated manually", e.Filename, e.FuncName)
+}
+
+// NoContextInScopeError is returned when App.NoStub is set and a call site
+// has no variable in scope to pass.
+type NoContextInScopeError struct {
+	Filename string
+	Line     int
+}
+
+func (e *NoContextInScopeError) Error() string {
+	return fmt.Sprintf("%s:%d: no variable to pass found in scope", e.Filename, e.Line)
+}
+
+// NameCollisionError is returned by Rewrite when the variable is to be declared in a function
+// whose parameter of the same name has another type.
+type NameCollisionError struct {
+	Filename string
+	Line     int
+	Name     string
+	// type of the parameter
+	Type string
+}
+
+func (e *NameCollisionError) Error() string {
+	return fmt.Sprintf("%s:%d: parameter %s of type %s collides with the variable to declare", e.Filename, e.Line, e.Name, e.Type)
+}
+
+// doneChanName is the name of the parameter added when VarSpec.ChanType is set.
+const doneChanName = "done"
+
+// newVarField creates a parameter field declaring the variable specified by VarSpec in file.
+// The field is positioned at pos, which should be the opening parenthesis of
+// the parameter list, so that comments around are kept in place on printing.
+// If the package of the variable type is dot-imported in file, the type is not qualified.
+// If VarSpec.ChanType is set, the field is "done <-chan struct{}" instead.
+func (app *App) newVarField(file *ast.File, pos token.Pos) *ast.Field {
+	if app.VarSpec.ChanType {
+		return &ast.Field{
+			Names: []*ast.Ident{
+				{Name: doneChanName, NamePos: pos},
 			},
+			Type: &ast.ChanType{
+				Begin: pos,
+				Arrow: pos,
+				Dir:   ast.RECV,
+				Value: &ast.StructType{Struct: pos, Fields: &ast.FieldList{Opening: pos, Closing: pos}},
+			},
+		}
+	}
+
+	var typ ast.Expr = &ast.SelectorExpr{
+		Sel: &ast.Ident{Name: app.VarSpec.TypeName, NamePos: pos},
+		X:   &ast.Ident{Name: app.VarSpec.pkg.Name, NamePos: pos},
+	}
+	if isDotImported(file, app.VarSpec.pkg.PkgPath) {
+		typ = &ast.Ident{Name: app.VarSpec.TypeName, NamePos: pos}
+	}
+
+	typ = app.VarSpec.instantiateTypeExpr(typ)
+	if app.VarSpec.Pointer {
+		typ = &ast.StarExpr{Star: pos, X: typ}
+	}
+
+	return &ast.Field{
+		Names: []*ast.Ident{
+			{Name: app.VarSpec.Name, NamePos: pos},
 		},
-		funcDecl.Type.Params.List...,
-	)
+		Type: typ,
+	}
+}
 
-	app.removeStubVarDecl(spec.pkg.TypesInfo, funcDecl)
+// addVarImport adds the import of the package of the variable type to file,
+// unless it is dot-imported, or RewriteNoImport or VarSpec.ChanType is set.
+func (app *App) addVarImport(file *ast.File) {
+	if app.RewriteNoImport || app.VarSpec.ChanType || isDotImported(file, app.VarSpec.pkg.PkgPath) {
+		return
+	}
 
-	if file := app.markModified(funcDecl.Pos()); file != nil {
-		astutil.AddImport(app.Config.Fset, file, app.VarSpec.pkg.PkgPath)
+	astutil.AddImport(app.Config.Fset, file, app.VarSpec.pkg.PkgPath)
+}
+
+// isDotImported reports whether the package of path is imported by file as `import . "path"`.
+func isDotImported(file *ast.File, path string) bool {
+	for _, spec := range file.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err == nil && p == path && spec.Name != nil && spec.Name.Name == "." {
+			return true
+		}
 	}
 
-	return nil
+	return false
+}
+
+// insertVarField returns the fields of params in file with the variable inserted at app.argIndex,
+// counted in parameters. A field declaring multiple parameters, eg. "x, y int", is split if required.
+func (app *App) insertVarField(file *ast.File, params *ast.FieldList) []*ast.Field {
+	fields := make([]*ast.Field, 0, len(params.List)+2)
+	rest, pos := app.argIndex, params.Opening
+	inserted := false
+	for _, field := range params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+
+		if !inserted && rest < n {
+			if rest > 0 {
+				fields = append(fields, &ast.Field{Doc: field.Doc, Names: field.Names[:rest], Type: field.Type})
+				pos = field.Names[rest-1].End()
+				field = &ast.Field{Names: field.Names[rest:], Type: field.Type, Comment: field.Comment}
+			}
+			fields = append(fields, app.newVarField(file, pos))
+			inserted = true
+		}
+
+		fields = append(fields, field)
+		rest -= n
+		pos = field.End()
+	}
+	if !inserted {
+		fields = append(fields, app.newVarField(file, pos))
+	}
+
+	return fields
+}
+
+// argPos returns the position for the argument to be inserted at i in callExpr,
+// which is at the opening parenthesis or the end of the preceding argument,
+// so that comments and line breaks around the existing arguments are kept relative to them.
+func argPos(callExpr *ast.CallExpr, i int) token.Pos {
+	if i == 0 || len(callExpr.Args) == 0 {
+		return callExpr.Lparen
+	}
+	if i > len(callExpr.Args) {
+		i = len(callExpr.Args)
+	}
+	return callExpr.Args[i-1].End()
+}
+
+// positionArgIndex returns the index of the parameter of the function specified by spec
+// to insert the variable at, by VarSpec.Position.
+func (app *App) positionArgIndex(spec FuncSpec) (int, error) {
+	var found *types.Func
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			found = f
+			break
+		}
+	}
+	if found == nil {
+		return 0, xerrors.Errorf("could not find declaration of func %s in package %s", spec.FuncName, spec.PkgPath)
+	}
+
+	return app.signatureArgIndex(found.Type().(*types.Signature), found.Pos(), spec.String())
+}
+
+// declaredArgIndex returns the index of the parameter of the variable's type which the function
+// specified by spec is declared with when loaded, eg. written by a previous run, or -1 if none.
+func (app *App) declaredArgIndex(spec FuncSpec) int {
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			params := f.Type().(*types.Signature).Params()
+			for i := 0; i < params.Len(); i++ {
+				if types.Identical(params.At(i).Type(), app.VarSpec.varType()) {
+					return i
+				}
+			}
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// passesVar reports whether the call at pos in pkg already passes an argument of the variable's type.
+func (app *App) passesVar(pkg *packages.Package, pos token.Pos) bool {
+	callExpr, ok := app.findNodeEnclosing(pos, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		return ok && call.Fun.Pos() <= pos && pos < call.Fun.End()
+	}).(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+
+	for _, arg := range callExpr.Args {
+		if t := pkg.TypesInfo.TypeOf(arg); t != nil && types.Identical(t, app.VarSpec.varType()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// signatureArgIndex is positionArgIndex for sig of the function named name declared at pos.
+func (app *App) signatureArgIndex(sig *types.Signature, pos token.Pos, name string) (int, error) {
+	n := sig.Params().Len()
+
+	i := app.VarSpec.Position
+	switch {
+	case i == -1:
+		i = n
+	case i < 0 || i > n:
+		return 0, xerrors.Errorf("%s: position %d is out of the %d parameters of func %s", app.position(pos), i, n, name)
+	}
+	if sig.Variadic() && i == n {
+		return 0, xerrors.Errorf("%s: func %s is variadic, so cannot take %s last", app.position(pos), name, app.VarSpec.Name)
+	}
+
+	return i, nil
+}
+
+// insertExpr returns exprs with expr inserted at i, or appended if i is out of range.
+func insertExpr(exprs []ast.Expr, i int, expr ast.Expr) []ast.Expr {
+	if i > len(exprs) {
+		i = len(exprs)
+	}
+
+	result := make([]ast.Expr, 0, len(exprs)+1)
+	result = append(result, exprs[:i]...)
+	result = append(result, expr)
+	return append(result, exprs[i:]...)
 }
 
 func (app *App) removeStubVarDecl(typesInfo *types.Info, funcDecl *ast.FuncDecl) {
-	// Special but common case: if the type of variable inserted is
-	// "context.Context" and there is a definition of variable of same name which
-	// is initialized by "<var> := context.TODO()" inside function declaration, remove that
-	// definition in favour of newly added ctx argument.
-	if app.VarSpec.PkgPath != "context" || app.VarSpec.TypeName != "Context" {
+	// The variable declared by a previous rewrite, eg. of a function called here,
+	// is not known to typesInfo but is as well replaced by the argument.
+	if assign := app.stubs[funcDecl]; assign != nil && len(assign.Lhs) == 1 {
+		astutil.Apply(funcDecl.Body, func(c *astutil.Cursor) bool {
+			if c.Node() == assign {
+				c.Delete()
+				return false
+			}
+
+			return true
+		}, nil)
+		delete(app.stubs, funcDecl)
 		return
 	}
 
@@ -446,12 +1739,16 @@ func (app *App) removeStubVarDecl(typesInfo *types.Info, funcDecl *ast.FuncDecl)
 		obj.Pos(),
 		func(n ast.Node) bool { _, ok := n.(*ast.AssignStmt); return ok },
 	).(*ast.AssignStmt)
-	if !ok {
+	if !ok || assign.Tok != token.DEFINE {
 		return
 	}
 
+	// Special but common case: if the type of variable inserted is
+	// "context.Context" and there is a definition of variable of same name which
+	// is initialized by "<var> := context.TODO()" inside function declaration, remove that
+	// definition in favour of newly added ctx argument.
 	// for simplicity, only assume "<var> := context.TODO()" case.
-	if assign.Tok == token.DEFINE && len(assign.Lhs) == 1 {
+	if app.VarSpec.PkgPath == "context" && app.VarSpec.TypeName == "Context" && len(assign.Lhs) == 1 {
 		var buf bytes.Buffer
 		err := format.Node(&buf, app.Config.Fset, assign.Rhs[0])
 		if err != nil {
@@ -467,8 +1764,19 @@ func (app *App) removeStubVarDecl(typesInfo *types.Info, funcDecl *ast.FuncDecl)
 
 				return true
 			}, nil)
+			return
+		}
+	}
+
+	// Otherwise, eg. "<var> := context.WithValue(base, k, v)", the definition is kept
+	// but assigns to the argument now, unless it defines other variables as well.
+	for _, lhs := range assign.Lhs {
+		if id, ok := lhs.(*ast.Ident); ok && id.Name != app.VarSpec.Name && typesInfo.Defs[id] != nil {
+			return
 		}
 	}
+	debugf("%s: assigning to %s instead of defining", app.position(assign.Pos()), app.VarSpec.Name)
+	assign.Tok = token.ASSIGN
 }
 
 func (app *App) markModified(pos token.Pos) *ast.File {
@@ -1,12 +1,26 @@
 package ctxize
 
 import (
+	"encoding/json"
+	"fmt"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/assign"
 	"golang.org/x/tools/go/packages/packagestest"
+	"golang.org/x/xerrors"
 )
 
 var testdata = []packagestest.Module{
@@ -15,6 +29,81 @@ var testdata = []packagestest.Module{
 	testPackage("example.com/baz"),
 	testPackage("example.com/go-qux"),
 	testPackage("example.com/go-quux"),
+	testPackage("example.com/handler"),
+	testPackage("example.com/asm"),
+	testPackage("example.com/annotated"),
+	testPackage("example.com/store"),
+	testPackage("example.com/traced"),
+	testPackage("example.com/grouped"),
+	testPackage("golang.org/x/sync"),
+	testPackage("example.com/chanmsg"),
+	testPackage("example.com/coverage"),
+	testPackage("example.com/shimmed"),
+	testPackage("example.com/inferred"),
+	testPackage("example.com/private"),
+	testPackage("example.com/selecting"),
+	testPackage("example.com/switching"),
+	testPackage("example.com/embedded"),
+	testPackage("example.com/helpers"),
+	testPackage("example.com/converted"),
+	testPackage("example.com/ctxreg"),
+	testPackage("example.com/legacy"),
+	testPackage("example.com/deriving"),
+	testPackage("example.com/prechecked"),
+	testPackage("example.com/directive"),
+	testPackage("example.com/sorted"),
+	testPackage("example.com/suggested"),
+	testPackage("example.com/vendored"),
+	testPackage("example.com/consumer"),
+	testPackage("example.com/iterating"),
+	testPackage("example.com/versioned"),
+	testPackage("example.com/leaking"),
+	testPackage("example.com/commented"),
+	testPackage("example.com/backgrounded"),
+	testPackage("example.com/internalized"),
+	testPackage("example.com/fallback"),
+	testPackage("example.com/commenting"),
+	testPackage("example.com/dotimport"),
+	testPackage("example.com/chain"),
+	testPackage("example.com/wrapped"),
+	testPackage("example.com/handling"),
+	testPackage("example.com/conflicting"),
+	testPackage("example.com/pooled"),
+	testPackage("example.com/semver"),
+	testPackage("example.com/validated"),
+	testPackage("example.com/plugged"),
+	testPackage("example.com/surface"),
+	testPackage("example.com/generictrace"),
+	testPackage("example.com/constrained"),
+	testPackage("example.com/shadowed"),
+	testPackage("example.com/diagram"),
+	testPackage("example.com/factory"),
+	testPackage("example.com/extracting"),
+	testPackage("example.com/intercepted"),
+	testPackage("google.golang.org/grpc"),
+	testPackage("example.com/templated"),
+	testPackage("example.com/exttest"),
+	testPackage("example.com/nestedclosure"),
+	testPackage("example.com/concurrent"),
+	testPackage("example.com/collision"),
+	testPackage("example.com/sqlcgen"),
+	testPackage("github.com/gin-gonic/gin"),
+	testPackage("example.com/ginhandler"),
+	testPackage("github.com/gofiber/fiber/v2"),
+	testPackage("example.com/fiberhandler"),
+	testPackage("github.com/google/wire"),
+	testPackage("example.com/wired"),
+	testPackage("example.com/transitive"),
+	testPackage("example.com/earlyreturn"),
+	testPackage("example.com/resultname"),
+	testPackage("example.com/namedargs"),
+	testPackage("example.com/batch"),
+	testPackage("example.com/goroutine"),
+	testPackage("example.com/donechan"),
+	testPackage("example.com/deferred"),
+	testPackage("example.com/propagated"),
+	testPackage("example.com/closured"),
+	testPackage("example.com/positioned"),
 }
 
 func testPackage(pkgPath string) packagestest.Module {
@@ -177,3 +266,4147 @@ func TestRewrite_RemoveCtxTODO(t *testing.T) {
 	}
 	testFileContents(t, app, expects)
 }
+
+func TestRewrite_KeepCtxWithValue(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "derivesCtxInside", PkgPath: "example.com/baz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"baz.go": {
+			"func derivesCtxInside(ctx context.Context, base context.Context)",
+			// ctx is now the argument, which cannot be defined again
+			"ctx = context.WithValue(base, key{}, 1)",
+			"!ctx := context.WithValue(base, key{}, 1)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_Exclude(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:  exported.Config,
+		Exclude: []string{"bar.go:8"},
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "bar.go" {
+			t.Errorf("bar.go should not be modified but got:\n%s", string(content))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"foo.go": {"func F(ctx context.Context)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_ExcludePackagePatterns(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:                 exported.Config,
+		ExcludePackagePatterns: []string{"example.com/ba*/..."},
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "bar.go" {
+			t.Errorf("bar.go should not be modified but got:\n%s", string(content))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"foo.go": {"func F(ctx context.Context)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteRecursiveStruct(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/handler", "example.com/handler/consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteRecursiveStruct(FuncSpec{PkgPath: "example.com/handler", TypeName: "Handler", FuncName: "Process"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"handler.go": {
+			"Process func(ctx context.Context, n int) error",
+			`{Name: "named", Process: func(ctx context.Context, n int) error {`,
+			`{Name: "literal", Process: func(ctx context.Context, n int) error { return nil }}`,
+			`{"positional", func(ctx context.Context, n int) error {`,
+			"return process(n)",
+			"ctx := context.TODO()",
+			"h.Process(ctx, 1)",
+		},
+		// consumer_test.go makes the test variant of the package share consumer.go
+		"consumer.go": {
+			"func Run(h handler.Handler) error {\n\tctx := context.TODO()\n\treturn h.Process(ctx, 2)\n}",
+			`{Name: "consumer", Process: func(ctx context.Context, n int) error { return nil }}`,
+			`{Name: "none", Process: nil}`,
+		},
+	}
+	testFileContents(t, app, expects)
+
+	app = &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "ctx",
+			PkgPath:  "context",
+			TypeName: "Context",
+			InitExpr: "context.TODO()",
+			Position: -1,
+		},
+	}
+
+	err = app.Load("example.com/handler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteRecursiveStruct(FuncSpec{PkgPath: "example.com/handler", TypeName: "Handler", FuncName: "Process"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects = map[string][]string{
+		"handler.go": {
+			"Process func(n int, ctx context.Context) error",
+			`{Name: "named", Process: func(n int, ctx context.Context) error {`,
+			`{Name: "literal", Process: func(n int, ctx context.Context) error { return nil }}`,
+			"h.Process(1, ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_Bodyless(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/asm")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Sum", PkgPath: "example.com/asm"})
+
+	var bodylessErr *BodylessDeclarationError
+	if !xerrors.As(err, &bodylessErr) {
+		t.Fatalf("expected BodylessDeclarationError but got %v", err)
+	}
+	if filepath.Base(bodylessErr.Filename) != "asm.go" || bodylessErr.FuncName != "Sum" {
+		t.Errorf("unexpected error: %+v", bodylessErr)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		t.Errorf("%s should not be modified", filename)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewriteAnnotated(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/annotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteAnnotated("example.com/annotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"annotated.go": {
+			"func F(ctx context.Context)",
+			"func G(c context.Context)",
+			"ctx := context.TODO()",
+			"F(ctx)",
+			"G(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteMatching(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteMatching("example.com/store", regexp.MustCompile("^(Get|Set|Delete)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Get overlaps with the previous rewrite
+	err = app.RewriteMatching("example.com/store", regexp.MustCompile("^(Get|List)$"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"store.go": {
+			"func Get(ctx context.Context, key string)",
+			"func Set(ctx context.Context, key, value string)",
+			"func Delete(ctx context.Context, key string)",
+			"func List(ctx context.Context)",
+			"func Lookup(key string)",
+			"return Get(ctx, key)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_withResult(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:       "ctx",
+			PkgPath:    "context",
+			TypeName:   "Context",
+			InitExpr:   `Start(context.Background(), "G")`,
+			ResultName: "span",
+			ResultExpr: "span.End()",
+		},
+	}
+
+	err := app.Load("example.com/traced")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/traced"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"traced.go": {
+			"func F(ctx context.Context)",
+			`ctx, span := Start(context.Background(), "G")`,
+			"defer span.End()",
+			"F(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteForOpenTelemetry(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/traced")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteForOpenTelemetry(FuncSpec{FuncName: "F", PkgPath: "example.com/traced"}, "example.com/traced", "G")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"traced.go": {
+			"func F(ctx context.Context)",
+			`ctx, span := otel.Tracer("example.com/traced").Start(context.TODO(), "G")` + "\n\tdefer span.End()\n\tF(ctx)",
+			`"go.opentelemetry.io/otel"`,
+		},
+	}
+	testFileContents(t, app, expects)
+
+	if app.VarSpec.InitExpr != "context.TODO()" {
+		t.Errorf("VarSpec should be restored but got %q", app.VarSpec.InitExpr)
+	}
+}
+
+func TestRewrite_errgroup(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/grouped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/grouped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"grouped.go": {
+			"func F(ctx context.Context, n int)",
+			"return F(gctx, 1)",
+			"!context.TODO()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_NoStub(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		NoStub: true,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+
+	var noCtxErr *NoContextInScopeError
+	if !xerrors.As(err, &noCtxErr) {
+		t.Fatalf("expected NoContextInScopeError but got %v", err)
+	}
+	if noCtxErr.Line == 0 {
+		t.Errorf("unexpected error: %+v", noCtxErr)
+	}
+}
+
+func TestRewrite_NameCollision(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/collision")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/collision"})
+
+	var collisionErr *NameCollisionError
+	if !xerrors.As(err, &collisionErr) {
+		t.Fatalf("expected NameCollisionError but got %v", err)
+	}
+	if collisionErr.Line != 7 || collisionErr.Name != "ctx" || collisionErr.Type != "int" {
+		t.Errorf("unexpected error: %+v", collisionErr)
+	}
+}
+
+func TestRewrite_resolveLoadedPackages(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// packages already loaded should be resolved without running go list, which fails
+	app.Config.Env = append(append([]string(nil), app.Config.Env...), "GOFLAGS=-mod=invalid")
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"foo.go": {"func F(ctx context.Context)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_ChannelContextField(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:              exported.Config,
+		ChannelContextField: "Ctx",
+	}
+
+	err := app.Load("example.com/chanmsg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/chanmsg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"chanmsg.go": {
+			"F(msg.Ctx, msg.N)",
+			"F(msg.Ctx, msg.N+1)",
+			"!context.TODO()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestLintCoverage(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coverage, err := app.LintCoverage("example.com/coverage")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handle, Run and Batch call Fetch, of which only Handle accepts ctx
+	if expected := 1.0 / 3; coverage != expected {
+		t.Errorf("expected coverage %v but got %v", expected, coverage)
+	}
+
+	// the packages given to Load, not including context
+	coverage, err = app.LintCoverage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := 1.0 / 3; coverage != expected {
+		t.Errorf("expected coverage %v but got %v", expected, coverage)
+	}
+}
+
+func TestGenerateShim(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/shimmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := FuncSpec{FuncName: "F", PkgPath: "example.com/shimmed"}
+
+	err = app.Rewrite(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.GenerateShim(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"shimmed.go": {
+			"func FWithContext(ctx context.Context, n int, opts ...string) (string, error)",
+			`FWithContext(ctx, 1, "a")`,
+		},
+		"shimF.go": {
+			`import "context"`,
+			"// Deprecated: use FWithContext instead.",
+			"func F(n int, opts ...string) (string, error) {",
+			"return FWithContext(context.TODO(), n, opts...)",
+			"!strings",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestGenerateShim_typeParams(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/shimmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := FuncSpec{FuncName: "Zero", PkgPath: "example.com/shimmed"}
+
+	err = app.Rewrite(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.GenerateShim(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"shimmed.go": {
+			"func ZeroWithContext[T any, U comparable](ctx context.Context, n int) (T, U)",
+			"ZeroWithContext[string, int](ctx, 1)",
+		},
+		"shimZero.go": {
+			"func Zero[T any, U comparable](n int) (T, U) {",
+			"return ZeroWithContext[T, U](context.TODO(), n)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestGenerateShim_excluded(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:  exported.Config,
+		Exclude: []string{"shimmed.go:16"},
+	}
+
+	err := app.Load("example.com/shimmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spec := FuncSpec{FuncName: "F", PkgPath: "example.com/shimmed"}
+
+	err = app.Rewrite(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.GenerateShim(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"shimmed.go": {
+			`FWithContext(ctx, 1, "a")`,
+			// the excluded call site keeps calling the shim
+			"func H() {\n\tF(2)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_RewriteCompatMode(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:            exported.Config,
+		RewriteCompatMode: true,
+	}
+
+	err := app.Load("example.com/shimmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/shimmed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"shimmed.go": {
+			"func FWithContext(ctx context.Context, n int, opts ...string) (string, error)",
+			`FWithContext(ctx, 1, "a")`,
+		},
+		"shimF.go": {
+			"// Deprecated: use FWithContext instead.",
+			"func F(n int, opts ...string) (string, error) {",
+			"return FWithContext(context.TODO(), n, opts...)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteFromReader(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	input := `
+# functions to migrate
+example.com/store.Get
+example.com/store.Set
+`
+	err := app.RewriteFromReader(strings.NewReader(input), []string{"example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"store.go": {
+			"func Get(ctx context.Context, key string)",
+			"func Set(ctx context.Context, key, value string)",
+			"func Delete(key string)",
+			"return Get(ctx, key)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewritePartial(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar", "example.com/baz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positions := []token.Position{
+		{Filename: exported.File("example.com/baz", "baz.go"), Line: 10},
+	}
+	err = app.RewritePartial(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"}, positions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"foo.go": {"func F(ctx context.Context)"},
+		"baz.go": {"foo.F(x)"},
+	}
+	testFileContents(t, app, expects)
+
+	err = app.Each(func(filename string, content []byte) error {
+		if name := filepath.Base(filename); name == "bar.go" || name == "foo_test.go" {
+			t.Errorf("%s should not be modified but got:\n%s", filename, string(content))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	positions = []token.Position{
+		{Filename: exported.File("example.com/bar", "bar.go"), Line: 8},
+	}
+	err = app.RewritePartial(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"}, positions)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects = map[string][]string{
+		"foo.go": {"func F(ctx context.Context)", "!ctx context.Context, ctx"},
+		"bar.go": {"foo.F(ctx)"},
+		"baz.go": {"foo.F(x)"},
+	}
+	testFileContents(t, app, expects)
+
+	err = app.Each(func(filename string, content []byte) error {
+		if name := filepath.Base(filename); name == "foo_test.go" {
+			t.Errorf("%s should not be modified but got:\n%s", filename, string(content))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the rest of the call sites
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects = map[string][]string{
+		"foo.go":      {"func F(ctx context.Context)", "!ctx context.Context, ctx"},
+		"bar.go":      {"foo.F(ctx)", "!foo.F(ctx, ctx)"},
+		"baz.go":      {"foo.F(x)", "!foo.F(x, x)"},
+		"foo_test.go": {"F(ctx)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_InferContext(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:       exported.Config,
+		InferContext: true,
+	}
+
+	err := app.Load("example.com/inferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/inferred"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"inferred.go": {
+			"F(c, 1)",
+			"ctx := context.TODO()",
+			"F(ctx, 2)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_unexportedType(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/private")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{PkgPath: "example.com/private", TypeName: "store", FuncName: "get"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{PkgPath: "example.com/private", TypeName: "cache", FuncName: "lookup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"private.go": {
+			"func (s *store) get(ctx context.Context, key string) string",
+			"func (c cache[K]) lookup(ctx context.Context, key K) bool",
+			`s.get(ctx, "a")`,
+			`c.lookup(ctx, "b")`,
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestClone(t *testing.T) {
+	// files of modules other than the primary one cannot be overlaid
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/store")})
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Get", PkgPath: "example.com/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := app.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = clone.Rewrite(FuncSpec{FuncName: "Set", PkgPath: "example.com/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// already rewritten before cloned
+	err = clone.Rewrite(FuncSpec{FuncName: "Get", PkgPath: "example.com/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Delete", PkgPath: "example.com/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testFileContents(t, app, map[string][]string{
+		"store.go": {
+			"func Get(ctx context.Context, key string)",
+			"func Set(key, value string)",
+			"func Delete(ctx context.Context, key string)",
+		},
+	})
+	testFileContents(t, clone, map[string][]string{
+		"store.go": {
+			"func Get(ctx context.Context, key string)",
+			"func Set(ctx context.Context, key, value string)",
+			"func Delete(key string)",
+			"return Get(ctx, key)",
+		},
+	})
+}
+
+func TestClone_options(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/store")})
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:                     exported.Config,
+		Exclude:                    []string{"store.go:1"},
+		ExcludePackagePatterns:     []string{"example.com/excluded/..."},
+		ChannelContextField:        "ctx",
+		NoStub:                     true,
+		InferContext:               true,
+		AnnotateCallSites:          true,
+		PreCheckers:                []*analysis.Analyzer{assign.Analyzer},
+		RewriteComment:             true,
+		ConflictResolver:           func(existingStub, newStub string) string { return existingStub },
+		BeforeRewrite:              func(spec FuncSpec) error { return nil },
+		AfterRewrite:               func(spec FuncSpec, modified []string) {},
+		PropagateToInterfaces:      true,
+		RewriteCompatMode:          true,
+		RewritePreservingComments:  true,
+		AcknowledgePluginABIChange: true,
+		RewriteWithShadowCheck:     true,
+		RewriteNoImport:            true,
+		TemplateFiles:              []string{"store.tmpl"},
+		ExcludeExternalTests:       true,
+		SQLCMode:                   true,
+		WireMode:                   true,
+		DryRun:                     true,
+		DiffMode:                   true,
+		NamedArgMode:               true,
+	}
+
+	err := app.Load("example.com/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := app.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, cv := reflect.ValueOf(app).Elem(), reflect.ValueOf(clone).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		// Config and VarSpec are copied instead of shared
+		if !field.IsExported() || field.Name == "Config" || field.Name == "VarSpec" {
+			continue
+		}
+
+		if v.Field(i).IsZero() {
+			t.Errorf("%s must be set to be tested", field.Name)
+			continue
+		}
+
+		var equal bool
+		if field.Type.Kind() == reflect.Func {
+			equal = v.Field(i).Pointer() == cv.Field(i).Pointer()
+		} else {
+			equal = reflect.DeepEqual(v.Field(i).Interface(), cv.Field(i).Interface())
+		}
+		if !equal {
+			t.Errorf("%s is not copied: %v", field.Name, cv.Field(i))
+		}
+	}
+
+	if clone.VarSpec == app.VarSpec || clone.VarSpec.Name != app.VarSpec.Name || clone.VarSpec.InitExpr != app.VarSpec.InitExpr {
+		t.Errorf("VarSpec is not copied: %+v", clone.VarSpec)
+	}
+}
+
+func TestRewrite_selectCase(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/selecting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/selecting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"selecting.go": {
+			"F(ctx, n)",
+			"F(ctx, 0)",
+			"func H(ch chan int) {\n\tctx := context.TODO()\n",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_switchCase(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/switching")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/switching"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"switching.go": {
+			"F(c, 1)",
+			"ctx := context.TODO()",
+			"F(ctx, 2)",
+			"F(ctx, n)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestChanges(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string]Change{}
+	for _, c := range []Change{
+		{File: "foo.go", Kind: ChangeDecl, Line: 3, Column: 1, Before: "func F()", After: "func F(ctx context.Context)"},
+		{File: "bar.go", Kind: ChangeCall, Line: 8, Column: 2, Before: "foo.F()", After: "foo.F(ctx)"},
+		{File: "bar.go", Kind: ChangeStub, Line: 7, Column: 12, Before: "", After: "ctx := context.TODO()"},
+	} {
+		expects[c.File+" "+c.Kind] = c
+	}
+
+	for _, c := range app.Changes() {
+		c.File = filepath.Base(c.File)
+		expected, ok := expects[c.File+" "+c.Kind]
+		if !ok {
+			continue
+		}
+		if !reflect.DeepEqual(c, expected) {
+			t.Errorf("expected %+v but got %+v", expected, c)
+		}
+		delete(expects, c.File+" "+c.Kind)
+	}
+
+	for _, c := range expects {
+		t.Errorf("change not reported: %+v", c)
+	}
+}
+
+func TestRewriteReport_ToMarkdown(t *testing.T) {
+	report := RewriteReport{
+		{File: "foo.go", Kind: ChangeDecl, Line: 3, Column: 1, Before: "func F()", After: "func F(ctx context.Context)"},
+		{File: "bar.go", Kind: ChangeStub, Line: 7, Column: 12, Before: "", After: "ctx, span := Start(context.Background(), `G`)\ndefer span.End()"},
+		{File: "baz.go", Kind: ChangeCall, Line: 5, Column: 9, Before: "F(a || b)", After: "F(ctx, a || b)"},
+	}
+
+	expected := "| File | Location | Change Type | Before | After |\n" +
+		"| --- | --- | --- | --- | --- |\n" +
+		"| foo.go | 3:1 | decl | `func F()` | `func F(ctx context.Context)` |\n" +
+		"| bar.go | 7:12 | stub |  | ``ctx, span := Start(context.Background(), `G`); defer span.End()`` |\n" +
+		"| baz.go | 5:9 | call | `F(a \\|\\| b)` | `F(ctx, a \\|\\| b)` |\n"
+
+	if got := report.ToMarkdown(); got != expected {
+		t.Errorf("expected:\n%s\nbut got:\n%s", expected, got)
+	}
+}
+
+func TestRewrite_embeddedInterface(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/embedded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{PkgPath: "example.com/embedded", TypeName: "Fetcher", FuncName: "Fetch"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"embedded.go": {
+			"Fetch(ctx context.Context, key string) (string, error)",
+			"return s.Fetch(ctx, key)",
+			`f.Fetch(ctx, "")`,
+			"f.Purge()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteTestHelpers(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/helpers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteTestHelpers("example.com/helpers")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"helpers_test.go": {
+			"func mustFetch(t *testing.T, ctx context.Context, id int) string",
+			"func fail(t testing.TB)",
+			"ctx := context.Background()",
+			"mustFetch(t, ctx, 1)",
+			"s, err := Fetch(ctx, id)",
+			"!Fetch(context.Background(), id)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_convertedReceiver(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/converted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{PkgPath: "example.com/converted", TypeName: "T", FuncName: "Method"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"converted.go": {
+			"func (t *T) Method(ctx context.Context, n int)",
+			"(*T)(u).Method(ctx, 1)",
+			"(*T)((*T)(u)).Method(ctx, 2)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_Validator(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:      "ctx",
+			PkgPath:   "context",
+			TypeName:  "Context",
+			InitExpr:  "context.TODO()",
+			Validator: func(obj types.Object) bool { return obj.Name() == "ctx" },
+		},
+	}
+
+	err := app.Load("example.com/switching")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/switching"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"switching.go": {
+			"F(ctx, 1)",
+			"F(ctx, 2)",
+			"F(ctx, n)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_InitExprImport(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "ctx",
+			PkgPath:  "context",
+			TypeName: "Context",
+			InitExpr: "contextpkg.Current()",
+		},
+	}
+
+	err := app.Load("example.com/legacy", "example.com/ctxreg")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"legacy.go": {
+			`"example.com/ctxreg"`,
+			"ctx := contextpkg.Current()",
+			"F(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_derivedContext(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/deriving")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/deriving"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"deriving.go": {
+			// evaluated once for both
+			"func G() {\n\tparentCtx := context.Background()\n\tF(parentCtx, 1)",
+			"context.WithTimeout(parentCtx, time.Second)",
+			"F(ctx, 2)",
+			// copied as it is
+			"func H() {\n\tF(base, 3)",
+			"F(ctx, 4)",
+			"!context.TODO()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_AnnotateCallSites(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:            exported.Config,
+		AnnotateCallSites: true,
+	}
+
+	err := app.Load("example.com/selecting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/selecting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"selecting.go": {
+			"F(ctx, n) // ctxize:auto\n",
+			"F(ctx, 0) // ctxize:auto\n",
+			"func F(ctx context.Context, n int) {\n",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_PreCheckers(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:      exported.Config,
+		PreCheckers: []*analysis.Analyzer{assign.Analyzer},
+	}
+
+	err := app.Load("example.com/prechecked", "example.com/store")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// no diagnostics in store
+	err = app.Rewrite(FuncSpec{FuncName: "Get", PkgPath: "example.com/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/prechecked"})
+
+	var preCheckErr *PreCheckFailedError
+	if !xerrors.As(err, &preCheckErr) {
+		t.Fatalf("expected PreCheckFailedError but got %v", err)
+	}
+	if len(preCheckErr.Results) != 1 {
+		t.Fatalf("unexpected results: %v", preCheckErr.Results)
+	}
+	if r := preCheckErr.Results[0]; r.Analyzer != "assign" || filepath.Base(r.Position.Filename) != "prechecked.go" || r.Position.Line != 9 {
+		t.Errorf("unexpected result: %v", r)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "prechecked.go" {
+			t.Errorf("%s should not be modified", filename)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewriteFromAnnotations(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/directive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteFromAnnotations("example.com/directive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"directive.go": {
+			"// F does something.\nfunc F(ctx context.Context)",
+			"func G(ctx context.Context)",
+			"ctx := context.Background()",
+			"F(ctx)",
+			"G(ctx)",
+			"!go:ctxize",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_sortInterface(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/sorted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Less", PkgPath: "example.com/sorted"})
+
+	var cannotErr *CannotRewriteError
+	if !xerrors.As(err, &cannotErr) {
+		t.Fatalf("expected CannotRewriteError but got %v", err)
+	}
+	if filepath.Base(cannotErr.Filename) != "sorted.go" || cannotErr.Line != 14 {
+		t.Errorf("unexpected error: %+v", cannotErr)
+	}
+}
+
+func TestSuggestFuncSpec(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/suggested")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs, err := app.SuggestFuncSpec("example.com/suggested")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, spec := range specs {
+		names = append(names, spec.FuncName)
+	}
+
+	// Handle and loadAll reach both of loadUser and loadGroup
+	expected := []string{"Handle", "loadAll", "loadGroup", "loadUser"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v but got %v", expected, names)
+	}
+
+	// the packages given to Load, not including context
+	specs, err = app.SuggestFuncSpec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names = nil
+	for _, spec := range specs {
+		names = append(names, spec.PkgPath+"."+spec.FuncName)
+	}
+
+	expected = []string{"example.com/suggested.Handle", "example.com/suggested.loadAll", "example.com/suggested.loadGroup", "example.com/suggested.loadUser"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected %v but got %v", expected, names)
+	}
+}
+
+func TestRewriteOnlyCallSites(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/vendored", "example.com/consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteOnlyCallSites(FuncSpec{FuncName: "Get", PkgPath: "example.com/vendored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteOnlyCallSites(FuncSpec{FuncName: "Put", PkgPath: "example.com/vendored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// consumer_test.go makes the test variant of the package share consumer.go
+	expects := map[string][]string{
+		"consumer.go": {
+			"return func(ctx context.Context) (string, error) {\n\t\treturn vendored.Get(\"a\")\n\t}(ctx)",
+			"func Store() {\n\tctx := context.TODO()\n\tfunc(ctx context.Context) {\n\t\tvendored.Put(\"b\")\n\t}(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "vendored.go" {
+			t.Errorf("%s should not be modified", filename)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app = &App{
+		Config:                 exported.Config,
+		ExcludePackagePatterns: []string{"example.com/consumer"},
+	}
+
+	err = app.Load("example.com/vendored", "example.com/consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteOnlyCallSites(FuncSpec{FuncName: "Get", PkgPath: "example.com/vendored"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		t.Errorf("%s should not be modified", filename)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewrite_RangeOverFunc(t *testing.T) {
+	for _, inferContext := range []bool{false, true} {
+		t.Run(fmt.Sprintf("InferContext=%v", inferContext), func(t *testing.T) {
+			exported := packagestest.Export(t, packagestest.Modules, testdata)
+			defer exported.Cleanup()
+
+			app := &App{
+				Config:       exported.Config,
+				InferContext: inferContext,
+			}
+
+			err := app.Load("example.com/iterating")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/iterating"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expects := map[string][]string{
+				"iterating.go": {
+					"func F(ctx context.Context, n int)",
+					"func Items() iter.Seq[int] {\n\tctx := context.TODO()",
+					"\t\t\tF(ctx, i)\n\t\t\tif !yield(i)",
+					"for i := range Items() {\n\t\tF(ctx, i)",
+					"\t\tF(ctx, 0)\n\t\tyield(1)",
+					"} {\n\t\tF(ctx, i)",
+				},
+			}
+			testFileContents(t, app, expects)
+		})
+	}
+}
+
+func TestRewrite_Version(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/versioned")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Func", Version: "V2", PkgPath: "example.com/versioned"})
+	if err == nil || !strings.Contains(err.Error(), "already takes Context") {
+		t.Fatalf("should fail for the version already migrated but got: %v", err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Func", Version: "V1", PkgPath: "example.com/versioned"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"versioned.go": {
+			"func FuncV1(ctx context.Context, x int)",
+			"func FuncV2(ctx context.Context, x int)",
+			"FuncV1(ctx, 1)",
+			"FuncV2(ctx, 2)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteForModule(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/multi")})
+	defer exported.Cleanup()
+
+	// the module root should be found from its subdirectory
+	exported.Config.Dir = filepath.Join(exported.Config.Dir, "sub")
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.RewriteForModule("example.com/multi", FuncSpec{FuncName: "F", PkgPath: "example.com/multi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"multi.go": {"func F(ctx context.Context)"},
+		"sub.go":   {"ctx := context.TODO()", "multi.F(ctx)"},
+	}
+	testFileContents(t, app, expects)
+
+	// filenames are relative to Config.Dir as given
+	if app.Config.Dir != exported.Config.Dir {
+		t.Errorf("Config.Dir should be kept %s but got %s", exported.Config.Dir, app.Config.Dir)
+	}
+	var filenames []string
+	err = app.Each(func(filename string, content []byte) error {
+		filenames = append(filenames, filename)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(filenames)
+	if expected := []string{filepath.Join("..", "multi.go"), "sub.go"}; !reflect.DeepEqual(filenames, expected) {
+		t.Errorf("expected %v but got %v", expected, filenames)
+	}
+}
+
+func TestRewriteModule(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/multi")})
+	defer exported.Cleanup()
+
+	exported.Config.Dir = filepath.Join(exported.Config.Dir, "sub")
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/multi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteModule("example.com/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"multi.go": {"func F(ctx context.Context)"},
+		"sub.go":   {"ctx := context.TODO()", "multi.F(ctx)"},
+	}
+	testFileContents(t, app, expects)
+
+	err = app.RewriteModule("example.com/unknown")
+	if err == nil {
+		t.Error("RewriteModule should fail for modules not found")
+	}
+}
+
+func TestRewriteModule_written(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/multi")})
+	defer exported.Cleanup()
+
+	exported.Config.Dir = filepath.Join(exported.Config.Dir, "sub")
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/multi"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(exported.Config.Dir, filename)
+		}
+		return os.WriteFile(filename, content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteModule("example.com/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var filenames []string
+	err = app.Each(func(filename string, content []byte) error {
+		filenames = append(filenames, filepath.Base(filename))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"sub.go"}; !reflect.DeepEqual(filenames, expected) {
+		t.Errorf("expected only %v to be modified but got %v", expected, filenames)
+	}
+
+	expects := map[string][]string{
+		"sub.go": {"ctx := context.TODO()", "multi.F(ctx)"},
+	}
+	testFileContents(t, app, expects)
+
+	err = app.Each(func(filename string, content []byte) error {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(exported.Config.Dir, filename)
+		}
+		return os.WriteFile(filename, content, 0644)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// call sites already passing the variable are left as they are
+	err = app.RewriteModule("example.com/multi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		t.Errorf("%s should not be modified:\n%s", filename, content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectContextLeaks(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/leaking")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaks := app.DetectContextLeaks("example.com/leaking")
+	if len(leaks) != 1 {
+		t.Fatalf("expected 1 leak but got %v", leaks)
+	}
+
+	leak := leaks[0]
+	if leak.Name != "ctx" || leak.Go.Line != 13 || leak.Capture.Line != 14 {
+		t.Errorf("unexpected leak: %+v", leak)
+	}
+
+	// the packages given to Load, not including context
+	leaks = app.DetectContextLeaks()
+	if len(leaks) != 1 || leaks[0] != leak {
+		t.Errorf("expected %+v but got %+v", leak, leaks)
+	}
+}
+
+func TestRewritePreservingComments(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:                    exported.Config,
+		RewritePreservingComments: true,
+	}
+
+	err := app.Load("example.com/commented")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/commented"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"commented.go": {
+			"F(ctx,\n\t\t1, // x\n\t\t2, // y\n\t)",
+			"F(ctx, 1 /* x */, 2)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+type headerPlugin struct {
+	specs []string
+}
+
+func (p *headerPlugin) AfterRewrite(spec FuncSpec, modified map[string][]byte) error {
+	p.specs = append(p.specs, spec.String())
+	for filename, content := range modified {
+		modified[filename] = append([]byte("// rewritten for "+spec.String()+"\n"), content...)
+	}
+	return nil
+}
+
+func TestRegisterPlugin(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plugin := &headerPlugin{}
+	app.RegisterPlugin("header", plugin)
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"foo.go": {"// rewritten for example.com/foo.F\npackage foo", "func F(ctx context.Context)"},
+		"bar.go": {"// rewritten for example.com/foo.F\npackage bar"},
+	}
+	testFileContents(t, app, expects)
+
+	if !reflect.DeepEqual(plugin.specs, []string{"example.com/foo.F"}) {
+		t.Errorf("plugin got unexpected specs: %v", plugin.specs)
+	}
+}
+
+func TestRewrite_InitExprForBackground(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:                  "ctx",
+			PkgPath:               "context",
+			TypeName:              "Context",
+			InitExpr:              "context.TODO()",
+			InitExprForBackground: "context.WithoutCancel(context.Background())",
+		},
+	}
+
+	err := app.Load("example.com/backgrounded")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/backgrounded"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"backgrounded.go": {
+			"func worker() {\n\tctx := context.WithoutCancel(context.Background())\n",
+			"func handle() {\n\tctx := context.TODO()\n",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_InternalPackage(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/internalized/...")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Get", PkgPath: "example.com/internalized/internal/store"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"store.go":        {"func Get(ctx context.Context, key string) string"},
+		"internalized.go": {"ctx := context.TODO()", "return store.Get(ctx, key)"},
+	}
+	testFileContents(t, app, expects)
+
+	for _, pkg := range app.pkgs {
+		for _, err := range pkg.Errors {
+			t.Errorf("%s: %s", pkg.PkgPath, err)
+		}
+	}
+}
+
+func TestRewriteWithFallback(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, err := app.RewriteWithFallback(FuncSpec{FuncName: "F", PkgPath: "example.com/fallback"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []int{}
+	for _, f := range failures {
+		lines = append(lines, f.Pos.Line)
+	}
+	sort.Ints(lines)
+	if !reflect.DeepEqual(lines, []int{9, 14}) {
+		t.Errorf("unexpected failures: %v", failures)
+	}
+
+	expects := map[string][]string{
+		"fallback.go": {
+			"func F(ctx context.Context) int",
+			"var initial = F()",
+			"return F() < s[j]",
+			"func G() int {\n\tctx := context.TODO()\n\treturn F(ctx)",
+			"func H(ctx context.Context) int {\n\treturn F(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+
+	_, err = app.RewriteWithFallback(FuncSpec{FuncName: "Missing", PkgPath: "example.com/fallback"})
+	if err == nil {
+		t.Error("should fail for missing function")
+	}
+
+	t.Run("NoStub", func(t *testing.T) {
+		app := &App{
+			Config: exported.Config,
+			NoStub: true,
+		}
+
+		err := app.Load("example.com/fallback")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		failures, err := app.RewriteWithFallback(FuncSpec{FuncName: "F", PkgPath: "example.com/fallback"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lines := []int{}
+		for _, f := range failures {
+			lines = append(lines, f.Pos.Line)
+		}
+		sort.Ints(lines)
+		if !reflect.DeepEqual(lines, []int{9, 14, 18}) {
+			t.Errorf("unexpected failures: %v", failures)
+		}
+
+		expects := map[string][]string{
+			"fallback.go": {
+				"func F(ctx context.Context) int",
+				"func G() int {\n\treturn F()\n}",
+				"func H(ctx context.Context) int {\n\treturn F(ctx)",
+			},
+		}
+		testFileContents(t, app, expects)
+	})
+}
+
+func TestRewrite_RewriteComment(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:         exported.Config,
+		NoStub:         true,
+		Exclude:        []string{"commenting.go:21"},
+		RewriteComment: true,
+	}
+
+	err := app.Load("example.com/commenting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/commenting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"commenting.go": {
+			"func a(ctx context.Context) {\n\tF(ctx)\n}",
+			"\tn++\n\t// TODO(goctxize): add ctx here (no variable in scope)\n\tF()\n",
+			"\t\t// TODO(goctxize): add ctx here (excluded)\n\t\tF()\n",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_DotImport(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/dotimport")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/dotimport"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"dotimport.go": {
+			"import (\n\t. \"context\"\n)\n",
+			"func F(ctx Context)",
+			"\tF(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestEstimateEffort(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/chain/base", "example.com/chain/top")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := app.EstimateEffort(FuncSpec{FuncName: "F", PkgPath: "example.com/chain/base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := EffortReport{
+		Exported:  true,
+		CallSites: 2,
+		Packages:  []string{"example.com/chain/mid"},
+	}
+	if !reflect.DeepEqual(report, expected) {
+		t.Errorf("expected %+v but got %+v", expected, report)
+	}
+
+	report, err = app.EstimateEffort(FuncSpec{FuncName: "f", PkgPath: "example.com/chain/base"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(report, EffortReport{}) {
+		t.Errorf("expected no effort but got %+v", report)
+	}
+}
+
+func TestRewrite_WrappedInHelper(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/wrapped")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"F", "Check"} {
+		err = app.Rewrite(FuncSpec{FuncName: name, PkgPath: "example.com/wrapped"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expects := map[string][]string{
+		"wrapped.go": {
+			"func F(ctx context.Context, x int) (int, error)",
+			"func Check(ctx context.Context, x int) error",
+			"mustOK(Check(ctx, 1))",
+			"return must(F(ctx, 2))",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_RequestContext(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/handling")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/handling"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"handling.go": {
+			"func Handle(w http.ResponseWriter, r *http.Request) {\n\tF(r.Context())\n}",
+			"func(w http.ResponseWriter, req *http.Request) {\n\t\tF(req.Context())\n\t}",
+			"func NotHandler(r *http.Request) {\n\tctx := context.TODO()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteWithRetry(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/consumer"), testPackage("example.com/vendored")})
+	defer exported.Cleanup()
+
+	// drop the requirement to be restored by go mod tidy
+	goMod := filepath.Join(exported.Config.Dir, "go.mod")
+	err := os.WriteFile(goMod, []byte("module example.com/consumer\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported.Config.Env = append(exported.Config.Env, "GOFLAGS=-mod=readonly")
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err = app.RewriteWithRetry(FuncSpec{FuncName: "Store", PkgPath: "example.com/consumer"}, "example.com/consumer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(goMod)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "example.com/vendored") {
+		t.Errorf("go.mod should require example.com/vendored but got:\n%s", content)
+	}
+
+	expects := map[string][]string{
+		"consumer.go": {"func Store(ctx context.Context)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_ConflictResolver(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	var conflicts [][2]string
+	app := &App{
+		Config: exported.Config,
+		ConflictResolver: func(existingStub, newStub string) string {
+			conflicts = append(conflicts, [2]string{existingStub, newStub})
+			return newStub
+		},
+	}
+
+	err := app.Load("example.com/conflicting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/conflicting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app.VarSpec.InitExpr = "context.Background()"
+
+	err = app.Rewrite(FuncSpec{FuncName: "G", PkgPath: "example.com/conflicting"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := [][2]string{{"context.TODO()", "context.Background()"}}; !reflect.DeepEqual(conflicts, expected) {
+		t.Errorf("expected conflicts %v but got %v", expected, conflicts)
+	}
+
+	expects := map[string][]string{
+		"conflicting.go": {
+			"func caller() {\n\tctx := context.Background()\n",
+			"F(ctx)\n\tG(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestWriteGenerateScript(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:            exported.Config,
+		AnnotateCallSites: true,
+		Exclude:           []string{"bar.go:8"},
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := app.IsRewritten(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("F should not be rewritten yet")
+	}
+
+	for _, spec := range []FuncSpec{{FuncName: "F", PkgPath: "example.com/foo"}, {FuncName: "bar", PkgPath: "example.com/bar"}} {
+		err = app.Rewrite(spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ok, err = app.IsRewritten(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("F should be rewritten")
+	}
+
+	var buf strings.Builder
+	err = app.WriteGenerateScript(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := "-var 'ctx context.Context = context.TODO()' -annotate -exclude bar.go:8"
+	expected := "#!/bin/sh\n# Code generated by go-ctxize. DO NOT EDIT.\n\nset -e\n" +
+		"\ngoctxize -check " + args + " example.com/foo.F example.com/foo example.com/bar || goctxize " + args + " example.com/foo.F example.com/foo example.com/bar\n" +
+		"\ngoctxize -check " + args + " example.com/bar.bar example.com/foo example.com/bar || goctxize " + args + " example.com/bar.bar example.com/foo example.com/bar\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected script:\n%s", buf.String())
+	}
+
+	app.RewriteCompatMode = true
+	app.SQLCMode = true
+	app.WireMode = true
+	app.NamedArgMode = true
+	app.AcknowledgePluginABIChange = true
+	app.TemplateFiles = []string{"gen/client.go.tmpl"}
+
+	buf.Reset()
+	err = app.WriteGenerateScript(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args = "-var 'ctx context.Context = context.TODO()' -shim -annotate -sqlc -wire -named-args -acknowledge-plugin-abi-change -exclude bar.go:8 -template gen/client.go.tmpl"
+	if !strings.Contains(buf.String(), "\ngoctxize -check "+args+" example.com/foo.F ") {
+		t.Errorf("unexpected script:\n%s", buf.String())
+	}
+}
+
+func TestRewrite_PoolNew(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/pooled")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failures, err := app.RewriteWithFallback(FuncSpec{FuncName: "NewBuffer", PkgPath: "example.com/pooled"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []int{}
+	for _, f := range failures {
+		var poolErr *PoolContextError
+		if !xerrors.As(f.Err, &poolErr) {
+			t.Errorf("expected PoolContextError but got %v", f.Err)
+			continue
+		}
+		lines = append(lines, poolErr.Line)
+	}
+	sort.Ints(lines)
+	if !reflect.DeepEqual(lines, []int{17, 23, 26}) {
+		t.Errorf("unexpected failures: %v", failures)
+	}
+}
+
+func TestRewriteWithSemVer(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/semver", "example.com/semver/v2", "example.com/semver/caller")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteWithSemVer(FuncSpec{FuncName: "F", PkgPath: "example.com/semver"}, "v1", "v2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"semver.go": {"func F(ctx context.Context, x int) int {\n\treturn x * 2"},
+		"caller.go": {"\t\"example.com/semver/v2\"\n", "return semver.F(ctx, 1)"},
+	}
+	testFileContents(t, app, expects)
+
+	err = app.Each(func(filename string, content []byte) error {
+		if strings.Contains(string(content), "return x\n") {
+			t.Errorf("%s of v1 should not be modified", filename)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestValidateCallSiteContexts(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/validated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/validated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	warnings := app.ValidateCallSiteContexts()
+	if len(warnings) != 1 {
+		t.Fatalf("expected 1 warning but got %v", warnings)
+	}
+
+	w := warnings[0]
+	if filepath.Base(w.Pos.Filename) != "validated.go" || w.Pos.Line != 13 {
+		t.Errorf("unexpected position: %v", w.Pos)
+	}
+	if w.Severity != SeverityWarning {
+		t.Errorf("expected severity %q but got %q", SeverityWarning, w.Severity)
+	}
+}
+
+func TestRewrite_PluginABIChange(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/plugged")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/plugged"})
+	if _, ok := err.(*PluginABIChangeError); !ok {
+		t.Fatalf("expected PluginABIChangeError but got %v", err)
+	}
+
+	app.AcknowledgePluginABIChange = true
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/plugged"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"plugged.go": {
+			"func F(ctx context.Context) {",
+			"F(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewritePackage(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/surface")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewritePackage("example.com/surface")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"surface.go": {
+			"func (c *Client) Do(ctx context.Context) {\n\tFetch(ctx)\n}",
+			"func Fetch(ctx context.Context) {\n\thelper()\n}",
+			"func Already(ctx context.Context) {\n\tFetch(ctx)\n}",
+			"func Run(ctx context.Context) {\n\tc := &Client{}\n\tc.Do(ctx)\n}",
+			"func caller() {\n\tctx := context.TODO()\n\tRun(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_GenericVarType(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "tr",
+			PkgPath:  "example.com/generictrace/tracer",
+			TypeName: "Tracer",
+			TypeArgs: []types.Type{types.Typ[types.Int]},
+			InitExpr: "tracer.New()",
+		},
+	}
+
+	err := app.Load("example.com/generictrace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/generictrace"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"generictrace.go": {
+			"func F(tr tracer.Tracer[int]) {",
+			"func handle(t tracer.Tracer[int]) {\n\tF(t)\n}",
+			"func other(t tracer.Tracer[string]) {\n\ttr := tracer.New()\n\tF(tr)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestLoad_GenericVarTypeWithoutTypeArgs(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "tr",
+			PkgPath:  "example.com/generictrace/tracer",
+			TypeName: "Tracer",
+			InitExpr: "tracer.New()",
+		},
+	}
+
+	err := app.Load("example.com/generictrace")
+	if err == nil {
+		t.Fatal("expected error for generic type without type arguments")
+	}
+}
+
+func TestRewritePreservingGitBlame(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/blamed")})
+	defer exported.Cleanup()
+
+	dir := exported.Config.Dir
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"commit", "-q", "-m", "initial"},
+	} {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=Original Author", "GIT_AUTHOR_EMAIL=original@example.com",
+			"GIT_COMMITTER_NAME=Original Author", "GIT_COMMITTER_EMAIL=original@example.com",
+		)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %s: %s", args, err, out)
+		}
+	}
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/blamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewritePreservingGitBlame(FuncSpec{FuncName: "F", PkgPath: "example.com/blamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, BlameDatabaseFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var entries []BlameEntry
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%s:%d %s %s <%s>", e.File, e.Line, e.Kind, e.Author, e.AuthorMail))
+	}
+	expected := []string{
+		"blamed.go:3 decl Original Author <original@example.com>",
+		"blamed.go:7 call Original Author <original@example.com>",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v but got %v", expected, got)
+	}
+}
+
+func TestRewriteHistory(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	exported := packagestest.Export(t, packagestest.Modules, []packagestest.Module{testPackage("example.com/blamed")})
+	defer exported.Cleanup()
+
+	t.Setenv("GIT_AUTHOR_NAME", "Original Author")
+	t.Setenv("GIT_AUTHOR_EMAIL", "original@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "Original Author")
+	t.Setenv("GIT_COMMITTER_EMAIL", "original@example.com")
+
+	dir := exported.Config.Dir
+	git := func(args ...string) string {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %v: %s%s", args, err, exitErrorStderr(err))
+		}
+		return string(out)
+	}
+
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "initial")
+	git("checkout", "-q", "-b", "feature")
+	err := os.WriteFile(filepath.Join(dir, "extra.go"), []byte("package blamed\n\nfunc extra() {\n\tF()\n}\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "add extra")
+	git("checkout", "-q", "-")
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err = app.Load("example.com/blamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/blamed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteHistory("feature")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for file, expected := range map[string]string{
+		"blamed.go": "func F(ctx context.Context) {",
+		"extra.go":  "\tF(ctx)\n",
+	} {
+		if content := git("show", "feature:"+file); !strings.Contains(content, expected) {
+			t.Errorf("%s of feature should contain %q:\n%s", file, expected, content)
+		}
+	}
+	if log := git("log", "--format=%s", "feature"); log != "add extra\ninitial\n" {
+		t.Errorf("unexpected log of feature:\n%s", log)
+	}
+
+	// the working tree is left untouched
+	if content := git("diff", "HEAD"); content != "" {
+		t.Errorf("working tree should not be modified:\n%s", content)
+	}
+}
+
+func TestFunctionExists(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	tests := []struct {
+		spec   FuncSpec
+		exists bool
+	}{
+		{FuncSpec{PkgPath: "example.com/foo", FuncName: "F"}, true},
+		{FuncSpec{PkgPath: "example.com/foo", FuncName: "G"}, false},
+		{FuncSpec{PkgPath: "example.com/converted", TypeName: "T", FuncName: "Method"}, true},
+		{FuncSpec{PkgPath: "example.com/converted", TypeName: "U", FuncName: "Method"}, false},
+		{FuncSpec{PkgPath: "example.com/foo", FuncNameRE: regexp.MustCompile(`^F$`)}, true},
+	}
+	for _, test := range tests {
+		exists, err := app.FunctionExists(test.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != test.exists {
+			t.Errorf("FunctionExists(%s): expected %v but got %v", test.spec, test.exists, exists)
+		}
+	}
+
+	// without Config, looked up in the current directory
+	exists, err := (&App{}).FunctionExists(FuncSpec{PkgPath: "context", FuncName: "WithCancel"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists {
+		t.Error("FunctionExists(context.WithCancel): expected true but got false")
+	}
+}
+
+func TestRewrite_TypeConstraint(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/constrained")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{TypeName: "T", FuncName: "Run", PkgPath: "example.com/constrained"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"constrained.go": {
+			"type Runner interface {\n\tRun(ctx context.Context, n int)\n}",
+			"func (T) Run(ctx context.Context, n int) {",
+			"r.Run(ctx, 1)",
+			"T{}.Run(ctx, 2)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteWithShadowCheck(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:                 exported.Config,
+		RewriteWithShadowCheck: true,
+	}
+
+	err := app.Load("example.com/shadowed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/shadowed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	visited := false
+	err = app.Each(func(filename string, content []byte) error {
+		visited = true
+		return nil
+	})
+	shadowErr, ok := err.(*ShadowError)
+	if !ok {
+		t.Fatalf("expected ShadowError but got %v", err)
+	}
+	if visited {
+		t.Error("files should not be visited")
+	}
+
+	if len(shadowErr.Results) != 1 {
+		t.Fatalf("expected 1 result but got %v", shadowErr.Results)
+	}
+	if r := shadowErr.Results[0]; filepath.Base(r.Position.Filename) != "shadowed.go" || r.Position.Line != 13 {
+		t.Errorf("unexpected result: %v", r)
+	}
+}
+
+func TestRewriteReporter(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ch := make(chan ChangeEvent)
+	app.RewriteReporter(ch)
+
+	var events []ChangeEvent
+	done := make(chan struct{})
+	go func() {
+		for e := range ch {
+			events = append(events, e)
+		}
+		close(done)
+	}()
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	close(ch)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != len(app.Changes()) {
+		t.Fatalf("expected %d events but got %d", len(app.Changes()), len(events))
+	}
+	for i, e := range events {
+		c := app.Changes()[i]
+		if e.Kind != c.Kind || e.Filename != c.File || e.Line != c.Line {
+			t.Errorf("event %v does not match change %v", e, c)
+		}
+		if e.FuncSpec.String() != "example.com/foo.F" {
+			t.Errorf("unexpected spec: %s", e.FuncSpec)
+		}
+	}
+}
+
+func TestGenerateMermaid(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/diagram")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"F", "G"} {
+		err = app.Rewrite(FuncSpec{FuncName: name, PkgPath: "example.com/diagram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := app.GenerateMermaid(FuncSpec{FuncName: "F", PkgPath: "example.com/diagram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `sequenceDiagram
+    participant p0 as diagram.H
+    participant p1 as diagram.G
+    participant p2 as diagram.F
+    participant p3 as diagram.K
+    Note over p0: ctx := context.TODO()
+    p0->>p1: ctx
+    p1->>p2: ctx
+    p3->>p2: ctx
+`
+	if got != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, got)
+	}
+}
+
+func TestRewrite_FactoryMethod(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:            "ctx",
+			PkgPath:         "context",
+			TypeName:        "Context",
+			InitExpr:        "context.TODO()",
+			FactoryReceiver: "example.com/factory.Job",
+			FactoryMethod:   "Context",
+		},
+	}
+
+	err := app.Load("example.com/factory")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/factory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"run.go":   {"package factory\n\nfunc run(job *Job) {\n\tctx := job.Context()\n\tF(ctx)\n}"},
+		"other.go": {"import \"context\"", "ctx := context.TODO()\n\tF(ctx)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteNoImport(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:          exported.Config,
+		RewriteNoImport: true,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "foo.go" && strings.Contains(string(content), "import") {
+			t.Errorf("%s: import should not be added:\n%s", filename, content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"foo.go": {"func F(ctx context.Context)"},
+		"bar.go": {"foo.F(ctx)"},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_ExtractMode(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/extracting")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// ambiguous unless Mode is specified
+	err = app.Rewrite(FuncSpec{FuncName: "GetContext", PkgPath: "example.com/extracting"})
+	if err == nil || !strings.Contains(err.Error(), "set Mode") {
+		t.Fatalf("expected error about Mode but got %v", err)
+	}
+
+	// as well when matched by FuncNameRE
+	err = app.Rewrite(FuncSpec{FuncNameRE: regexp.MustCompile("^Get"), PkgPath: "example.com/extracting"})
+	if err == nil || !strings.Contains(err.Error(), "set Mode") {
+		t.Fatalf("expected error about Mode but got %v", err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncNameRE: regexp.MustCompile("^Get"), PkgPath: "example.com/extracting", Mode: ModeExtract})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Changes()) != 0 {
+		t.Fatalf("expected no changes but got %v", app.Changes())
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "GetContext", PkgPath: "example.com/extracting", Mode: ModeExtract})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Changes()) != 0 {
+		t.Fatalf("expected no changes but got %v", app.Changes())
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "GetContext", PkgPath: "example.com/extracting", Mode: ModeInject})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"extracting.go": {
+			"func GetContext(ctx context.Context, x int) (context.Context, error) {",
+			"GetContext(ctx, 1)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestApplyPatch(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patch := "--- a/foo.go\n" +
+		"+++ b/foo.go\n" +
+		"@@ -1,4 +1,6 @@\n" +
+		" package foo\n" +
+		"\n" +
+		"-func F() {\n" +
+		"+import \"context\"\n" +
+		"+\n" +
+		"+func F(ctx context.Context) {\n" +
+		" }\n"
+
+	err = app.ApplyPatch([]byte(patch))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := map[string]string{}
+	err = app.Each(func(filename string, content []byte) error {
+		contents[filename] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{
+		"foo.go": "package foo\n\nimport \"context\"\n\nfunc F(ctx context.Context) {\n}\n",
+	}
+	if !reflect.DeepEqual(contents, expected) {
+		t.Errorf("expected %q but got %q", expected, contents)
+	}
+
+	err = app.ApplyPatch([]byte(patch))
+	if err == nil {
+		t.Error("applying the patch twice should fail")
+	}
+}
+
+func TestRewrite_GRPCInterceptor(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/intercepted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/intercepted"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"intercepted.go": {
+			"grpc.WithUnaryInterceptor(func(ctx context.Context, method string,",
+			"{\n\t\tF(ctx)\n\t\tF(ctx)\n\t\treturn nil\n\t})",
+			"func dialOption() grpc.DialOption {\n\treturn",
+			"{\n\tF(c)\n\treturn invoker(",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_TemplateFiles(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:        exported.Config,
+		TemplateFiles: []string{exported.File("example.com/templated", "gen.go.tmpl")},
+	}
+
+	err := app.Load("example.com/templated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/templated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var template string
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "gen.go.tmpl" {
+			template = string(content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "func {{.Name}}() {\n\ttemplated.F(ctx)\n\ttemplated.F(ctx, {{.Arg}})\n\tother.F()\n}\n"
+	if template != expected {
+		t.Errorf("expected %q but got %q", expected, template)
+	}
+
+	var lines []int
+	for _, w := range app.TemplateWarnings() {
+		lines = append(lines, w.Pos.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{2, 3}) {
+		t.Errorf("unexpected warnings: %v", app.TemplateWarnings())
+	}
+}
+
+func TestRewrite_ExternalTests(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/exttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/exttest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"exttest.go":      {"func caller() {\n\tctx := context.TODO()\n\tF(ctx)\n}"},
+		"exttest_test.go": {"ctx := context.Background()\n\texttest.F(ctx)", "\"context\""},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestLoad_ExcludeExternalTests(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:               exported.Config,
+		ExcludeExternalTests: true,
+	}
+
+	err := app.Load("example.com/exttest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/exttest"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error {
+		if filepath.Base(filename) == "exttest_test.go" {
+			t.Errorf("%s should not be rewritten", filename)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewrite_NestedClosures(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/nestedclosure")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/nestedclosure"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"nested.go": {
+			"func A(ctx context.Context) {\n\tgo func() {\n\t\tfunc() {\n\t\t\tF(ctx)",
+			"var handler = func(ctx context.Context) {\n\tgo func() {\n\t\tfunc() {\n\t\t\tF(ctx)",
+			"func B() {\n\tctx := context.TODO()\n\tgo func() {\n\t\tfunc() {\n\t\t\tF(ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_Concurrent(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"F", "G", "H"} {
+		wg.Add(2)
+		go func(name string) {
+			defer wg.Done()
+			err := app.Rewrite(FuncSpec{FuncName: name, PkgPath: "example.com/concurrent"})
+			if err != nil {
+				t.Error(err)
+			}
+		}(name)
+		go func() {
+			defer wg.Done()
+			err := app.Each(func(filename string, content []byte) error { return nil })
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	expects := map[string][]string{
+		"concurrent.go": {"func caller() {\n\tctx := context.TODO()\n\tF(ctx)\n\tG(ctx)\n\tH(ctx)\n}"},
+	}
+	testFileContents(t, app, expects)
+}
+
+// TestConcurrentEntryPoints is meant to be run with -race.
+func TestConcurrentEntryPoints(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/concurrent")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := []func() error{
+		func() error { return app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/concurrent"}) },
+		func() error {
+			_, err := app.RewriteWithFallback(FuncSpec{FuncName: "G", PkgPath: "example.com/concurrent"})
+			return err
+		},
+		func() error { return app.RewriteTransitive(FuncSpec{FuncName: "H", PkgPath: "example.com/concurrent"}, 0) },
+		func() error { return app.Each(func(filename string, content []byte) error { return nil }) },
+		func() error { app.RewriteReporter(nil); return nil },
+		func() error { _ = app.Changes(); return nil },
+		func() error { _ = app.ValidateCallSiteContexts(); return nil },
+		func() error { _, err := app.IsRewritten(FuncSpec{FuncName: "F", PkgPath: "example.com/concurrent"}); return err },
+		func() error { _, err := app.LintCoverage(); return err },
+		func() error { _, err := app.SuggestFuncSpec(); return err },
+		func() error { return app.WriteGenerateScript(io.Discard) },
+		func() error { return app.ApplyPatch(nil) },
+	}
+
+	var wg sync.WaitGroup
+	for _, call := range calls {
+		wg.Add(1)
+		go func(call func() error) {
+			defer wg.Done()
+			if err := call(); err != nil {
+				t.Error(err)
+			}
+		}(call)
+	}
+	wg.Wait()
+
+	expects := map[string][]string{
+		"concurrent.go": {"func caller() {\n\tctx := context.TODO()\n\tF(ctx)\n\tG(ctx)\n\tH(ctx)\n}"},
+	}
+	testFileContents(t, app, expects)
+
+	if changes := app.Changes(); len(changes) != 7 {
+		t.Errorf("expected 7 changes but got %+v", changes)
+	}
+}
+
+func TestSQLCWarnings(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:   exported.Config,
+		SQLCMode: true,
+	}
+
+	err := app.Load("example.com/sqlcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{PkgPath: "example.com/sqlcgen", TypeName: "Queries", FuncName: "logQuery"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"query.sql.go": {"func (q *Queries) ListAuthors() error {\n\tctx := context.TODO()\n\treturn q.logQuery(ctx, listAuthors)"},
+		"queries.go":   {"func (q *Queries) logQuery(ctx context.Context, query string) error"},
+	}
+	testFileContents(t, app, expects)
+
+	warnings := app.SQLCWarnings()
+	if len(warnings) != 2 {
+		t.Fatalf("expected 2 warnings but got %+v", warnings)
+	}
+	for _, w := range warnings {
+		if filepath.Base(w.Pos.Filename) != "query.sql.go" || !strings.Contains(w.Message, "sqlc.yaml") {
+			t.Errorf("unexpected warning: %+v", w)
+		}
+	}
+}
+
+func TestRewriteForGin(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/ginhandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteForGin(FuncSpec{FuncName: "F", PkgPath: "example.com/ginhandler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"ginhandler.go": {
+			"func F(ctx context.Context)",
+			"func Handle(c *gin.Context) {\n\tF(c)\n}",
+			"func G() {\n\tctx := context.TODO()\n\tF(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteForGin_ginContext(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "c",
+			PkgPath:  "github.com/gin-gonic/gin",
+			TypeName: "Context",
+			InitExpr: "&gin.Context{}",
+		},
+	}
+
+	err := app.Load("example.com/ginhandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteForGin(FuncSpec{FuncName: "F", PkgPath: "example.com/ginhandler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"ginhandler.go": {
+			"func F(c *gin.Context)",
+			"func Handle(c *gin.Context) {\n\tF(c)\n}",
+			"func G() {\n\tc := &gin.Context{}\n\tF(c)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteForFiber(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/fiberhandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteForFiber(FuncSpec{FuncName: "F", PkgPath: "example.com/fiberhandler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"fiberhandler.go": {
+			"func F(ctx context.Context)",
+			"func Handle(c *fiber.Ctx) error {\n\tF(c.UserContext())\n",
+			"func G() {\n\tctx := context.TODO()\n\tF(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteForFiber_fiberCtx(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "c",
+			PkgPath:  "github.com/gofiber/fiber/v2",
+			TypeName: "Ctx",
+			InitExpr: "&fiber.Ctx{}",
+		},
+	}
+
+	err := app.Load("example.com/fiberhandler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteForFiber(FuncSpec{FuncName: "F", PkgPath: "example.com/fiberhandler"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"fiberhandler.go": {
+			"func F(c *fiber.Ctx)",
+			"func Handle(c *fiber.Ctx) error {\n\tF(c)\n",
+			"func G() {\n\tc := &fiber.Ctx{}\n\tF(c)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_WireMode(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:   exported.Config,
+		WireMode: true,
+	}
+
+	err := app.Load("example.com/wired")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "NewFoo", PkgPath: "example.com/wired"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"wired.go": {"func NewFoo(ctx context.Context) *Foo"},
+		"wire.go": {
+			"func InitializeBar(ctx context.Context) *Bar {",
+			"func InitializeNothing() *Bar {",
+			`"context"`,
+		},
+	}
+	testFileContents(t, app, expects)
+
+	if len(app.wireDirs) != 1 {
+		t.Errorf("expected 1 directory to run wire gen but got %v", app.wireDirs)
+	}
+}
+
+func TestPreviewSideBySide(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = app.PreviewSideBySide(10)
+	if err == nil {
+		t.Error("PreviewSideBySide should fail for too narrow width")
+	}
+
+	preview, err := app.PreviewSideBySide(80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(preview)
+
+	for _, s := range []string{
+		"\x1b[1mfoo.go\x1b[0m",
+		"\x1b[31mfunc F() {",
+		"\x1b[32mfunc F(ctx context.Context) {",
+		"\x1b[32m    ctx := context.TODO()",
+	} {
+		if !strings.Contains(preview, s) {
+			t.Errorf("preview should contain %q", s)
+		}
+	}
+
+	rxANSI := regexp.MustCompile("\x1b\\[[0-9]*m")
+	for _, line := range strings.Split(preview, "\n") {
+		if n := len([]rune(rxANSI.ReplaceAllString(line, ""))); n > 80 {
+			t.Errorf("line exceeds width: %q", line)
+		}
+	}
+}
+
+func TestRewriteTransitive(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/transitive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteTransitive(FuncSpec{FuncName: "C", PkgPath: "example.com/transitive"}, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"transitive.go": {
+			"func C(ctx context.Context) {",
+			"func B(ctx context.Context) {\n\tC(ctx)\n}",
+			"func A(ctx context.Context) {\n\tB(ctx)\n}",
+			"func D(ctx context.Context) {\n\tE(ctx)\n\tC(ctx)\n}",
+			"func E(ctx context.Context) {\n\tD(ctx)\n}",
+			"!context.TODO()",
+		},
+		"transitive_test.go": {
+			"func TestA(t *testing.T) {\n\tctx := context.TODO()\n\tA(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteTransitive_maxDepth(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/transitive")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.RewriteTransitive(FuncSpec{FuncName: "C", PkgPath: "example.com/transitive"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"transitive.go": {
+			"func C(ctx context.Context) {",
+			"func B(ctx context.Context) {\n\tC(ctx)\n}",
+			"func A() {\n\tctx := context.TODO()\n\tB(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_DryRun(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		DryRun: true,
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	originals := map[string][]byte{}
+	for _, name := range []string{"foo/foo.go", "bar/bar.go"} {
+		filename := exported.File(path.Dir("example.com/"+name), path.Base(name))
+		originals[filename], err = os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Each(func(filename string, content []byte) error { return nil })
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for filename, orig := range originals {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != string(orig) {
+			t.Errorf("%s should not be modified but got:\n%s", filename, content)
+		}
+	}
+
+	changes, err := app.FileChanges()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := false
+	for _, c := range changes {
+		if filepath.Base(c.Filename) != "foo.go" {
+			continue
+		}
+		found = true
+
+		if !strings.Contains(string(c.Original), "func F()") || !strings.Contains(string(c.Rewritten), "func F(ctx context.Context)") {
+			t.Errorf("unexpected change: %s", c.Filename)
+		}
+		// "import" and a blank line are added, and the declaration is changed
+		if added, deleted := c.LineCounts(); added != 3 || deleted != 1 {
+			t.Errorf("expected +3 -1 lines but got +%d -%d", added, deleted)
+		}
+	}
+	if !found {
+		t.Errorf("foo.go not found in %v", changes)
+	}
+}
+
+func TestRewrite_DiffMode(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:   exported.Config,
+		DiffMode: true,
+	}
+
+	err := app.Load("example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs := map[string]string{}
+	err = app.Each(func(filename string, diff []byte) error {
+		diffs[filename] = string(diff)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diff := diffs["foo.go"]
+	for _, s := range []string{"--- a/foo.go\n", "+++ b/foo.go\n", "\n-func F() {\n", "\n+func F(ctx context.Context) {\n"} {
+		if !strings.Contains(diff, s) {
+			t.Errorf("diff should contain %q but got:\n%s", s, diff)
+		}
+	}
+
+	rewritten := map[string]string{}
+	app.DiffMode = false
+	err = app.Each(func(filename string, content []byte) error {
+		rewritten[filename] = string(content)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	patched := &App{
+		Config: exported.Config,
+	}
+
+	err = patched.Load("example.com/foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = patched.ApplyPatch([]byte(diff))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = patched.Each(func(filename string, content []byte) error {
+		if string(content) != rewritten[filename] {
+			t.Errorf("%s: patched content should be %q but got %q", filename, rewritten[filename], content)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRewrite_callInEarlyReturn(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/earlyreturn")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/earlyreturn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the variable is declared before the if statements calling F in their init
+	expects := map[string][]string{
+		"earlyreturn.go": {
+			"func G(x int) error {\n\tctx := context.TODO()\n\tif err := F(ctx, x); err != nil {",
+			"func H(x int) error {\n\tctx := context.TODO()\n\tif x < 0 {\n\t\treturn nil\n\t}\n\tif err := F(ctx, x); err != nil {\n\t\treturn err\n\t}\n\treturn F(ctx, x+1)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_resultShadowingPackage(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/resultname")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/resultname"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the result named "context" would shadow the package in the stub
+	expects := map[string][]string{
+		"resultname.go": {
+			"func F(ctx context.Context) (context error) {",
+			"func G() (context_ error) {\n\tctx := context.TODO()\n\tcontext_ = F(ctx)\n\tif context_ != nil {\n\t\treturn\n\t}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_NamedArgMode(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:       exported.Config,
+		NamedArgMode: true,
+	}
+
+	err := app.Load("example.com/namedargs", "example.com/namedargs/caller")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/namedargs"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"namedargs.go": {
+			"type FArgs struct {\n\tCtx   context.Context\n\tName  string\n\tCount int\n}\n\nfunc F(args FArgs) string {\n\treturn fmt.Sprintf(\"%s:%d\", args.Name, args.Count)\n}",
+			"func G(name string) string {\n\tctx := context.TODO()\n\treturn F(FArgs{Ctx: ctx, Name: name, Count: 1})\n}",
+		},
+		"caller.go": {
+			"func H() string {\n\tctx := context.TODO()\n\treturn namedargs.F(namedargs.FArgs{Ctx: ctx, Name: \"h\", Count: 2})\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewriteAll(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// G calls F, so is rewritten after F
+	err = app.RewriteAll([]FuncSpec{
+		{FuncName: "G", PkgPath: "example.com/batch"},
+		{FuncName: "F", PkgPath: "example.com/batch"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"batch.go": {
+			"func F(ctx context.Context) {\n}",
+			"func G(ctx context.Context) {\n\tF(ctx)\n}",
+			"func H() {\n\tctx := context.TODO()\n\tG(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_callInGoroutine(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/goroutine")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the variable in the goroutine literals or the enclosing scopes is passed without stubs
+	expects := map[string][]string{
+		"goroutine.go": {
+			"func Outer(ctx context.Context) {\n\tgo func() {\n\t\tfoo.F(ctx)\n\t}()\n}",
+			"func Own() {\n\tgo func(ctx context.Context) {\n\t\tfoo.F(ctx)\n\t}(context.Background())\n}",
+			"func Nested(ctx context.Context) {\n\tgo func() {\n\t\tfunc() {\n\t\t\tfoo.F(ctx)\n\t\t}()\n\t}()\n}",
+			"!context.TODO()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_ChanType(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "ctx",
+			PkgPath:  "context",
+			TypeName: "Context",
+			InitExpr: "context.TODO()",
+			ChanType: true,
+		},
+	}
+
+	err := app.Load("example.com/donechan")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/donechan"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects := map[string][]string{
+		"f.go": {
+			"func F(done <-chan struct{}, x int) {\n}",
+			"!import",
+		},
+		"donechan.go": {
+			"func G(ctx context.Context) {\n\tF(ctx.Done(), 1)\n}",
+			"func H() {\n\tctx := context.TODO()\n\tF(ctx.Done(), 2)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_BeforeRewrite(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	var called []string
+	app := &App{
+		Config: exported.Config,
+		BeforeRewrite: func(spec FuncSpec) error {
+			called = append(called, spec.FuncName)
+			if spec.FuncName == "F" {
+				return xerrors.New("not on the migration list")
+			}
+			return nil
+		},
+	}
+
+	err := app.Load("example.com/batch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"F", "G"} {
+		err = app.Rewrite(FuncSpec{FuncName: name, PkgPath: "example.com/batch"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !reflect.DeepEqual(called, []string{"F", "G"}) {
+		t.Errorf("BeforeRewrite should be called with F and G but got %v", called)
+	}
+
+	expects := map[string][]string{
+		"batch.go": {
+			"func F() {\n}",
+			"func G(ctx context.Context) {\n\tF()\n}",
+			"func H() {\n\tctx := context.TODO()\n\tG(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_callInDefer(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/deferred")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"F", "G"} {
+		err = app.Rewrite(FuncSpec{FuncName: name, PkgPath: "example.com/deferred"})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the variable is declared right before the defer statements,
+	// or at the top if called before them
+	expects := map[string][]string{
+		"deferred.go": {
+			"func A() {\n\tctx := context.TODO()\n\tdefer F(ctx)\n}",
+			"func B(x int) {\n\tif x > 0 {\n\t\treturn\n\t}\n\tctx := context.TODO()\n\tdefer F(ctx)\n}",
+			"func C() {\n\tctx := context.TODO()\n\tG(ctx)\n\tdefer F(ctx)\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_AfterRewrite(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	modified := map[string][]string{}
+	var contents []string
+	var app *App
+	app = &App{
+		Config: exported.Config,
+		AfterRewrite: func(spec FuncSpec, filenames []string) {
+			for _, filename := range filenames {
+				modified[spec.FuncName] = append(modified[spec.FuncName], filepath.Base(filename))
+			}
+
+			// must not deadlock
+			err := app.Each(func(filename string, content []byte) error {
+				contents = append(contents, filepath.Base(filename))
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		},
+	}
+
+	err := app.Load("example.com/foo", "example.com/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// bar.go is in the module cache, sorted first by its relative path
+	expected := map[string][]string{"F": {"bar.go", "foo.go", "foo_test.go"}}
+	if !reflect.DeepEqual(modified, expected) {
+		t.Errorf("expected %v but got %v", expected, modified)
+	}
+	sort.Strings(contents)
+	if !reflect.DeepEqual(contents, expected["F"]) {
+		t.Errorf("expected contents of %v but got %v", expected["F"], contents)
+	}
+}
+
+func TestRewrite_PropagateToInterfaces(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config:                exported.Config,
+		PropagateToInterfaces: true,
+	}
+
+	err := app.Load("example.com/propagated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Get", TypeName: "store", PkgPath: "example.com/propagated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the method is declared in Getter, which is embedded in ReadCloser
+	expects := map[string][]string{
+		"propagated.go": {
+			"type Getter interface {\n\tGet(ctx context.Context, key string) string\n}",
+			"type ReadCloser interface {\n\tGetter\n\tClose() error\n}",
+			"func (s *store) Get(ctx context.Context, key string) string {",
+			"func Use(g Getter) string {\n\tctx := context.TODO()\n\treturn g.Get(ctx, \"x\")\n}",
+		},
+	}
+	testFileContents(t, app, expects)
+
+	app = &App{
+		Config: exported.Config,
+		VarSpec: &VarSpec{
+			Name:     "ctx",
+			PkgPath:  "context",
+			TypeName: "Context",
+			InitExpr: "context.TODO()",
+			Position: -1,
+		},
+		PropagateToInterfaces: true,
+	}
+
+	err = app.Load("example.com/propagated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "Get", TypeName: "store", PkgPath: "example.com/propagated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expects = map[string][]string{
+		"propagated.go": {
+			"type Getter interface {\n\tGet(key string, ctx context.Context) string\n}",
+			"func (s *store) Get(key string, ctx context.Context) string {",
+			"return g.Get(\"x\", ctx)",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_callInNestedClosure(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	app := &App{
+		Config: exported.Config,
+	}
+
+	err := app.Load("example.com/foo", "example.com/closured")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/foo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the variables closed over from the outer function literals are passed without stubs
+	expects := map[string][]string{
+		"closured.go": {
+			"\t\tEach(items, func(item string) {\n\t\t\tfoo.F(ctx)\n\t\t})",
+			"\t\t\tfunc() {\n\t\t\t\tfoo.F(ctx)\n\t\t\t}()",
+			"!context.TODO()",
+		},
+	}
+	testFileContents(t, app, expects)
+}
+
+func TestRewrite_Position(t *testing.T) {
+	exported := packagestest.Export(t, packagestest.Modules, testdata)
+	defer exported.Cleanup()
+
+	tests := []struct {
+		position int
+		expects  []string
+		err      bool
+	}{
+		{
+			position: -1,
+			expects: []string{
+				"func F(a, b int, ctx context.Context) int {",
+				"return F(1, 2, ctx)",
+			},
+		},
+		{
+			position: 1,
+			expects: []string{
+				"func F(a int, ctx context.Context, b int) int {",
+				"return F(1, ctx, 2)",
+			},
+		},
+		{
+			position: 3,
+			err:      true,
+		},
+	}
+
+	for _, test := range tests {
+		app := &App{
+			Config: exported.Config,
+			VarSpec: &VarSpec{
+				Name:     "ctx",
+				PkgPath:  "context",
+				TypeName: "Context",
+				InitExpr: "context.TODO()",
+				Position: test.position,
+			},
+		}
+
+		err := app.Load("example.com/positioned")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = app.Rewrite(FuncSpec{FuncName: "F", PkgPath: "example.com/positioned"})
+		if test.err {
+			if err == nil {
+				t.Errorf("position %d should fail", test.position)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		testFileContents(t, app, map[string][]string{"positioned.go": test.expects})
+	}
+}
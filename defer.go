@@ -0,0 +1,63 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+)
+
+// topLevelDefer returns the index of the defer statement enclosing pos at the top level
+// of the body of funcDecl, eg. "defer F()" or "defer func() { F() }()", or -1 if none.
+// The variable for the call at pos is declared right before the statement.
+func topLevelDefer(funcDecl *ast.FuncDecl, pos token.Pos) int {
+	for i, stmt := range funcDecl.Body.List {
+		if stmt.Pos() <= pos && pos < stmt.End() {
+			if _, ok := stmt.(*ast.DeferStmt); ok {
+				return i
+			}
+			return -1
+		}
+	}
+
+	return -1
+}
+
+// hoistStub moves the variable declared by a previous rewrite before a defer statement of funcDecl
+// to the top of the body, if the call at pos comes before it.
+func (app *App) hoistStub(funcDecl *ast.FuncDecl, pos token.Pos) {
+	assign := app.stubs[funcDecl]
+	if assign == nil {
+		return
+	}
+
+	list := funcDecl.Body.List
+	i := 0
+	for i < len(list) && list[i] != assign {
+		i++
+	}
+	if i == 0 || i == len(list) {
+		return
+	}
+
+	before := false
+	for _, stmt := range list[:i] {
+		if stmt.Pos() <= pos && pos < stmt.End() {
+			before = true
+			break
+		}
+	}
+	if !before {
+		return
+	}
+
+	// along with the deferred ResultExpr, which is not positioned as generated
+	n := 1
+	if i+1 < len(list) && list[i+1].Pos() == token.NoPos {
+		n = 2
+	}
+
+	debugf("%s: moving the variable to the top", app.position(pos))
+
+	stmts := append([]ast.Stmt{}, list[i:i+n]...)
+	stmts = append(stmts, list[:i]...)
+	funcDecl.Body.List = append(stmts, list[i+n:]...)
+}
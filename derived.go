@@ -0,0 +1,150 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// derivedParentContext returns the parent context given to a context derivation which
+// defines the variable later in the function enclosing pos, eg. for the call F() below:
+//
+//	F()
+//	ctx, cancel := context.WithTimeout(context.Background(), d)
+//
+// it returns the same parent, so that the call can be given it without declaring a stub variable
+// which the derivation would redeclare. Parents which may have side effects, eg. "context.Background()",
+// are not copied but assigned to a variable declared at the beginning of the function, eg. "parentCtx",
+// which is then passed to both the call and the derivation.
+// Only parents not referring to local variables are returned.
+func (app *App) derivedParentContext(pkg *packages.Package, pos token.Pos) ast.Expr {
+	if app.VarSpec.PkgPath != "context" || app.VarSpec.TypeName != "Context" {
+		return nil
+	}
+
+	funcDecl, ok := app.findNodeEnclosing(pos, func(n ast.Node) (ok bool) { _, ok = n.(*ast.FuncDecl); return }).(*ast.FuncDecl)
+	if !ok || funcDecl.Body == nil {
+		return nil
+	}
+
+	for _, stmt := range funcDecl.Body.List {
+		if stmt.Pos() < pos {
+			continue
+		}
+
+		assign, ok := stmt.(*ast.AssignStmt)
+		if !ok || assign.Tok != token.DEFINE || len(assign.Rhs) != 1 {
+			continue
+		}
+		id, ok := assign.Lhs[0].(*ast.Ident)
+		if !ok || id.Name != app.VarSpec.Name {
+			continue
+		}
+
+		parent := contextDerivationParent(pkg.TypesInfo, assign.Rhs[0])
+		if parent == nil || refersToLocal(pkg, parent) {
+			continue
+		}
+
+		debugf("%s: found derivation from %s", app.position(assign.Pos()), app.nodeString(parent))
+
+		if !isSideEffectFree(parent) {
+			return app.declareDerivedParent(pkg, funcDecl, assign.Rhs[0].(*ast.CallExpr))
+		}
+
+		expr, err := parser.ParseExpr(app.nodeString(parent))
+		if err != nil {
+			debugf("BUG: parsing %s: %s", app.nodeString(parent), err)
+			return nil
+		}
+		clearPos(expr)
+
+		return expr
+	}
+
+	return nil
+}
+
+// declareDerivedParent declares a variable initialized by the parent argument of derivation
+// at the beginning of funcDecl, replaces the argument with it and returns it.
+func (app *App) declareDerivedParent(pkg *packages.Package, funcDecl *ast.FuncDecl, derivation *ast.CallExpr) ast.Expr {
+	scope := pkg.TypesInfo.Scopes[funcDecl.Type]
+	if scope == nil {
+		return nil
+	}
+
+	name := "parent" + strings.ToUpper(app.VarSpec.Name[:1]) + app.VarSpec.Name[1:]
+	for isDeclaredUnder(scope, name) {
+		name += "_"
+	}
+	scope.Insert(types.NewVar(token.NoPos, pkg.Types, name, app.VarSpec.varType()))
+
+	before := app.nodeString(derivation)
+	stmt := &ast.AssignStmt{
+		Lhs: []ast.Expr{ast.NewIdent(name)},
+		Tok: token.DEFINE,
+		Rhs: []ast.Expr{derivation.Args[0]},
+	}
+	derivation.Args[0] = &ast.Ident{Name: name, NamePos: derivation.Args[0].Pos()}
+	funcDecl.Body.List = append([]ast.Stmt{stmt}, funcDecl.Body.List...)
+
+	app.recordChange(ChangeStub, funcDecl.Body.Lbrace, "", app.nodeString(stmt))
+	app.recordChange(ChangeCall, derivation.Pos(), before, app.nodeString(derivation))
+	app.markModified(funcDecl.Pos())
+
+	debugf("%s: declared %s for the parent of derivation", app.position(derivation.Pos()), name)
+
+	return ast.NewIdent(name)
+}
+
+// isSideEffectFree reports whether expr is an identifier or a selector of one, eg. "ctx" or "s.ctx",
+// which can be evaluated more than once.
+func isSideEffectFree(expr ast.Expr) bool {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return true
+	case *ast.SelectorExpr:
+		return isSideEffectFree(e.X)
+	case *ast.ParenExpr:
+		return isSideEffectFree(e.X)
+	}
+
+	return false
+}
+
+// contextDerivationParent returns the parent argument if expr is a call of
+// context.WithCancel, context.WithTimeout or other functions deriving a context.
+func contextDerivationParent(info *types.Info, expr ast.Expr) ast.Expr {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) == 0 {
+		return nil
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return nil
+	}
+	f, ok := info.Uses[sel.Sel].(*types.Func)
+	if !ok || f.Pkg() == nil || f.Pkg().Path() != "context" || !strings.HasPrefix(f.Name(), "With") {
+		return nil
+	}
+
+	return call.Args[0]
+}
+
+// refersToLocal reports whether expr refers to any variables other than package-level ones.
+func refersToLocal(pkg *packages.Package, expr ast.Expr) bool {
+	found := false
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok {
+			if v, ok := pkg.TypesInfo.Uses[id].(*types.Var); ok && !v.IsField() && v.Parent() != v.Pkg().Scope() {
+				found = true
+			}
+		}
+		return !found
+	})
+	return found
+}
@@ -0,0 +1,131 @@
+package ctxize
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// diffContext is the number of unchanged lines around changes in hunks of unified diffs.
+const diffContext = 3
+
+// diffCallback returns a callback for eachContent which passes the unified diff
+// of each file to callback, skipping files not changed.
+func (app *App) diffCallback(callback func(filename string, diff []byte) error) func(filename string, content []byte) error {
+	return func(filename string, content []byte) error {
+		path := filename
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(app.Config.Dir, path)
+		}
+
+		// generated files may not exist yet
+		orig, err := os.ReadFile(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+			orig = nil
+		}
+
+		diff := unifiedDiff(filepath.ToSlash(filename), orig, content)
+		if diff == nil {
+			return nil
+		}
+
+		return callback(filename, diff)
+	}
+}
+
+// diffLine is a line of unified diff, whose kind is one of ' ', '-' and '+'.
+type diffLine struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns the unified diff from a to b of the file name, or nil if they are the same.
+// a is nil for files newly created.
+func unifiedDiff(name string, a, b []byte) []byte {
+	if bytes.Equal(a, b) {
+		return nil
+	}
+
+	var lines []diffLine
+	var deleted, added []diffLine
+	for _, row := range diffRows(splitLines(string(a)), splitLines(string(b))) {
+		if row.changed {
+			if row.hasOld {
+				deleted = append(deleted, diffLine{'-', row.old})
+			}
+			if row.hasNew {
+				added = append(added, diffLine{'+', row.new})
+			}
+			continue
+		}
+
+		lines = append(append(append(lines, deleted...), added...), diffLine{' ', row.old})
+		deleted, added = nil, nil
+	}
+	lines = append(append(lines, deleted...), added...)
+
+	var buf bytes.Buffer
+	if a == nil {
+		buf.WriteString("--- /dev/null\n")
+	} else {
+		fmt.Fprintf(&buf, "--- a/%s\n", name)
+	}
+	fmt.Fprintf(&buf, "+++ b/%s\n", name)
+
+	// line numbers, 1-based, of lines[i] in a and b
+	oldLine, newLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	oldLine[0], newLine[0] = 1, 1
+	for i, l := range lines {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if l.kind != '+' {
+			oldLine[i+1]++
+		}
+		if l.kind != '-' {
+			newLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk from start to end, joining changes within twice diffContext lines
+		start := max(i-diffContext, 0)
+		end := i
+		for j := i; j < len(lines) && j-end <= 2*diffContext; j++ {
+			if lines[j].kind != ' ' {
+				end = j + 1
+			}
+		}
+		end = min(end+diffContext, len(lines))
+
+		oldCount, newCount := oldLine[end]-oldLine[start], newLine[end]-newLine[start]
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n", hunkRange(oldLine[start], oldCount), hunkRange(newLine[start], newCount))
+		for _, l := range lines[start:end] {
+			buf.WriteByte(l.kind)
+			buf.WriteString(l.text)
+			buf.WriteByte('\n')
+		}
+
+		i = end
+	}
+
+	return buf.Bytes()
+}
+
+// hunkRange formats the range of a hunk header, where an empty range is denoted by the line before it.
+func hunkRange(line, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", line-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", line)
+	}
+	return fmt.Sprintf("%d,%d", line, count)
+}
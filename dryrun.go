@@ -0,0 +1,58 @@
+package ctxize
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// FileChange is a file modified or generated by the rewrites, with its contents before and after.
+type FileChange struct {
+	Filename  string
+	Original  []byte // nil for files newly generated
+	Rewritten []byte
+}
+
+// FileChanges returns the files visited by Each, in order of their names, along with
+// their original contents read from the files, eg. to preview the rewrites with DryRun.
+func (app *App) FileChanges() ([]FileChange, error) {
+	var changes []FileChange
+	err := app.eachContent(func(filename string, content []byte) error {
+		path := filename
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(app.Config.Dir, path)
+		}
+
+		orig, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		changes = append(changes, FileChange{Filename: filename, Original: orig, Rewritten: content})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Filename < changes[j].Filename })
+
+	return changes, nil
+}
+
+// LineCounts returns the numbers of lines added and deleted by c.
+func (c FileChange) LineCounts() (added, deleted int) {
+	for _, row := range diffRows(splitLines(string(c.Original)), splitLines(string(c.Rewritten))) {
+		if !row.changed {
+			continue
+		}
+		if row.hasNew {
+			added++
+		}
+		if row.hasOld {
+			deleted++
+		}
+	}
+
+	return
+}
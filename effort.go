@@ -0,0 +1,76 @@
+package ctxize
+
+import (
+	"sort"
+
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// EffortReport is an estimate of manual changes required to complete the migration
+// of a function, beyond the rewrite of the packages loaded.
+type EffortReport struct {
+	// whether the function is exported, and can be called from other packages
+	Exported bool
+	// number of call sites in packages outside of the rewrite
+	CallSites int
+	// import paths of packages outside of the rewrite calling the function, sorted
+	Packages []string
+}
+
+// EstimateEffort counts calls to the function specified by spec in the packages
+// which are not given to Load but imported by them, which are left untouched by Rewrite.
+// Packages which do not depend on any of the loaded packages cannot be taken into account,
+// so the estimate is a lower bound.
+func (app *App) EstimateEffort(spec FuncSpec) (EffortReport, error) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var report EffortReport
+
+	var err error
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return report, err
+	}
+
+	report.Exported = token.IsExported(spec.FuncName) && (spec.TypeName == "" || token.IsExported(spec.TypeName))
+
+	loaded := map[string]bool{}
+	for _, pkg := range app.pkgs {
+		loaded[pkg.PkgPath] = true
+	}
+
+	seen := map[string]bool{}
+	pkgPaths := map[string]bool{}
+	packages.Visit(app.pkgs, nil, func(pkg *packages.Package) {
+		if loaded[pkg.PkgPath] || pkg.TypesInfo == nil {
+			return
+		}
+		if !report.Exported && pkg.PkgPath != spec.PkgPath {
+			return
+		}
+
+		for id, obj := range pkg.TypesInfo.Uses {
+			if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+				pos := app.position(id.Pos()).String()
+				if seen[pos] {
+					continue
+				}
+				seen[pos] = true
+
+				report.CallSites++
+				pkgPaths[pkg.PkgPath] = true
+			}
+		}
+	})
+
+	for path := range pkgPaths {
+		report.Packages = append(report.Packages, path)
+	}
+	sort.Strings(report.Packages)
+
+	return report, nil
+}
@@ -0,0 +1,100 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+const errgroupPkgPath = "golang.org/x/sync/errgroup"
+
+// errgroupContext returns the name of the context derived by errgroup.WithContext
+// if the call at pos is inside a function literal given to Go method of the group, eg.
+//
+//	g, gctx := errgroup.WithContext(ctx)
+//	g.Go(func() error {
+//		return F(gctx) // <- pos
+//	})
+//
+// Only contexts are considered, so if the variable is of other types, it returns "".
+func (app *App) errgroupContext(info *types.Info, pos token.Pos) string {
+	if app.VarSpec.PkgPath != "context" || app.VarSpec.TypeName != "Context" {
+		return ""
+	}
+
+	path := app.pathEnclosing(pos)
+	for i, node := range path {
+		if _, ok := node.(*ast.FuncLit); !ok || i+1 >= len(path) {
+			continue
+		}
+
+		call, ok := path[i+1].(*ast.CallExpr)
+		if !ok {
+			continue
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || sel.Sel.Name != "Go" || !isErrgroupGroup(info.TypeOf(sel.X)) {
+			continue
+		}
+		recv, ok := sel.X.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		if name := app.errgroupContextOf(info, info.Uses[recv]); name != "" {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// errgroupContextOf finds the definition of group, "group, ctx := errgroup.WithContext(...)",
+// and returns the name of the context defined along with it.
+func (app *App) errgroupContextOf(info *types.Info, group types.Object) string {
+	if group == nil {
+		return ""
+	}
+
+	assign, ok := app.findNodeEnclosing(group.Pos(), func(n ast.Node) (ok bool) { _, ok = n.(*ast.AssignStmt); return }).(*ast.AssignStmt)
+	if !ok || len(assign.Lhs) != 2 || len(assign.Rhs) != 1 {
+		return ""
+	}
+
+	call, ok := assign.Rhs[0].(*ast.CallExpr)
+	if !ok {
+		return ""
+	}
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return ""
+	}
+	f, ok := info.Uses[sel.Sel].(*types.Func)
+	if !ok || f.Pkg() == nil || f.Pkg().Path() != errgroupPkgPath || f.Name() != "WithContext" {
+		return ""
+	}
+
+	groupIdent, ok := assign.Lhs[0].(*ast.Ident)
+	if !ok || info.ObjectOf(groupIdent) != group {
+		return ""
+	}
+	ctxIdent, ok := assign.Lhs[1].(*ast.Ident)
+	if !ok || ctxIdent.Name == "_" {
+		return ""
+	}
+	if obj := info.ObjectOf(ctxIdent); obj == nil || !app.VarSpec.validate(obj) {
+		return ""
+	}
+
+	return ctxIdent.Name
+}
+
+func isErrgroupGroup(t types.Type) bool {
+	named, ok := derefType(t).(*types.Named)
+	if !ok {
+		return false
+	}
+
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == errgroupPkgPath && obj.Name() == "Group"
+}
@@ -0,0 +1,114 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// FunctionExists reports whether the function or method specified by spec is declared,
+// without type-checking packages, which is much quicker than Load. Only the files of the package
+// are listed and their declarations are looked up by names; the signature is not checked.
+// It may be called before Load; if Config is not set, the package is looked up in the current directory.
+func (app *App) FunctionExists(spec FuncSpec) (bool, error) {
+	var conf packages.Config
+	if app.Config != nil {
+		conf = *app.Config // copy
+	}
+	conf.Mode = packages.LoadFiles
+	conf.Tests = false
+
+	pp, err := packages.Load(&conf, spec.PkgPath)
+	if err != nil {
+		return false, err
+	}
+	if len(pp) != 1 {
+		return false, xerrors.Errorf("BUG: package %q resolved to multiple packages", spec.PkgPath)
+	}
+	if len(pp[0].Errors) > 0 {
+		return false, pp[0].Errors[0]
+	}
+
+	fset := token.NewFileSet()
+	for _, filename := range pp[0].GoFiles {
+		file, err := parser.ParseFile(fset, filename, nil, parser.SkipObjectResolution)
+		if err != nil {
+			return false, err
+		}
+
+		for _, decl := range file.Decls {
+			if declares(decl, spec) {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// declares reports whether decl declares the function specified by spec,
+// as a function, a method or a method of an interface type.
+func declares(decl ast.Decl, spec FuncSpec) bool {
+	matchName := func(name string) bool {
+		if spec.FuncNameRE != nil {
+			return spec.FuncNameRE.MatchString(name)
+		}
+		return name == spec.FuncName
+	}
+
+	switch decl := decl.(type) {
+	case *ast.FuncDecl:
+		typeName := ""
+		if decl.Recv != nil && len(decl.Recv.List) == 1 {
+			typeName = recvTypeName(decl.Recv.List[0].Type)
+		}
+		return typeName == spec.TypeName && matchName(decl.Name.Name)
+
+	case *ast.GenDecl:
+		if spec.TypeName == "" {
+			return false
+		}
+		for _, s := range decl.Specs {
+			typeSpec, ok := s.(*ast.TypeSpec)
+			if !ok || typeSpec.Name.Name != spec.TypeName {
+				continue
+			}
+			iface, ok := typeSpec.Type.(*ast.InterfaceType)
+			if !ok {
+				continue
+			}
+			for _, field := range iface.Methods.List {
+				for _, name := range field.Names {
+					if matchName(name.Name) {
+						return true
+					}
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// recvTypeName returns the name of the receiver type expr, eg. "T" for "*T[K]".
+func recvTypeName(expr ast.Expr) string {
+	for {
+		switch e := expr.(type) {
+		case *ast.StarExpr:
+			expr = e.X
+		case *ast.ParenExpr:
+			expr = e.X
+		case *ast.IndexExpr:
+			expr = e.X
+		case *ast.IndexListExpr:
+			expr = e.X
+		case *ast.Ident:
+			return e.Name
+		default:
+			return ""
+		}
+	}
+}
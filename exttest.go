@@ -0,0 +1,36 @@
+package ctxize
+
+import (
+	"strings"
+
+	"go/ast"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// isExternalTestPackage reports whether pkg is an external test package, eg. "foo_test".
+func isExternalTestPackage(pkg *packages.Package) bool {
+	return strings.HasSuffix(pkg.Name, "_test")
+}
+
+// withoutExternalTests returns pkgs except external test packages.
+func withoutExternalTests(pkgs []*packages.Package) []*packages.Package {
+	result := make([]*packages.Package, 0, len(pkgs))
+	for _, pkg := range pkgs {
+		if !isExternalTestPackage(pkg) {
+			result = append(result, pkg)
+		}
+	}
+	return result
+}
+
+// inExternalTest reports whether funcDecl is declared in an external test package,
+// whose callers get "context.Background()" instead of InitExpr, as tests are the roots of calls.
+func (app *App) inExternalTest(funcDecl *ast.FuncDecl) bool {
+	path := app.pathEnclosing(funcDecl.Pos())
+	if len(path) == 0 {
+		return false
+	}
+	file, ok := path[len(path)-1].(*ast.File)
+	return ok && strings.HasSuffix(file.Name.Name, "_test")
+}
@@ -0,0 +1,46 @@
+package ctxize
+
+import (
+	"strings"
+
+	"go/ast"
+	"go/types"
+)
+
+// factoryInitExpr returns "<param>.<FactoryMethod>()" if funcDecl has a parameter
+// of VarSpec.FactoryReceiver type or a pointer to it, or "" otherwise.
+func (app *App) factoryInitExpr(funcDecl *ast.FuncDecl) string {
+	if app.VarSpec.FactoryReceiver == "" {
+		return ""
+	}
+
+	i := strings.LastIndex(app.VarSpec.FactoryReceiver, ".")
+	if i == -1 {
+		return ""
+	}
+	pkgPath, typeName := app.VarSpec.FactoryReceiver[:i], app.VarSpec.FactoryReceiver[i+1:]
+
+	for _, field := range funcDecl.Type.Params.List {
+		for _, name := range field.Names {
+			if name.Name == "_" {
+				continue
+			}
+
+			for _, pkg := range app.pkgs {
+				obj := pkg.TypesInfo.Defs[name]
+				if obj == nil {
+					continue
+				}
+
+				named, ok := derefType(obj.Type()).(*types.Named)
+				if ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == pkgPath && named.Obj().Name() == typeName {
+					debugf("%s: found factory %s", app.position(name.Pos()), name.Name)
+					return name.Name + "." + app.VarSpec.FactoryMethod + "()"
+				}
+				break
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,48 @@
+package ctxize
+
+import (
+	"fmt"
+
+	"go/token"
+)
+
+// RewriteFailure is a call site which could not be rewritten by RewriteWithFallback.
+type RewriteFailure struct {
+	Pos token.Position
+	Err error
+}
+
+func (f RewriteFailure) Error() string {
+	return fmt.Sprintf("%s: %s", f.Pos, f.Err)
+}
+
+// RewriteWithFallback rewrites the function specified by spec as Rewrite does,
+// but failures at call sites do not stop the rewrite; they are collected and
+// returned instead, leaving the call sites untouched.
+// The error returned is for the failures other than those at call sites,
+// eg. the function is not found.
+func (app *App) RewriteWithFallback(spec FuncSpec) ([]RewriteFailure, error) {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	app.failures = []RewriteFailure{}
+	defer func() { app.failures = nil }()
+
+	err := app.rewrite(spec)
+	return app.failures, err
+}
+
+// addFailure records err at the call site at pos, unless already recorded
+// for another variant of the package.
+func (app *App) addFailure(pos token.Pos, err error) {
+	p := app.position(pos)
+	for _, f := range app.failures {
+		if f.Pos == p {
+			return
+		}
+	}
+
+	app.failures = append(app.failures, RewriteFailure{Pos: p, Err: err})
+}
@@ -0,0 +1,97 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+const fiberPkgPath = "github.com/gofiber/fiber/v2"
+
+// fiberContextMethods are the methods of *fiber.Ctx which may return the variable, in order of preference.
+// UserContext returns the context.Context set by the application, which Context does not carry.
+var fiberContextMethods = []string{"UserContext", "Context"}
+
+// RewriteForFiber is like Rewrite, for code using Fiber, whose handlers are func(c *fiber.Ctx) error.
+// If VarSpec is fiber.Ctx, the variable is a pointer to it, eg. "c *fiber.Ctx", and c is passed in handlers.
+// Otherwise the result of the method of c returning the variable type is passed in handlers,
+// eg. "c.UserContext()" for context.Context.
+func (app *App) RewriteForFiber(spec FuncSpec) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	varSpec := *app.VarSpec
+	if obj := varSpec.varTypeObj; obj != nil && obj.Pkg().Path() == fiberPkgPath && obj.Name() == "Ctx" {
+		varSpec.Pointer = true
+	}
+
+	orig := app.VarSpec
+	app.VarSpec, app.fiber = &varSpec, true
+	defer func() { app.VarSpec, app.fiber = orig, false }()
+
+	return app.rewrite(spec)
+}
+
+// fiberContext returns "c" or "c.<method>()" which can be passed as the variable if pos is inside
+// a Fiber handler, which is func(c *fiber.Ctx) error, and RewriteForFiber is running.
+// Only the innermost function enclosing pos is looked at, as *fiber.Ctx must not be used
+// after the handler returns.
+func (app *App) fiberContext(pkg *packages.Package, pos token.Pos) ast.Expr {
+	if !app.fiber {
+		return nil
+	}
+
+	sig := app.innermostSignature(pkg, pos)
+	if sig == nil || sig.Params().Len() != 1 || sig.Results().Len() != 1 {
+		return nil
+	}
+
+	c := sig.Params().At(0)
+	ptr, ok := c.Type().(*types.Pointer)
+	if !ok || !isFiberType(ptr.Elem(), "Ctx") || !types.Identical(sig.Results().At(0).Type(), types.Universe.Lookup("error").Type()) {
+		return nil
+	}
+	if c.Name() == "" || c.Name() == "_" {
+		return nil
+	}
+	// the parameter may be shadowed at pos
+	if _, obj := pkg.Types.Scope().Innermost(pos).LookupParent(c.Name(), pos); obj != c {
+		return nil
+	}
+
+	if app.VarSpec.passable(c.Type()) {
+		debugf("%s: found fiber context %s", app.position(pos), c.Name())
+		return ast.NewIdent(c.Name())
+	}
+
+	for _, name := range fiberContextMethods {
+		obj, _, _ := types.LookupFieldOrMethod(c.Type(), true, c.Pkg(), name)
+		m, ok := obj.(*types.Func)
+		if !ok {
+			continue
+		}
+
+		msig := m.Type().(*types.Signature)
+		if msig.Params().Len() == 0 && msig.Results().Len() == 1 && app.VarSpec.passable(msig.Results().At(0).Type()) {
+			debugf("%s: found fiber context %s.%s()", app.position(pos), c.Name(), name)
+			return &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   ast.NewIdent(c.Name()),
+					Sel: ast.NewIdent(name),
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// isFiberType reports whether t is the type named name in package github.com/gofiber/fiber/v2.
+func isFiberType(t types.Type, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == fiberPkgPath && named.Obj().Name() == name
+}
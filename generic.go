@@ -0,0 +1,74 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// varType returns the type of the variable, instantiated with TypeArgs if any,
+// or a pointer to it if Pointer is set.
+func (s *VarSpec) varType() types.Type {
+	typ := s.varTypeObj.Type()
+	if s.instType != nil {
+		typ = s.instType
+	}
+	if s.Pointer {
+		return types.NewPointer(typ)
+	}
+	return typ
+}
+
+// instantiateVarType instantiates the generic type of varSpec with its TypeArgs.
+// It returns nil for non-generic types, for which TypeArgs must be empty.
+func instantiateVarType(varSpec *VarSpec) (types.Type, error) {
+	typ := varSpec.varTypeObj.Type()
+
+	named, ok := typ.(*types.Named)
+	if !ok || named.TypeParams().Len() == 0 {
+		if len(varSpec.TypeArgs) > 0 {
+			return nil, xerrors.Errorf("type %s is not generic but type arguments are given", varSpec.varTypeObj.Name())
+		}
+		return nil, nil
+	}
+
+	if len(varSpec.TypeArgs) == 0 {
+		return nil, xerrors.Errorf("type %s is generic but no type arguments are given", varSpec.varTypeObj.Name())
+	}
+
+	inst, err := types.Instantiate(nil, typ, varSpec.TypeArgs, true)
+	if err != nil {
+		return nil, xerrors.Errorf("instantiating %s: %w", varSpec.varTypeObj.Name(), err)
+	}
+
+	return inst, nil
+}
+
+// instantiateTypeExpr returns typ, the expression of the variable type, with TypeArgs
+// appended as type arguments, eg. "trace.Tracer[int]".
+func (s *VarSpec) instantiateTypeExpr(typ ast.Expr) ast.Expr {
+	if len(s.TypeArgs) == 0 {
+		return typ
+	}
+
+	qualifier := func(pkg *types.Package) string { return pkg.Name() }
+
+	indices := make([]ast.Expr, len(s.TypeArgs))
+	for i, arg := range s.TypeArgs {
+		name := types.TypeString(arg, qualifier)
+		expr, err := parser.ParseExpr(name)
+		if err != nil {
+			debugf("BUG: parsing type %s: %s", name, err)
+			expr = &ast.Ident{Name: name}
+		}
+		clearPos(expr)
+		indices[i] = expr
+	}
+
+	if len(indices) == 1 {
+		return &ast.IndexExpr{X: typ, Index: indices[0]}
+	}
+	return &ast.IndexListExpr{X: typ, Indices: indices}
+}
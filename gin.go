@@ -0,0 +1,40 @@
+package ctxize
+
+import (
+	"golang.org/x/xerrors"
+)
+
+const ginPkgPath = "github.com/gin-gonic/gin"
+
+// RewriteForGin is like Rewrite, for code using Gin, whose handlers take "c *gin.Context".
+// If VarSpec is gin.Context, the variable is a pointer to it, eg. "c *gin.Context", and
+// *gin.Context in scope is passed. If VarSpec is context.Context, *gin.Context in scope is passed
+// as well, since it implements context.Context.
+func (app *App) RewriteForGin(spec FuncSpec) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	obj := app.VarSpec.varTypeObj
+	if obj == nil {
+		return xerrors.New("VarSpec is not resolved; Load must be called beforehand")
+	}
+
+	switch {
+	case obj.Pkg().Path() == "context" && obj.Name() == "Context":
+		return app.rewrite(spec)
+
+	case obj.Pkg().Path() == ginPkgPath && obj.Name() == "Context":
+		varSpec := *app.VarSpec
+		varSpec.Pointer = true
+
+		orig := app.VarSpec
+		app.VarSpec = &varSpec
+		defer func() { app.VarSpec = orig }()
+
+		return app.rewrite(spec)
+	}
+
+	return xerrors.Errorf("variable type must be context.Context or gin.Context for Gin, got %s.%s", app.VarSpec.PkgPath, app.VarSpec.TypeName)
+}
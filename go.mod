@@ -1,8 +1,13 @@
 module github.com/motemen/go-ctxize
 
-go 1.12
+go 1.22.0
 
 require (
-	golang.org/x/tools v0.0.0-20190314010720-1286b2016bb1
-	golang.org/x/xerrors v0.0.0-20190315151331-d61658bd2e18
+	golang.org/x/mod v0.21.0
+	golang.org/x/tools v0.26.0
+	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da
+)
+
+require (
+	golang.org/x/sync v0.8.0 // indirect
 )
@@ -0,0 +1,89 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// grpcInterceptorContext returns the first parameter of the gRPC interceptor enclosing pos,
+// which is the context of the call intercepted, eg. "ctx" for
+// "func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error".
+// If the parameter is named "_", it is renamed to the variable name.
+// Only the innermost function enclosing pos is looked at, as requestContext does.
+func (app *App) grpcInterceptorContext(pkg *packages.Package, pos token.Pos) ast.Expr {
+	var funcType *ast.FuncType
+	var sig *types.Signature
+	for _, node := range app.pathEnclosing(pos) {
+		if lit, ok := node.(*ast.FuncLit); ok {
+			funcType = lit.Type
+			sig, _ = pkg.TypesInfo.TypeOf(lit).(*types.Signature)
+			break
+		}
+		if decl, ok := node.(*ast.FuncDecl); ok {
+			funcType = decl.Type
+			if f, ok := pkg.TypesInfo.Defs[decl.Name].(*types.Func); ok {
+				sig = f.Type().(*types.Signature)
+			}
+			break
+		}
+	}
+	if sig == nil || sig.Params().Len() == 0 || !types.Identical(sig.Params().At(0).Type(), app.VarSpec.varType()) {
+		return nil
+	}
+	if !isGRPCInterceptor(sig) {
+		return nil
+	}
+
+	field := funcType.Params.List[0]
+	if len(field.Names) == 0 {
+		return nil
+	}
+	ident := field.Names[0]
+
+	param := sig.Params().At(0)
+	innermost := pkg.Types.Scope().Innermost(pos)
+	switch {
+	case ident.Name == "_":
+		if _, obj := innermost.LookupParent(app.VarSpec.Name, pos); obj != nil {
+			return nil
+		}
+
+		before := app.nodeString(field)
+		ident.Name = app.VarSpec.Name
+		app.markModified(pos)
+		app.recordChange(ChangeDecl, field.Pos(), before, app.nodeString(field))
+
+	case ident.Name != param.Name():
+		// renamed for a previous call
+
+	default:
+		// the parameter may be shadowed at pos
+		if _, obj := innermost.LookupParent(ident.Name, pos); obj != param {
+			return nil
+		}
+	}
+
+	debugf("%s: found gRPC interceptor context %s", app.position(pos), ident.Name)
+
+	return ast.NewIdent(ident.Name)
+}
+
+// isGRPCInterceptor reports whether sig is of a gRPC interceptor, which takes the next handler
+// of the call intercepted.
+func isGRPCInterceptor(sig *types.Signature) bool {
+	for i := 0; i < sig.Params().Len(); i++ {
+		named, ok := sig.Params().At(i).Type().(*types.Named)
+		if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != "google.golang.org/grpc" {
+			continue
+		}
+		switch named.Obj().Name() {
+		case "UnaryInvoker", "Streamer", "UnaryHandler":
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,211 @@
+package ctxize
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"go/token"
+
+	"golang.org/x/xerrors"
+)
+
+// RewriteHistory applies the rewrites made so far to every commit of the branch branchRef
+// which is not reachable from HEAD, eg. of a feature branch while its base branch is checked out,
+// and updates the branch to the rewritten commits, as "git filter-branch --tree-filter" would.
+// Each commit is checked out in a temporary worktree, where the packages given to Load are loaded
+// again with the options of app and the functions rewritten by app are rewritten in turn.
+// Functions which already take the variable, or are not declared, in a commit are skipped.
+// The rewritten commit keeps the author and message of the original one, and has the rewritten
+// parent as its parent. The working tree and the index of the repository are left untouched.
+// The branch must not be checked out, and must not have merge commits to rewrite.
+func (app *App) RewriteHistory(branchRef string) error {
+	app.mu.RLock()
+	specs := append([]FuncSpec(nil), app.rewrittenSpecs...)
+	app.mu.RUnlock()
+
+	if len(specs) == 0 {
+		return xerrors.New("no functions rewritten yet")
+	}
+
+	dir, err := filepath.Abs(app.Config.Dir)
+	if err != nil {
+		return err
+	}
+	top, err := gitTopLevel(dir)
+	if err != nil {
+		return err
+	}
+	// the top level directory reported by git has symbolic links resolved
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(top, dir)
+	if err != nil {
+		return err
+	}
+
+	ref, err := gitOutput(top, "rev-parse", "--symbolic-full-name", branchRef)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(ref, "refs/heads/") {
+		return xerrors.Errorf("%s is not a branch", branchRef)
+	}
+	if head, err := gitOutput(top, "symbolic-ref", "-q", "HEAD"); err == nil && head == ref {
+		return xerrors.Errorf("branch %s is checked out", branchRef)
+	}
+
+	oldHead, err := gitOutput(top, "rev-parse", ref)
+	if err != nil {
+		return err
+	}
+	merges, err := gitOutput(top, "rev-list", "--min-parents=2", "HEAD.."+ref)
+	if err != nil {
+		return err
+	}
+	if merges != "" {
+		return xerrors.Errorf("branch %s has merge commits", branchRef)
+	}
+	out, err := gitOutput(top, "rev-list", "--reverse", "HEAD.."+ref)
+	if err != nil {
+		return err
+	}
+	commits := strings.Fields(out)
+	if len(commits) == 0 {
+		debugf("%s: no commits to rewrite", branchRef)
+		return nil
+	}
+
+	parent, err := gitOutput(top, "rev-parse", commits[0]+"^")
+	if err != nil {
+		return err
+	}
+
+	worktree, err := os.MkdirTemp("", "goctxize-history-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(worktree)
+
+	if _, err := gitOutput(top, "worktree", "add", "-q", "--detach", worktree, parent); err != nil {
+		return err
+	}
+	defer gitOutput(top, "worktree", "remove", "--force", worktree)
+
+	for _, commit := range commits {
+		debugf("%s: rewriting commit", commit)
+
+		if _, err := gitOutput(worktree, "checkout", "-q", "-f", "--detach", commit); err != nil {
+			return err
+		}
+
+		err := app.rewriteCommit(filepath.Join(worktree, rel), specs)
+		if err != nil {
+			return xerrors.Errorf("commit %s: %w", commit, err)
+		}
+
+		if _, err := gitOutput(worktree, "add", "-A"); err != nil {
+			return err
+		}
+		tree, err := gitOutput(worktree, "write-tree")
+		if err != nil {
+			return err
+		}
+
+		parent, err = gitCommitTree(worktree, commit, tree, parent)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = gitOutput(top, "update-ref", "-m", "goctxize: rewrite history", ref, parent, oldHead)
+	return err
+}
+
+// rewriteCommit rewrites the functions of specs in the packages given to Load, loaded at dir,
+// and writes the files modified or generated.
+func (app *App) rewriteCommit(dir string, specs []FuncSpec) error {
+	conf := *app.Config // copy
+	conf.Dir = dir
+	conf.Fset = token.NewFileSet()
+	conf.Overlay = nil
+
+	a := app.withOptions(&conf)
+	a.plugins = app.plugins
+
+	err := a.Load(app.pkgPaths...)
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		// resolved again in the packages newly loaded
+		spec.pkg = nil
+
+		ok, err := a.IsRewritten(spec)
+		if err != nil {
+			debugf("%s: skipped: %s", spec, err)
+			continue
+		}
+		if ok {
+			debugf("%s: already takes %s", spec, a.VarSpec.Name)
+			continue
+		}
+
+		err = a.Rewrite(spec)
+		if err != nil {
+			return err
+		}
+	}
+
+	return a.eachContent(func(filename string, content []byte) error {
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(dir, filename)
+		}
+		return os.WriteFile(filename, content, 0644)
+	})
+}
+
+// gitCommitTree creates a commit of tree with parent, with the author and message of commit,
+// and returns its hash.
+func gitCommitTree(dir, commit, tree, parent string) (string, error) {
+	out, err := gitOutput(dir, "log", "-1", "--format=%an%n%ae%n%aI", commit)
+	if err != nil {
+		return "", err
+	}
+	author := strings.SplitN(out, "\n", 3)
+	if len(author) != 3 {
+		return "", xerrors.Errorf("BUG: unexpected author of commit %s: %q", commit, out)
+	}
+
+	message, err := exec.Command("git", "-C", dir, "log", "-1", "--format=%B", commit).Output()
+	if err != nil {
+		return "", xerrors.Errorf("git log %s%s: %w", commit, exitErrorStderr(err), err)
+	}
+
+	cmd := exec.Command("git", "commit-tree", tree, "-p", parent)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME="+author[0], "GIT_AUTHOR_EMAIL="+author[1], "GIT_AUTHOR_DATE="+author[2])
+	cmd.Stdin = strings.NewReader(strings.TrimRight(string(message), "\n") + "\n")
+	hash, err := cmd.Output()
+	if err != nil {
+		return "", xerrors.Errorf("git commit-tree %s%s: %w", tree, exitErrorStderr(err), err)
+	}
+
+	return strings.TrimSpace(string(hash)), nil
+}
+
+// gitOutput runs git with args in dir and returns its output, trimmed.
+func gitOutput(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", xerrors.Errorf("git %s%s: %w", strings.Join(args, " "), exitErrorStderr(err), err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,122 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// lookupInterfaceMethod returns the method specified by spec if it is declared in an interface type.
+func (app *App) lookupInterfaceMethod(spec FuncSpec) *types.Func {
+	if spec.TypeName == "" {
+		return nil
+	}
+
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		f, ok := obj.(*types.Func)
+		if !ok || !spec.matches(f) {
+			continue
+		}
+		if recv := f.Type().(*types.Signature).Recv(); recv != nil && types.IsInterface(recv.Type()) {
+			return f
+		}
+	}
+
+	return nil
+}
+
+// rewriteInterfaceMethod rewrites the declaration of method in an interface type
+// to take the variable as its first parameter.
+// Calls of the method, either directly on the interface or through structs embedding it,
+// are rewritten by rewriteCallers; implementations of the interface are not rewritten.
+func (app *App) rewriteInterfaceMethod(method *types.Func) error {
+	field, ok := app.findNodeEnclosing(method.Pos(), func(n ast.Node) (ok bool) { _, ok = n.(*ast.Field); return }).(*ast.Field)
+	if !ok {
+		return xerrors.Errorf("BUG: %s: could not find method declaration", app.position(method.Pos()))
+	}
+	funcType, ok := field.Type.(*ast.FuncType)
+	if !ok {
+		return xerrors.Errorf("BUG: %s: method %s is not declared with func type", app.position(method.Pos()), method.Name())
+	}
+
+	debugf("%s: found interface method", app.position(field.Pos()))
+
+	before := app.nodeString(field)
+
+	file := app.markModified(field.Pos())
+	if file == nil {
+		return xerrors.Errorf("BUG: %s: could not find file", app.position(field.Pos()))
+	}
+
+	funcType.Params.List = app.insertVarField(file, funcType.Params)
+
+	app.recordChange(ChangeDecl, field.Pos(), before, app.nodeString(field))
+
+	app.addVarImport(file)
+
+	return nil
+}
+
+// propagateToInterfaces rewrites the methods of interface types in the loaded packages
+// which method, of a concrete type, implements, and their callers.
+func (app *App) propagateToInterfaces(method *types.Func) error {
+	recv := method.Type().(*types.Signature).Recv()
+	if recv == nil {
+		return nil
+	}
+	named, ok := derefType(recv.Type()).(*types.Named)
+	if !ok {
+		return nil
+	}
+
+	// interfaces may embed others declaring the method
+	seen := map[token.Pos]bool{}
+	for _, pkg := range app.pkgs {
+		scope := pkg.Types.Scope()
+		for _, name := range scope.Names() {
+			typeName, ok := scope.Lookup(name).(*types.TypeName)
+			if !ok {
+				continue
+			}
+			iface, ok := typeName.Type().Underlying().(*types.Interface)
+			if !ok || !types.Implements(named, iface) && !types.Implements(types.NewPointer(named), iface) {
+				continue
+			}
+
+			for i := 0; i < iface.NumMethods(); i++ {
+				m := iface.Method(i)
+				if m.Name() != method.Name() || seen[m.Pos()] {
+					continue
+				}
+				seen[m.Pos()] = true
+
+				declaring, ok := m.Type().(*types.Signature).Recv().Type().(*types.Named)
+				if !ok {
+					continue
+				}
+
+				spec := FuncSpec{
+					PkgPath:  m.Pkg().Path(),
+					TypeName: declaring.Obj().Name(),
+					FuncName: m.Name(),
+				}
+				var err error
+				spec.pkg, err = app.resolvePackage(spec.PkgPath)
+				if err != nil {
+					return err
+				}
+
+				debugf("%s: implements %s", method.FullName(), spec)
+
+				err = app.rewriteResolved(spec)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,214 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/cfg"
+	"golang.org/x/tools/go/packages"
+)
+
+// inferContext finds the variable most recently assigned a value satisfying the variable type
+// on all paths to pos in the innermost function enclosing pos, and returns its name.
+// It walks up the dominator tree of the control-flow graph of the function from the block
+// containing pos, so that assignments done only conditionally, eg.
+//
+//	if withDeadline {
+//		ctx, cancel = context.WithTimeout(ctx, d)
+//	}
+//
+// are not taken into account. Parameters of the function, and then variables
+// available where the function is declared if it is a function literal, are looked up next.
+func (app *App) inferContext(pkg *packages.Package, pos token.Pos) string {
+	iface, ok := app.VarSpec.varType().Underlying().(*types.Interface)
+	if !ok {
+		return ""
+	}
+
+	var (
+		funcNode ast.Node
+		funcType *ast.FuncType
+		body     *ast.BlockStmt
+	)
+	for _, node := range app.pathEnclosing(pos) {
+		// when looking up variables captured by a function literal,
+		// pos is at the literal itself, which should be skipped
+		if lit, ok := node.(*ast.FuncLit); ok && lit.Pos() != pos {
+			funcNode, funcType, body = lit, lit.Type, lit.Body
+			break
+		}
+		if decl, ok := node.(*ast.FuncDecl); ok {
+			funcNode, funcType, body = decl, decl.Type, decl.Body
+			break
+		}
+	}
+	if body == nil {
+		return ""
+	}
+
+	g := cfg.New(body, func(*ast.CallExpr) bool { return true })
+
+	scope := pkg.Types.Scope().Innermost(pos)
+	if scope == nil {
+		return ""
+	}
+
+	// usable reports whether id is of the variable type and visible at pos
+	usable := func(id *ast.Ident) bool {
+		obj := pkg.TypesInfo.ObjectOf(id)
+		if obj == nil || !types.Implements(obj.Type(), iface) || !app.VarSpec.validate(obj) {
+			return false
+		}
+		_, found := scope.LookupParent(id.Name, pos)
+		return found == obj
+	}
+
+	var block *cfg.Block
+	for _, b := range g.Blocks {
+		for _, n := range b.Nodes {
+			if n.Pos() <= pos && pos < n.End() {
+				block = b
+			}
+		}
+	}
+
+	idom := dominators(g)
+	for b := block; b != nil; b = idom[b] {
+		for i := len(b.Nodes) - 1; i >= 0; i-- {
+			n := b.Nodes[i]
+			if n.End() > pos {
+				continue
+			}
+
+			for _, id := range assignedIdents(n) {
+				if usable(id) {
+					debugf("%s: inferred %s", app.position(pos), id.Name)
+					return id.Name
+				}
+			}
+		}
+	}
+
+	// parameters are assigned at the entry
+	for _, field := range funcType.Params.List {
+		for _, id := range field.Names {
+			if usable(id) {
+				return id.Name
+			}
+		}
+	}
+
+	// variables captured by function literals are assigned before the literal
+	if _, ok := funcNode.(*ast.FuncLit); ok {
+		return app.inferContext(pkg, funcNode.Pos())
+	}
+
+	return ""
+}
+
+// assignedIdents returns identifiers assigned a value at node n, which is
+// an assignment statement or a value spec with values.
+func assignedIdents(n ast.Node) []*ast.Ident {
+	var lhs []ast.Expr
+	switch n := n.(type) {
+	case *ast.AssignStmt:
+		if n.Tok != token.ASSIGN && n.Tok != token.DEFINE {
+			return nil
+		}
+		lhs = n.Lhs
+
+	case *ast.ValueSpec:
+		if len(n.Values) == 0 {
+			return nil
+		}
+		return n.Names
+
+	case *ast.DeclStmt:
+		var idents []*ast.Ident
+		if genDecl, ok := n.Decl.(*ast.GenDecl); ok {
+			for _, spec := range genDecl.Specs {
+				idents = append(idents, assignedIdents(spec)...)
+			}
+		}
+		return idents
+	}
+
+	var idents []*ast.Ident
+	for _, expr := range lhs {
+		if id, ok := expr.(*ast.Ident); ok && id.Name != "_" {
+			idents = append(idents, id)
+		}
+	}
+	return idents
+}
+
+// dominators computes the immediate dominator of each live block of g,
+// by the algorithm of Cooper, Harvey and Kennedy.
+// The entry block maps to nil.
+func dominators(g *cfg.CFG) map[*cfg.Block]*cfg.Block {
+	var order []*cfg.Block // postorder
+	visited := map[*cfg.Block]bool{}
+	var visit func(b *cfg.Block)
+	visit = func(b *cfg.Block) {
+		visited[b] = true
+		for _, s := range b.Succs {
+			if !visited[s] {
+				visit(s)
+			}
+		}
+		order = append(order, b)
+	}
+	entry := g.Blocks[0]
+	visit(entry)
+
+	index := map[*cfg.Block]int{}
+	for i, b := range order {
+		index[b] = i
+	}
+
+	preds := map[*cfg.Block][]*cfg.Block{}
+	for _, b := range order {
+		for _, s := range b.Succs {
+			preds[s] = append(preds[s], b)
+		}
+	}
+
+	idom := map[*cfg.Block]*cfg.Block{entry: entry}
+	intersect := func(a, b *cfg.Block) *cfg.Block {
+		for a != b {
+			for index[a] < index[b] {
+				a = idom[a]
+			}
+			for index[b] < index[a] {
+				b = idom[b]
+			}
+		}
+		return a
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for i := len(order) - 2; i >= 0; i-- {
+			b := order[i]
+			var newIdom *cfg.Block
+			for _, p := range preds[b] {
+				if idom[p] == nil {
+					continue
+				}
+				if newIdom == nil {
+					newIdom = p
+				} else {
+					newIdom = intersect(p, newIdom)
+				}
+			}
+			if idom[b] != newIdom {
+				idom[b] = newIdom
+				changed = true
+			}
+		}
+	}
+
+	idom[entry] = nil
+	return idom
+}
@@ -0,0 +1,96 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// ContextLeak is a variable captured by a goroutine from the scope outside of it.
+// The variable may be cancelled, eg. at the end of a request, before the goroutine finishes.
+type ContextLeak struct {
+	Name    string         // name of the variable captured
+	Go      token.Position // position of the go statement launching the goroutine
+	Capture token.Position // position where the goroutine refers to the variable
+}
+
+// DetectContextLeaks reports variables of the type specified by VarSpec which are
+// captured by function literals run as goroutines, in packages pkgPaths.
+// Variables passed to goroutines as arguments, and package-level ones, are not reported.
+// If no pkgPaths given, the packages given to Load are scanned.
+func (app *App) DetectContextLeaks(pkgPaths ...string) []ContextLeak {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var leaks []ContextLeak
+	seen := map[string]bool{}
+
+	for _, pkg := range app.pkgs {
+		if !app.isScanned(pkg, pkgPaths) {
+			continue
+		}
+
+		for _, file := range pkg.Syntax {
+			ast.Inspect(file, func(n ast.Node) bool {
+				goStmt, ok := n.(*ast.GoStmt)
+				if !ok {
+					return true
+				}
+
+				lit, ok := goStmt.Call.Fun.(*ast.FuncLit)
+				if !ok {
+					return true
+				}
+
+				for _, leak := range app.capturedVars(pkg.Types, pkg.TypesInfo, lit) {
+					leak.Go = app.position(goStmt.Pos())
+
+					// test variants of packages share the same files
+					if key := leak.Capture.String(); !seen[key] {
+						seen[key] = true
+						leaks = append(leaks, leak)
+					}
+				}
+
+				return true
+			})
+		}
+	}
+
+	return leaks
+}
+
+// capturedVars returns the first references in lit to each variable of the type
+// specified by VarSpec declared outside of lit, except package-level ones.
+func (app *App) capturedVars(pkg *types.Package, info *types.Info, lit *ast.FuncLit) []ContextLeak {
+	var leaks []ContextLeak
+	captured := map[types.Object]bool{}
+
+	ast.Inspect(lit.Body, func(n ast.Node) bool {
+		id, ok := n.(*ast.Ident)
+		if !ok {
+			return true
+		}
+
+		v, ok := info.Uses[id].(*types.Var)
+		if !ok || v.IsField() || v.Parent() == pkg.Scope() || captured[v] {
+			return true
+		}
+		if lit.Pos() <= v.Pos() && v.Pos() < lit.End() {
+			return true
+		}
+		if !types.Identical(v.Type(), app.VarSpec.varType()) {
+			return true
+		}
+
+		captured[v] = true
+		leaks = append(leaks, ContextLeak{
+			Name:    id.Name,
+			Capture: app.position(id.Pos()),
+		})
+
+		return true
+	})
+
+	return leaks
+}
@@ -0,0 +1,163 @@
+package ctxize
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go/ast"
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// GenerateMermaid returns a Mermaid sequence diagram of how the variable flows into the function
+// specified by spec, which is usually rewritten beforehand: calls to the function are drawn as arrows
+// labeled with the variable name, and so are calls to the callers taking the variable, recursively.
+// Callers declaring the variable, instead of taking it, are the origins and are noted with
+// the declarations.
+func (app *App) GenerateMermaid(spec FuncSpec) (string, error) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var err error
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return "", err
+	}
+
+	var target *types.Func
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			target = f
+			break
+		}
+	}
+	if target == nil {
+		return "", xerrors.Errorf("could not find declaration of func %s in package %s", spec.FuncName, spec.PkgPath)
+	}
+
+	callers := map[*types.Func][]*types.Func{}
+	decls := map[*types.Func]*ast.FuncDecl{}
+	for _, pkg := range app.pkgs {
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				funcDecl, ok := decl.(*ast.FuncDecl)
+				if !ok || funcDecl.Body == nil {
+					continue
+				}
+
+				f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+				if !ok || decls[f] != nil {
+					continue
+				}
+				decls[f] = funcDecl
+
+				seen := map[*types.Func]bool{}
+				ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
+					if id, ok := node.(*ast.Ident); ok {
+						if callee, ok := pkg.TypesInfo.Uses[id].(*types.Func); ok && !seen[callee.Origin()] {
+							seen[callee.Origin()] = true
+							callers[callee.Origin()] = append(callers[callee.Origin()], f)
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+
+	type edge struct {
+		caller, callee *types.Func
+		depth          int
+	}
+	var edges []edge
+	visited := map[*types.Func]bool{target: true}
+	queue := []edge{{callee: target}}
+	for len(queue) > 0 {
+		e := queue[0]
+		queue = queue[1:]
+
+		for _, caller := range callers[e.callee] {
+			edges = append(edges, edge{caller: caller, callee: e.callee, depth: e.depth + 1})
+			if !visited[caller] && app.takesVar(caller) {
+				visited[caller] = true
+				queue = append(queue, edge{callee: caller, depth: e.depth + 1})
+			}
+		}
+	}
+
+	// outermost callers first
+	sort.SliceStable(edges, func(i, j int) bool {
+		if edges[i].depth != edges[j].depth {
+			return edges[i].depth > edges[j].depth
+		}
+		if a, b := mermaidName(edges[i].callee), mermaidName(edges[j].callee); a != b {
+			return a < b
+		}
+		return mermaidName(edges[i].caller) < mermaidName(edges[j].caller)
+	})
+
+	var participants []*types.Func
+	ids := map[*types.Func]string{}
+	participant := func(f *types.Func) string {
+		if id, ok := ids[f]; ok {
+			return id
+		}
+		ids[f] = fmt.Sprintf("p%d", len(participants))
+		participants = append(participants, f)
+		return ids[f]
+	}
+
+	var lines []string
+	noted := map[*types.Func]bool{}
+	for _, e := range edges {
+		from, to := participant(e.caller), participant(e.callee)
+		if stub := app.stubs[decls[e.caller]]; stub != nil && !noted[e.caller] {
+			noted[e.caller] = true
+			lines = append(lines, fmt.Sprintf("    Note over %s: %s", from, app.nodeString(stub)))
+		}
+		lines = append(lines, fmt.Sprintf("    %s->>%s: %s", from, to, app.VarSpec.Name))
+	}
+	participant(target)
+
+	var b strings.Builder
+	b.WriteString("sequenceDiagram\n")
+	for _, f := range participants {
+		fmt.Fprintf(&b, "    participant %s as %s\n", ids[f], mermaidName(f))
+	}
+	for _, line := range lines {
+		b.WriteString(line + "\n")
+	}
+
+	return b.String(), nil
+}
+
+// takesVar reports whether f takes the variable, either originally or by a rewrite.
+func (app *App) takesVar(f *types.Func) bool {
+	if app.accepts(f.Type().(*types.Signature)) {
+		return true
+	}
+
+	for _, spec := range app.rewrittenSpecs {
+		if spec.matches(f) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mermaidName returns the name of f qualified by its package name and receiver type, eg. "foo.T.F".
+func mermaidName(f *types.Func) string {
+	name := f.Name()
+	if recv := f.Type().(*types.Signature).Recv(); recv != nil {
+		if named, ok := derefType(recv.Type()).(*types.Named); ok {
+			name = named.Obj().Name() + "." + name
+		}
+	}
+	if f.Pkg() != nil {
+		name = f.Pkg().Name() + "." + name
+	}
+	return name
+}
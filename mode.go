@@ -0,0 +1,53 @@
+package ctxize
+
+import (
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// Modes of FuncSpec.
+const (
+	// the function takes the variable; it is an error if it returns one, eg. "func GetContext(x) (context.Context, error)",
+	// which may be either of the other modes
+	ModeAuto = ""
+	// the function takes the variable even if it returns one, eg. to derive a new one from it
+	ModeInject = "inject"
+	// the function is a source of the variable, and neither it nor its call sites,
+	// eg. "ctx, err := GetContext(x)", are rewritten
+	ModeExtract = "extract"
+)
+
+// extractsVar reports whether the function specified by spec is a source of the variable,
+// and is to be left untouched by Rewrite. Under ModeAuto, it is an error if the function
+// returns the variable, as it is ambiguous.
+func (app *App) extractsVar(spec FuncSpec) (bool, error) {
+	switch spec.Mode {
+	case ModeExtract:
+		return true, nil
+	case ModeInject:
+		return false, nil
+	}
+
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			if app.returnsVar(f.Type().(*types.Signature)) {
+				return false, xerrors.Errorf("func %s returns %s; set Mode to ModeExtract to leave it untouched, or ModeInject to rewrite it", spec, app.VarSpec.Name)
+			}
+			return false, nil
+		}
+	}
+
+	return false, nil
+}
+
+// returnsVar reports whether any of the results of sig is of the type specified by VarSpec.
+func (app *App) returnsVar(sig *types.Signature) bool {
+	results := sig.Results()
+	for i := 0; i < results.Len(); i++ {
+		if types.Identical(results.At(i).Type(), app.VarSpec.varType()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package ctxize
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// RewriteForModule loads all packages in the module of modulePath, by pattern "./..."
+// at the root directory of the module, and rewrites the function specified by spec
+// and its callers in them.
+// The packages are loaded by Load(), so it must not be called beforehand.
+func (app *App) RewriteForModule(modulePath string, spec FuncSpec) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	dir, err := app.moduleDir(modulePath)
+	if err != nil {
+		return err
+	}
+
+	if err := app.loadModule(modulePath, dir); err != nil {
+		return err
+	}
+
+	return app.rewrite(spec)
+}
+
+// loadModule loads all packages in the module of modulePath by Load, by pattern "./..." at dir,
+// the root directory of the module. Config.Dir is kept as it is, so that the filenames
+// visited by Each and reported by Changes stay relative to it.
+func (app *App) loadModule(modulePath, dir string) error {
+	config := packages.Config{Tests: true}
+	if app.Config != nil {
+		config = *app.Config
+	}
+	if config.Dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		config.Dir = wd
+	}
+
+	origDir := config.Dir
+	config.Dir = dir
+	app.Config = &config
+	defer func() { config.Dir = origDir }()
+
+	if err := app.load("./..."); err != nil {
+		return err
+	}
+
+	// loaded the same from Config.Dir, eg. by Clone
+	app.pkgPaths = []string{modulePath + "/..."}
+	return nil
+}
+
+// moduleDir returns the root directory of the module of modulePath.
+func (app *App) moduleDir(modulePath string) (string, error) {
+	config := &packages.Config{}
+	if app.Config != nil {
+		config.Dir, config.Env, config.BuildFlags = app.Config.Dir, app.Config.Env, app.Config.BuildFlags
+	}
+	config.Mode = packages.NeedName | packages.NeedModule
+
+	pkgs, err := packages.Load(config, modulePath+"/...")
+	if err != nil {
+		return "", err
+	}
+
+	for _, pkg := range pkgs {
+		if pkg.Module != nil && pkg.Module.Path == modulePath && pkg.Module.Dir != "" {
+			return pkg.Module.Dir, nil
+		}
+	}
+
+	return "", xerrors.Errorf("could not find module %s", modulePath)
+}
+
+// RewriteModule redoes the rewrites made so far in all the packages of the module of modPath,
+// loaded by pattern "./..." at the root directory of the module, which is found by walking up
+// from Config.Dir to go.mod declaring modPath. Thus call sites in the packages of the module
+// not given to Load are rewritten as well.
+// Modifications not written yet are discarded, as the packages are loaded again from the files.
+// If they are written, functions already taking the variable only have their call sites rewritten.
+func (app *App) RewriteModule(modPath string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if len(app.rewrittenSpecs) == 0 {
+		return xerrors.New("no functions rewritten yet")
+	}
+
+	dir, err := findModuleRoot(app.Config.Dir, modPath)
+	if err != nil {
+		return err
+	}
+
+	specs := app.rewrittenSpecs
+
+	if err := app.loadModule(modPath, dir); err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		// resolved again by Rewrite in the packages newly loaded
+		spec.pkg = nil
+		spec.Version = ""
+		spec.FuncNameRE = nil
+
+		debugf("%s: rewriting in module %s", spec, modPath)
+		if err := app.rewrite(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// findModuleRoot returns the nearest directory of dir or its ancestors
+// which has go.mod declaring the module modPath.
+func findModuleRoot(dir, modPath string) (string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		data, err := os.ReadFile(filepath.Join(d, "go.mod"))
+		if err == nil && modfile.ModulePath(data) == modPath {
+			return d, nil
+		}
+
+		if filepath.Dir(d) == d {
+			return "", xerrors.Errorf("could not find go.mod of module %s from %s", modPath, dir)
+		}
+	}
+}
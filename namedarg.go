@@ -0,0 +1,251 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// namedArgsParam is the name of the parameter of functions rewritten by NamedArgMode.
+const namedArgsParam = "args"
+
+// rewriteNamedArgs rewrites the function specified by spec, whose declaration and callers are
+// already rewritten to take the variable, to take a struct of its parameters instead
+// when app.NamedArgMode is set, eg.
+//
+//	type FArgs struct {
+//		Ctx context.Context
+//		X   int
+//	}
+//
+//	func F(args FArgs) { ... args.X ... }
+//
+// and its calls to pass the parameters by their names, as "pkg.F(pkg.FArgs{Ctx: ctx, X: x})".
+func (app *App) rewriteNamedArgs(spec FuncSpec) error {
+	if !app.NamedArgMode {
+		return nil
+	}
+
+	funcDecl, err := app.findFuncDecl(spec)
+	if err != nil {
+		return err
+	}
+	if funcDecl.Type.TypeParams != nil {
+		return xerrors.Errorf("%s: generic func %s cannot take named arguments", app.position(funcDecl.Pos()), funcDecl.Name.Name)
+	}
+
+	structName := spec.TypeName + spec.FuncName + "Args"
+	if spec.pkg.Types.Scope().Lookup(structName) != nil {
+		return xerrors.Errorf("%s: %s is already declared in package %s", app.position(funcDecl.Pos()), structName, spec.pkg.Name)
+	}
+
+	file := app.markModified(funcDecl.Pos())
+	if file == nil {
+		return xerrors.Errorf("BUG: %s: could not find file", app.position(funcDecl.Pos()))
+	}
+
+	// field names by parameter names
+	fieldNames := map[string]string{}
+	fieldList := &ast.FieldList{}
+	for _, field := range funcDecl.Type.Params.List {
+		if _, ok := field.Type.(*ast.Ellipsis); ok {
+			return xerrors.Errorf("%s: variadic func %s cannot take named arguments", app.position(funcDecl.Pos()), funcDecl.Name.Name)
+		}
+
+		var names []*ast.Ident
+		for _, name := range field.Names {
+			if name.Name == "_" || name.Name == namedArgsParam {
+				return xerrors.Errorf("%s: parameter %s of func %s cannot be a named argument", app.position(name.Pos()), name.Name, funcDecl.Name.Name)
+			}
+			fieldName := exportedName(name.Name)
+			for _, n := range fieldNames {
+				if n == fieldName {
+					return xerrors.Errorf("%s: parameters of func %s have the same field name %s", app.position(name.Pos()), funcDecl.Name.Name, fieldName)
+				}
+			}
+			fieldNames[name.Name] = fieldName
+			names = append(names, ast.NewIdent(fieldName))
+		}
+		if len(names) == 0 {
+			return xerrors.Errorf("%s: parameters of func %s must be named to be named arguments", app.position(field.Pos()), funcDecl.Name.Name)
+		}
+
+		clearPos(field.Type)
+		fieldList.List = append(fieldList.List, &ast.Field{Names: names, Type: field.Type})
+	}
+
+	err = app.rewriteNamedArgCalls(spec, structName, fieldNames, funcDecl.Type.Params.List)
+	if err != nil {
+		return err
+	}
+
+	before := app.nodeString(funcSignature(funcDecl))
+
+	app.replaceParamsWithNamedArgs(spec.pkg, funcDecl, fieldNames)
+	funcDecl.Type.Params.List = []*ast.Field{
+		{
+			Names: []*ast.Ident{{Name: namedArgsParam, NamePos: funcDecl.Type.Params.Opening}},
+			Type:  &ast.Ident{Name: structName, NamePos: funcDecl.Type.Params.Opening},
+		},
+	}
+
+	app.recordChange(ChangeDecl, funcDecl.Pos(), before, app.nodeString(funcSignature(funcDecl)))
+
+	structDecl := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent(structName),
+				Type: &ast.StructType{Fields: fieldList},
+			},
+		},
+	}
+	for i, decl := range file.Decls {
+		if decl == funcDecl {
+			file.Decls = append(file.Decls[:i], append([]ast.Decl{structDecl}, file.Decls[i:]...)...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// rewriteNamedArgCalls rewrites the calls of the function specified by spec to pass a composite literal
+// of the struct of structName, whose fields correspond to params.
+func (app *App) rewriteNamedArgCalls(spec FuncSpec, structName string, fieldNames map[string]string, params []*ast.Field) error {
+	// test variants of packages share the syntax of the files with the packages
+	seen := map[token.Pos]bool{}
+
+	for _, pkg := range app.pkgs {
+		for id, obj := range pkg.TypesInfo.Uses {
+			f, ok := obj.(*types.Func)
+			if !ok || !spec.matches(f) || seen[id.Pos()] {
+				continue
+			}
+			seen[id.Pos()] = true
+
+			callExpr, ok := app.findNodeEnclosing(id.Pos(), func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				return ok && call.Fun.Pos() <= id.Pos() && id.Pos() < call.Fun.End()
+			}).(*ast.CallExpr)
+			if !ok {
+				// eg. passed as a function value, which must be updated manually
+				debugf("%s: not called", app.position(id.Pos()))
+				continue
+			}
+			if callExpr.Ellipsis.IsValid() {
+				return xerrors.Errorf("%s: call with ... cannot pass named arguments", app.position(callExpr.Pos()))
+			}
+
+			file := app.markModified(callExpr.Pos())
+			if file == nil {
+				return xerrors.Errorf("BUG: %s: could not find file", app.position(callExpr.Pos()))
+			}
+
+			var elts []ast.Expr
+			for _, field := range params {
+				for _, name := range field.Names {
+					if len(callExpr.Args) <= len(elts) {
+						return xerrors.Errorf("%s: call of %s has too few arguments to be named", app.position(callExpr.Pos()), f.Name())
+					}
+					elts = append(elts, &ast.KeyValueExpr{
+						Key:   ast.NewIdent(fieldNames[name.Name]),
+						Value: callExpr.Args[len(elts)],
+					})
+				}
+			}
+
+			before := app.nodeString(callExpr)
+
+			callExpr.Args = []ast.Expr{
+				&ast.CompositeLit{
+					Type: app.qualifiedTypeExpr(pkg, file, spec.pkg.PkgPath, structName),
+					Elts: elts,
+				},
+			}
+
+			app.recordChange(ChangeCall, callExpr.Pos(), before, app.nodeString(callExpr))
+		}
+	}
+
+	return nil
+}
+
+// replaceParamsWithNamedArgs replaces the references to the parameters of funcDecl in its body,
+// including the variable passed by rewritten calls, with the fields of the struct parameter.
+func (app *App) replaceParamsWithNamedArgs(pkg *packages.Package, funcDecl *ast.FuncDecl, fieldNames map[string]string) {
+	funcScope := pkg.TypesInfo.Scopes[funcDecl.Type]
+
+	// the variable passed by rewritten calls is not type-checked
+	insertedAt := map[ast.Expr]token.Pos{}
+	for _, inserted := range app.insertedArgs {
+		insertedAt[inserted.arg] = inserted.call.Pos()
+	}
+
+	astutil.Apply(funcDecl.Body, func(c *astutil.Cursor) bool {
+		id, ok := c.Node().(*ast.Ident)
+		if !ok {
+			return true
+		}
+		fieldName, ok := fieldNames[id.Name]
+		if !ok {
+			return true
+		}
+
+		var obj types.Object
+		if pos, ok := insertedAt[id]; ok {
+			_, obj = pkg.Types.Scope().Innermost(pos).LookupParent(id.Name, pos)
+		} else if obj = pkg.TypesInfo.Uses[id]; obj == nil {
+			return true
+		} else if v, ok := obj.(*types.Var); !ok || v.IsField() {
+			return true
+		}
+
+		// parameters, or the variable declared by a stub removed
+		if obj == nil || obj.Parent() == funcScope {
+			c.Replace(&ast.SelectorExpr{
+				X:   &ast.Ident{Name: namedArgsParam, NamePos: id.NamePos},
+				Sel: ast.NewIdent(fieldName),
+			})
+		}
+
+		return true
+	}, nil)
+}
+
+// qualifiedTypeExpr returns the expression referring to the type of name in the package of path
+// from file of pkg, adding the import of the package if required.
+func (app *App) qualifiedTypeExpr(pkg *packages.Package, file *ast.File, path, name string) ast.Expr {
+	if pkg.PkgPath == path {
+		return ast.NewIdent(name)
+	}
+
+	for _, spec := range file.Imports {
+		if p, err := strconv.Unquote(spec.Path.Value); err != nil || p != path {
+			continue
+		}
+		if spec.Name == nil {
+			break
+		}
+		if spec.Name.Name == "." {
+			return ast.NewIdent(name)
+		}
+		return &ast.SelectorExpr{X: ast.NewIdent(spec.Name.Name), Sel: ast.NewIdent(name)}
+	}
+
+	astutil.AddImport(app.Config.Fset, file, path)
+	return &ast.SelectorExpr{X: ast.NewIdent(app.packageName(path)), Sel: ast.NewIdent(name)}
+}
+
+// exportedName returns name with its first letter upper-cased, eg. "Ctx" for "ctx".
+func exportedName(name string) string {
+	r, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(r)) + name[size:]
+}
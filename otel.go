@@ -0,0 +1,44 @@
+package ctxize
+
+import (
+	"fmt"
+	"strconv"
+
+	"golang.org/x/xerrors"
+)
+
+const otelPkgPath = "go.opentelemetry.io/otel"
+
+// RewriteForOpenTelemetry is like Rewrite, but declares the variable, which must be context.Context,
+// in callers by starting an OpenTelemetry span of spanName with the tracer of tracerName
+// instead of VarSpec.InitExpr, ie.
+//
+//	ctx, span := otel.Tracer(tracerName).Start(context.TODO(), spanName)
+//	defer span.End()
+//
+// "go.opentelemetry.io/otel" is imported by the files modified, whether loaded or not.
+func (app *App) RewriteForOpenTelemetry(spec FuncSpec, tracerName, spanName string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	if obj := app.VarSpec.varTypeObj; obj == nil || obj.Pkg().Path() != "context" || obj.Name() != "Context" {
+		return xerrors.Errorf("variable type must be context.Context for OpenTelemetry, got %s.%s", app.VarSpec.PkgPath, app.VarSpec.TypeName)
+	}
+
+	varSpec := *app.VarSpec
+	varSpec.InitExpr = fmt.Sprintf("otel.Tracer(%s).Start(context.TODO(), %s)", strconv.Quote(tracerName), strconv.Quote(spanName))
+	varSpec.ResultName = "span"
+	varSpec.ResultExpr = "span.End()"
+	// these would declare the variable without a span
+	varSpec.InitExprForBackground = ""
+	varSpec.FactoryReceiver, varSpec.FactoryMethod = "", ""
+	varSpec.initPkgPaths = []string{"context", otelPkgPath}
+
+	orig := app.VarSpec
+	app.VarSpec = &varSpec
+	defer func() { app.VarSpec = orig }()
+
+	return app.rewrite(spec)
+}
@@ -0,0 +1,209 @@
+package ctxize
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// filePatch is the hunks of a unified diff for a file.
+type filePatch struct {
+	filename string
+	hunks    []patchHunk
+}
+
+// patchHunk is a hunk of a unified diff, starting at oldLine (1-based) of the original file.
+type patchHunk struct {
+	oldLine int
+	lines   []string // prefixed by ' ', '-' or '+'
+}
+
+// ApplyPatch applies patchData, a unified diff of files under Config.Dir, eg. the output of
+// "git diff" after running goctxize, to the original contents of the files, to be visited by Each
+// without rewriting again. Hunks must apply exactly; files modified by rewrites cannot be patched.
+func (app *App) ApplyPatch(patchData []byte) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	patches, err := parsePatch(patchData)
+	if err != nil {
+		return err
+	}
+
+	modified := map[string]bool{}
+	for file := range app.modified {
+		modified[app.position(file.Pos()).Filename] = true
+	}
+
+	for _, p := range patches {
+		if modified[p.filename] {
+			return xerrors.Errorf("%s: already modified by rewrite", p.filename)
+		}
+
+		content, ok := app.generated[p.filename]
+		if !ok {
+			filename := p.filename
+			if !filepath.IsAbs(filename) {
+				filename = filepath.Join(app.Config.Dir, filename)
+			}
+			content, ok = app.Config.Overlay[filename]
+			if !ok {
+				content, err = os.ReadFile(filename)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		patched, err := applyHunks(content, p.hunks)
+		if err != nil {
+			return xerrors.Errorf("%s: %w", p.filename, err)
+		}
+
+		debugf("%s: patched", p.filename)
+
+		app.generated[p.filename] = patched
+	}
+
+	return nil
+}
+
+// parsePatch parses a unified diff into patches of files, in order.
+func parsePatch(data []byte) ([]filePatch, error) {
+	var patches []filePatch
+
+	lines := strings.SplitAfter(string(data), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSuffix(lines[i], "\n")
+
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			name := strings.TrimPrefix(line, "+++ ")
+			if j := strings.IndexByte(name, '\t'); j != -1 {
+				name = name[:j]
+			}
+			if name == "/dev/null" {
+				return nil, xerrors.Errorf("line %d: deleting files is not supported", i+1)
+			}
+			patches = append(patches, filePatch{filename: filepath.FromSlash(strings.TrimPrefix(name, "b/"))})
+
+		case strings.HasPrefix(line, "@@ "):
+			if len(patches) == 0 {
+				return nil, xerrors.Errorf("line %d: hunk without file header", i+1)
+			}
+
+			// "@@ -<line>[,<count>] +<line>[,<count>] @@"
+			fields := strings.Fields(line)
+			if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+				return nil, xerrors.Errorf("line %d: malformed hunk header: %s", i+1, line)
+			}
+			oldLine, oldCount, err1 := parseHunkRange(fields[1][1:])
+			_, newCount, err2 := parseHunkRange(fields[2][1:])
+			if err1 != nil || err2 != nil {
+				return nil, xerrors.Errorf("line %d: malformed hunk header: %s", i+1, line)
+			}
+			// an empty range is after the line given
+			if oldCount == 0 {
+				oldLine++
+			}
+
+			hunk := patchHunk{oldLine: oldLine}
+			for (oldCount > 0 || newCount > 0) && i+1 < len(lines) {
+				i++
+				next := lines[i]
+				if next == "" {
+					break
+				}
+				if next == "\n" {
+					// empty context lines may lose their leading space
+					next = " \n"
+				}
+				switch next[0] {
+				case ' ':
+					oldCount--
+					newCount--
+				case '-':
+					oldCount--
+				case '+':
+					newCount--
+				default:
+					return nil, xerrors.Errorf("line %d: malformed hunk line: %s", i+1, strings.TrimSuffix(next, "\n"))
+				}
+				hunk.lines = append(hunk.lines, next)
+
+				// "\ No newline at end of file" applies to the line just read
+				if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "\\") {
+					i++
+					hunk.lines[len(hunk.lines)-1] = strings.TrimSuffix(next, "\n")
+				}
+			}
+			if oldCount != 0 || newCount != 0 {
+				return nil, xerrors.Errorf("line %d: hunk is truncated", i+1)
+			}
+
+			p := &patches[len(patches)-1]
+			p.hunks = append(p.hunks, hunk)
+		}
+	}
+
+	return patches, nil
+}
+
+// applyHunks applies hunks, in order of their positions, to content.
+func applyHunks(content []byte, hunks []patchHunk) ([]byte, error) {
+	lines := strings.SplitAfter(string(content), "\n")
+	if len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	var buf bytes.Buffer
+	cur := 0 // index of the next line of content to be written
+	for _, h := range hunks {
+		start := h.oldLine - 1
+		if start < cur || start > len(lines) {
+			return nil, xerrors.Errorf("hunk at line %d is out of order or range", h.oldLine)
+		}
+		for _, l := range lines[cur:start] {
+			buf.WriteString(l)
+		}
+		cur = start
+
+		for _, l := range h.lines {
+			switch l[0] {
+			case ' ', '-':
+				if cur >= len(lines) || lines[cur] != l[1:] {
+					return nil, xerrors.Errorf("hunk at line %d does not apply at line %d", h.oldLine, cur+1)
+				}
+				if l[0] == ' ' {
+					buf.WriteString(lines[cur])
+				}
+				cur++
+			case '+':
+				buf.WriteString(l[1:])
+			}
+		}
+	}
+	for _, l := range lines[cur:] {
+		buf.WriteString(l)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// parseHunkRange parses "<line>[,<count>]" of hunk headers.
+func parseHunkRange(s string) (line, count int, err error) {
+	count = 1
+	if i := strings.IndexByte(s, ','); i != -1 {
+		count, err = strconv.Atoi(s[i+1:])
+		if err != nil {
+			return
+		}
+		s = s[:i]
+	}
+	line, err = strconv.Atoi(s)
+	return
+}
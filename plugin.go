@@ -0,0 +1,49 @@
+package ctxize
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// Plugin is a hook into the rewrite pipeline, to further transform the rewritten files,
+// eg. for custom formatting or annotations.
+type Plugin interface {
+	// AfterRewrite is called with each function spec rewritten and the contents of
+	// files modified or generated, keyed by their filenames. Plugins can update
+	// the contents in place.
+	AfterRewrite(spec FuncSpec, modified map[string][]byte) error
+}
+
+type namedPlugin struct {
+	name   string
+	plugin Plugin
+}
+
+// RegisterPlugin registers plugin by name, to be run by Each before visiting the files.
+// Plugins are run in order of registration; registering a plugin with the name of
+// the one already registered replaces it.
+func (app *App) RegisterPlugin(name string, plugin Plugin) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	for i, p := range app.plugins {
+		if p.name == name {
+			app.plugins[i].plugin = plugin
+			return
+		}
+	}
+
+	app.plugins = append(app.plugins, namedPlugin{name: name, plugin: plugin})
+}
+
+// runPlugins runs the registered plugins on contents for each function spec rewritten.
+func (app *App) runPlugins(contents map[string][]byte) error {
+	for _, spec := range app.rewrittenSpecs {
+		for _, p := range app.plugins {
+			if err := p.plugin.AfterRewrite(spec, contents); err != nil {
+				return xerrors.Errorf("plugin %s: %w", p.name, err)
+			}
+		}
+	}
+
+	return nil
+}
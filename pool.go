@@ -0,0 +1,66 @@
+package ctxize
+
+import (
+	"fmt"
+
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// PoolContextError is returned when a call site is in the New function of sync.Pool,
+// which is called without arguments and so cannot be given the variable.
+type PoolContextError struct {
+	Filename string
+	Line     int
+	VarName  string
+}
+
+func (e *PoolContextError) Error() string {
+	return fmt.Sprintf(
+		"%s:%d: called in New of sync.Pool, which cannot receive %s; create values without %s in New and set up ones taken by Get with %s instead",
+		e.Filename, e.Line, e.VarName, e.VarName, e.VarName,
+	)
+}
+
+// checkPoolNew returns PoolContextError if the call at pos is in a function literal
+// given as New of sync.Pool, either in a composite literal or by an assignment.
+func (app *App) checkPoolNew(pkg *packages.Package, pos token.Pos) error {
+	path := app.pathEnclosing(pos)
+	for i, node := range path {
+		lit, ok := node.(*ast.FuncLit)
+		if !ok || i+1 >= len(path) {
+			continue
+		}
+
+		var field *ast.Ident
+		switch parent := path[i+1].(type) {
+		case *ast.KeyValueExpr:
+			field, _ = parent.Key.(*ast.Ident)
+		case *ast.AssignStmt:
+			for j, rhs := range parent.Rhs {
+				if rhs == lit && j < len(parent.Lhs) {
+					if sel, ok := parent.Lhs[j].(*ast.SelectorExpr); ok {
+						field = sel.Sel
+					}
+				}
+			}
+		}
+		if field == nil {
+			continue
+		}
+
+		// New is the only field of that name in package sync
+		v, ok := pkg.TypesInfo.Uses[field].(*types.Var)
+		if !ok || !v.IsField() || v.Pkg() == nil || v.Pkg().Path() != "sync" || v.Name() != "New" {
+			continue
+		}
+
+		p := app.position(pos)
+		return &PoolContextError{Filename: p.Filename, Line: p.Line, VarName: app.VarSpec.Name}
+	}
+
+	return nil
+}
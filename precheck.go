@@ -0,0 +1,187 @@
+package ctxize
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// PreCheckResult is a diagnostic reported by one of App.PreCheckers.
+type PreCheckResult struct {
+	Analyzer string
+	Position token.Position
+	Message  string
+}
+
+func (r PreCheckResult) String() string {
+	return fmt.Sprintf("%s: %s: %s", r.Position, r.Analyzer, r.Message)
+}
+
+// PreCheckFailedError is returned by Rewrite when App.PreCheckers report diagnostics
+// in files which would be modified.
+type PreCheckFailedError struct {
+	Results []PreCheckResult
+}
+
+func (e *PreCheckFailedError) Error() string {
+	lines := make([]string, 0, len(e.Results)+1)
+	lines = append(lines, "pre-check failed:")
+	for _, r := range e.Results {
+		lines = append(lines, "\t"+r.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// preCheck runs app.PreCheckers on the packages having the declaration or calls
+// of the function specified by spec, and returns PreCheckFailedError if any diagnostics
+// are reported in the files of them.
+func (app *App) preCheck(spec FuncSpec) error {
+	if len(app.PreCheckers) == 0 {
+		return nil
+	}
+
+	files := map[string]bool{}
+	var pkgs []*packages.Package
+	for _, pkg := range app.pkgs {
+		found := false
+		for id, obj := range pkg.TypesInfo.Defs {
+			if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+				files[app.Config.Fset.Position(id.Pos()).Filename] = true
+				found = true
+			}
+		}
+		for id, obj := range pkg.TypesInfo.Uses {
+			if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+				files[app.Config.Fset.Position(id.Pos()).Filename] = true
+				found = true
+			}
+		}
+		if found {
+			pkgs = append(pkgs, pkg)
+		}
+	}
+
+	var results []PreCheckResult
+	seen := map[string]bool{}
+	for _, pkg := range pkgs {
+		analyzed := map[*analysis.Analyzer]interface{}{}
+		for _, a := range app.PreCheckers {
+			diags, err := runPreChecker(pkg, a, analyzed)
+			if err != nil {
+				return xerrors.Errorf("running %s on %s: %w", a.Name, pkg.ID, err)
+			}
+
+			for _, d := range diags {
+				p := app.Config.Fset.Position(d.Pos)
+				if !files[p.Filename] {
+					continue
+				}
+
+				r := PreCheckResult{Analyzer: a.Name, Position: app.position(d.Pos), Message: d.Message}
+				if seen[r.String()] {
+					continue
+				}
+				seen[r.String()] = true
+				results = append(results, r)
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].String() < results[j].String() })
+
+	return &PreCheckFailedError{Results: results}
+}
+
+// runPreChecker runs a and its requirements on pkg, and returns the diagnostics reported by a.
+// Results of analyzers already run are cached in results.
+// Facts are available only within pkg; those of dependencies are not computed.
+func runPreChecker(pkg *packages.Package, a *analysis.Analyzer, results map[*analysis.Analyzer]interface{}) ([]analysis.Diagnostic, error) {
+	resultOf := map[*analysis.Analyzer]interface{}{}
+	for _, req := range a.Requires {
+		if _, ok := results[req]; !ok {
+			if _, err := runPreChecker(pkg, req, results); err != nil {
+				return nil, err
+			}
+		}
+		resultOf[req] = results[req]
+	}
+
+	type factKey struct {
+		obj types.Object
+		typ reflect.Type
+	}
+	objectFacts := map[factKey]analysis.Fact{}
+	packageFacts := map[factKey]analysis.Fact{}
+
+	var diags []analysis.Diagnostic
+	pass := &analysis.Pass{
+		Analyzer:   a,
+		Fset:       pkg.Fset,
+		Files:      pkg.Syntax,
+		OtherFiles: pkg.OtherFiles,
+		Pkg:        pkg.Types,
+		TypesInfo:  pkg.TypesInfo,
+		TypesSizes: pkg.TypesSizes,
+		ResultOf:   resultOf,
+		Report:     func(d analysis.Diagnostic) { diags = append(diags, d) },
+		ReadFile:   os.ReadFile,
+		ImportObjectFact: func(obj types.Object, fact analysis.Fact) bool {
+			f, ok := objectFacts[factKey{obj, reflect.TypeOf(fact)}]
+			if ok {
+				reflect.ValueOf(fact).Elem().Set(reflect.ValueOf(f).Elem())
+			}
+			return ok
+		},
+		ExportObjectFact: func(obj types.Object, fact analysis.Fact) {
+			objectFacts[factKey{obj, reflect.TypeOf(fact)}] = fact
+		},
+		ImportPackageFact: func(p *types.Package, fact analysis.Fact) bool {
+			if p != pkg.Types {
+				return false
+			}
+			f, ok := packageFacts[factKey{nil, reflect.TypeOf(fact)}]
+			if ok {
+				reflect.ValueOf(fact).Elem().Set(reflect.ValueOf(f).Elem())
+			}
+			return ok
+		},
+		ExportPackageFact: func(fact analysis.Fact) {
+			packageFacts[factKey{nil, reflect.TypeOf(fact)}] = fact
+		},
+		AllObjectFacts: func() []analysis.ObjectFact {
+			facts := make([]analysis.ObjectFact, 0, len(objectFacts))
+			for k, f := range objectFacts {
+				facts = append(facts, analysis.ObjectFact{Object: k.obj, Fact: f})
+			}
+			return facts
+		},
+		AllPackageFacts: func() []analysis.PackageFact {
+			facts := make([]analysis.PackageFact, 0, len(packageFacts))
+			for _, f := range packageFacts {
+				facts = append(facts, analysis.PackageFact{Package: pkg.Types, Fact: f})
+			}
+			return facts
+		},
+	}
+
+	result, err := a.Run(pass)
+	if err != nil {
+		return nil, err
+	}
+	results[a] = result
+
+	return diags, nil
+}
@@ -0,0 +1,200 @@
+package ctxize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/xerrors"
+)
+
+// ANSI escape sequences for PreviewSideBySide.
+const (
+	ansiBold  = "\x1b[1m"
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiReset = "\x1b[0m"
+)
+
+// previewContext is the number of unchanged lines shown around changes by PreviewSideBySide.
+const previewContext = 3
+
+// previewRow is a row of side-by-side comparison, either of which may be absent.
+type previewRow struct {
+	old, new       string
+	hasOld, hasNew bool
+	changed        bool
+}
+
+// PreviewSideBySide returns the changes of the files visited by Each, in two columns of
+// the original contents and the new ones fitted to width, for terminal display.
+// Deleted lines are colored red and added lines green by ANSI escape sequences.
+// Only changed lines and previewContext lines around them are shown.
+func (app *App) PreviewSideBySide(width int) (string, error) {
+	const sep = " | "
+	col := (width - len(sep)) / 2
+	if col < 8 {
+		return "", xerrors.Errorf("width %d is too narrow for side-by-side comparison", width)
+	}
+
+	contents := map[string][]byte{}
+	err := app.eachContent(func(filename string, content []byte) error {
+		contents[filename] = content
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	filenames := make([]string, 0, len(contents))
+	for filename := range contents {
+		filenames = append(filenames, filename)
+	}
+	sort.Strings(filenames)
+
+	var b strings.Builder
+	for _, filename := range filenames {
+		path := filename
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(app.Config.Dir, path)
+		}
+
+		// generated files may not exist yet
+		orig, err := os.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+
+		rows := diffRows(splitLines(string(orig)), splitLines(string(contents[filename])))
+
+		fmt.Fprintf(&b, "%s%s%s\n", ansiBold, filename, ansiReset)
+
+		last := -1
+		for i, row := range rows {
+			if !nearChange(rows, i) {
+				continue
+			}
+			if last != -1 && last != i-1 {
+				fmt.Fprintln(&b, "...")
+			}
+			last = i
+
+			left, right := fitColumn(row.old, col), strings.TrimRight(fitColumn(row.new, col), " ")
+			if row.changed && row.hasOld {
+				left = ansiRed + left + ansiReset
+			}
+			if row.changed && row.hasNew {
+				right = ansiGreen + right + ansiReset
+			}
+			fmt.Fprintf(&b, "%s%s%s\n", left, sep, right)
+		}
+	}
+
+	return b.String(), nil
+}
+
+// nearChange reports whether rows[i] is changed or within previewContext rows of a changed one.
+func nearChange(rows []previewRow, i int) bool {
+	for j := i - previewContext; j <= i+previewContext; j++ {
+		if 0 <= j && j < len(rows) && rows[j].changed {
+			return true
+		}
+	}
+	return false
+}
+
+// diffRows compares lines of a and b by their longest common subsequence, and returns
+// the rows of side-by-side comparison, where lines deleted and added in a run are paired.
+func diffRows(a, b []string) []previewRow {
+	// common prefix and suffix are trimmed to keep the table small
+	pre := 0
+	for pre < len(a) && pre < len(b) && a[pre] == b[pre] {
+		pre++
+	}
+	suf := 0
+	for suf < len(a)-pre && suf < len(b)-pre && a[len(a)-1-suf] == b[len(b)-1-suf] {
+		suf++
+	}
+	ma, mb := a[pre:len(a)-suf], b[pre:len(b)-suf]
+
+	// lcs[i][j] is the length of the LCS of ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var rows []previewRow
+	for _, line := range a[:pre] {
+		rows = append(rows, previewRow{old: line, new: line, hasOld: true, hasNew: true})
+	}
+
+	var deleted, added []string
+	flush := func() {
+		for k := 0; k < len(deleted) || k < len(added); k++ {
+			row := previewRow{changed: true}
+			if k < len(deleted) {
+				row.old, row.hasOld = deleted[k], true
+			}
+			if k < len(added) {
+				row.new, row.hasNew = added[k], true
+			}
+			rows = append(rows, row)
+		}
+		deleted, added = nil, nil
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			flush()
+			rows = append(rows, previewRow{old: ma[i], new: mb[j], hasOld: true, hasNew: true})
+			i++
+			j++
+		case j == len(mb) || i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]:
+			deleted = append(deleted, ma[i])
+			i++
+		default:
+			added = append(added, mb[j])
+			j++
+		}
+	}
+	flush()
+
+	for _, line := range a[len(a)-suf:] {
+		rows = append(rows, previewRow{old: line, new: line, hasOld: true, hasNew: true})
+	}
+
+	return rows
+}
+
+// splitLines splits s into lines without line terminators.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+// fitColumn expands tabs in line and truncates or pads it to width runes.
+func fitColumn(line string, width int) string {
+	line = strings.ReplaceAll(line, "\t", "    ")
+	if n := utf8.RuneCountInString(line); n <= width {
+		return line + strings.Repeat(" ", width-n)
+	}
+
+	runes := []rune(line)
+	return string(runes[:width-1]) + "…"
+}
@@ -0,0 +1,60 @@
+package ctxize
+
+import (
+	"bufio"
+	"io"
+	"strings"
+
+	"golang.org/x/xerrors"
+)
+
+// RewriteFromReader reads func specs, one per line, from r and rewrites them in order.
+// Blank lines and lines starting with "#" are ignored.
+// The packages of the specs and callerPkgs are loaded by Load(), so it must not be
+// called beforehand.
+func (app *App) RewriteFromReader(r io.Reader, callerPkgs []string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var specs []FuncSpec
+	var pkgPaths []string
+
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		spec, err := ParseFuncSpec(line)
+		if err != nil {
+			return xerrors.Errorf("line %d: %w", lineno, err)
+		}
+
+		specs = append(specs, spec)
+		if !containsString(pkgPaths, spec.PkgPath) {
+			pkgPaths = append(pkgPaths, spec.PkgPath)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if len(specs) == 0 {
+		return xerrors.New("no func specs given")
+	}
+
+	if err := app.load(append(pkgPaths, callerPkgs...)...); err != nil {
+		return err
+	}
+
+	for _, spec := range specs {
+		if err := app.rewrite(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
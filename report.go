@@ -0,0 +1,58 @@
+package ctxize
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RewriteReport is the changes made by rewriting, to be formatted for documentation.
+type RewriteReport []Change
+
+// RewriteReport returns the changes made so far as RewriteReport.
+func (app *App) RewriteReport() RewriteReport {
+	return RewriteReport(app.Changes())
+}
+
+// ToMarkdown formats r as a GitHub-flavored Markdown table with columns of
+// File, Location, Change Type, Before and After, eg. for pull request descriptions.
+func (r RewriteReport) ToMarkdown() string {
+	var b strings.Builder
+
+	b.WriteString("| File | Location | Change Type | Before | After |\n")
+	b.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, c := range r {
+		fmt.Fprintf(&b, "| %s | %d:%d | %s | %s | %s |\n", markdownCell(c.File), c.Line, c.Column, c.Kind, markdownCode(c.Before), markdownCode(c.After))
+	}
+
+	return b.String()
+}
+
+// markdownCell escapes s to be put in a cell of Markdown table, which cannot contain pipes or line breaks.
+func markdownCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+// markdownCode formats Go source s as a code span in a cell of Markdown table, or returns "" for empty s.
+// Line breaks are replaced by "; " as code spans cannot contain them in tables.
+func markdownCode(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	s = strings.Join(lines, "; ")
+
+	fence := "`"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	if strings.HasPrefix(s, "`") || strings.HasSuffix(s, "`") {
+		s = " " + s + " "
+	}
+
+	return fence + strings.ReplaceAll(s, "|", `\|`) + fence
+}
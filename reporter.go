@@ -0,0 +1,34 @@
+package ctxize
+
+// ChangeEvent is sent to the channel registered by RewriteReporter for each change
+// as it is made.
+type ChangeEvent struct {
+	Kind     string // one of ChangeDecl, ChangeCall and ChangeStub
+	Filename string
+	Line     int
+	FuncSpec FuncSpec // the function being rewritten
+}
+
+// RewriteReporter registers ch to receive a ChangeEvent for each change made by
+// the rewrites from then on, eg. to display the progress. Sends block, so ch should be
+// received from concurrently or be buffered enough. Passing nil unregisters the channel.
+func (app *App) RewriteReporter(ch chan<- ChangeEvent) {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	app.reporter = ch
+}
+
+// reportChange sends change to the channel registered by RewriteReporter, if any.
+func (app *App) reportChange(change Change) {
+	if app.reporter == nil {
+		return
+	}
+
+	app.reporter <- ChangeEvent{
+		Kind:     change.Kind,
+		Filename: change.File,
+		Line:     change.Line,
+		FuncSpec: app.current,
+	}
+}
@@ -0,0 +1,74 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// requestContext returns "r.Context()" if pos is inside an HTTP handler function,
+// which is func(w http.ResponseWriter, r *http.Request), and the variable is context.Context.
+// Only the innermost function enclosing pos is looked at, as function literals inside handlers
+// may run after the request is finished.
+func (app *App) requestContext(pkg *packages.Package, pos token.Pos) ast.Expr {
+	if obj := app.VarSpec.varTypeObj; obj.Pkg().Path() != "context" || obj.Name() != "Context" {
+		return nil
+	}
+
+	sig := app.innermostSignature(pkg, pos)
+	if sig == nil || sig.Params().Len() != 2 {
+		return nil
+	}
+
+	if !isNetHTTPType(sig.Params().At(0).Type(), "ResponseWriter") {
+		return nil
+	}
+	ptr, ok := sig.Params().At(1).Type().(*types.Pointer)
+	if !ok || !isNetHTTPType(ptr.Elem(), "Request") {
+		return nil
+	}
+
+	req := sig.Params().At(1)
+	if req.Name() == "" || req.Name() == "_" {
+		return nil
+	}
+	// the parameter may be shadowed at pos
+	if _, obj := pkg.Types.Scope().Innermost(pos).LookupParent(req.Name(), pos); obj != req {
+		return nil
+	}
+
+	debugf("%s: found request %s", app.position(pos), req.Name())
+
+	return &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   ast.NewIdent(req.Name()),
+			Sel: ast.NewIdent("Context"),
+		},
+	}
+}
+
+// innermostSignature returns the signature of the innermost function enclosing pos, or nil if not found.
+func (app *App) innermostSignature(pkg *packages.Package, pos token.Pos) *types.Signature {
+	for _, node := range app.pathEnclosing(pos) {
+		if lit, ok := node.(*ast.FuncLit); ok {
+			sig, _ := pkg.TypesInfo.TypeOf(lit).(*types.Signature)
+			return sig
+		}
+		if decl, ok := node.(*ast.FuncDecl); ok {
+			if f, ok := pkg.TypesInfo.Defs[decl.Name].(*types.Func); ok {
+				return f.Type().(*types.Signature)
+			}
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// isNetHTTPType reports whether t is the type named name in package net/http.
+func isNetHTTPType(t types.Type, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "net/http" && named.Obj().Name() == name
+}
@@ -0,0 +1,80 @@
+package ctxize
+
+import (
+	"os/exec"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// RewriteWithRetry loads pkgPaths and rewrites the function specified by spec as Rewrite does.
+// If loading fails because of modules missing from go.mod, eg. the package of VarSpec
+// is not required yet, "go mod tidy" is run in Config.Dir and packages are loaded again.
+// The packages are loaded by Load(), so it must not be called beforehand.
+func (app *App) RewriteWithRetry(spec FuncSpec, pkgPaths ...string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	err := app.loadModules(pkgPaths)
+	if err != nil {
+		if !isMissingModuleError(err) {
+			return err
+		}
+
+		debugf("%s; running go mod tidy", err)
+
+		if tidyErr := app.goModTidy(); tidyErr != nil {
+			return xerrors.Errorf("%v; go mod tidy: %w", err, tidyErr)
+		}
+
+		if retryErr := app.loadModules(pkgPaths); retryErr != nil {
+			return xerrors.Errorf("%v; after go mod tidy: %w", err, retryErr)
+		}
+	}
+
+	return app.rewrite(spec)
+}
+
+// loadModules loads pkgPaths by Load and returns the first error of the packages
+// about missing modules, if any.
+func (app *App) loadModules(pkgPaths []string) error {
+	err := app.load(pkgPaths...)
+	if err != nil {
+		return err
+	}
+
+	packages.Visit(app.pkgs, nil, func(pkg *packages.Package) {
+		for _, e := range pkg.Errors {
+			if err == nil && isMissingModuleError(e) {
+				err = e
+			}
+		}
+	})
+
+	return err
+}
+
+// isMissingModuleError reports whether err is caused by a module not listed in go.mod or go.sum.
+func isMissingModuleError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "no required module provides package") ||
+		strings.Contains(msg, "missing go.sum entry") ||
+		strings.Contains(msg, "cannot find module providing package")
+}
+
+// goModTidy runs "go mod tidy" in Config.Dir.
+func (app *App) goModTidy() error {
+	cmd := exec.Command("go", "mod", "tidy")
+	cmd.Dir = app.Config.Dir
+	cmd.Env = app.Config.Env
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return xerrors.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
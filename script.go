@@ -0,0 +1,121 @@
+package ctxize
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// IsRewritten reports whether the function specified by spec is rewritten by app,
+// or already takes the variable when loaded, that is, any of its parameters is
+// of the type specified by VarSpec.
+func (app *App) IsRewritten(spec FuncSpec) (bool, error) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return app.isRewritten(spec)
+}
+
+// isRewritten is IsRewritten without locking.
+func (app *App) isRewritten(spec FuncSpec) (bool, error) {
+	var err error
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return false, err
+	}
+
+	if app.rewritten[spec.String()] {
+		return true, nil
+	}
+
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			return app.accepts(f.Type().(*types.Signature)), nil
+		}
+	}
+
+	return false, xerrors.Errorf("could not find declaration of func %s in package %s", spec.FuncName, spec.PkgPath)
+}
+
+// WriteGenerateScript writes a shell script to w, which runs goctxize to apply the rewrites
+// made so far again, in order, with the options of app. Each rewrite is skipped
+// if "goctxize -check" reports the function already takes the variable, so that
+// the script can be run repeatedly.
+// Options goctxize has no flags for, eg. ResultName and ResultExpr of VarSpec, ChannelContextField
+// and the hooks, are not reproduced, nor are DryRun and DiffMode, with which files are not modified.
+func (app *App) WriteGenerateScript(w io.Writer) error {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var flags []string
+	flags = append(flags, "-var", shellQuote(fmt.Sprintf("%s %s.%s = %s", app.VarSpec.Name, app.VarSpec.PkgPath, app.VarSpec.TypeName, app.VarSpec.InitExpr)))
+	if app.NoStub {
+		flags = append(flags, "-no-stub")
+	}
+	if app.RewriteCompatMode {
+		flags = append(flags, "-shim")
+	}
+	if app.InferContext {
+		flags = append(flags, "-experimental-infer-context")
+	}
+	if app.AnnotateCallSites {
+		flags = append(flags, "-annotate")
+	}
+	if app.SQLCMode {
+		flags = append(flags, "-sqlc")
+	}
+	if app.WireMode {
+		flags = append(flags, "-wire")
+	}
+	if app.NamedArgMode {
+		flags = append(flags, "-named-args")
+	}
+	if app.AcknowledgePluginABIChange {
+		flags = append(flags, "-acknowledge-plugin-abi-change")
+	}
+	for _, exclude := range app.Exclude {
+		flags = append(flags, "-exclude", shellQuote(exclude))
+	}
+	for _, pattern := range app.ExcludePackagePatterns {
+		flags = append(flags, "-exclude-pattern", shellQuote(pattern))
+	}
+	for _, filename := range app.TemplateFiles {
+		flags = append(flags, "-template", shellQuote(filename))
+	}
+
+	var pkgPaths []string
+	for _, path := range app.pkgPaths {
+		pkgPaths = append(pkgPaths, shellQuote(path))
+	}
+
+	_, err := fmt.Fprint(w, "#!/bin/sh\n# Code generated by go-ctxize. DO NOT EDIT.\n\nset -e\n")
+	if err != nil {
+		return err
+	}
+
+	for _, spec := range app.rewrittenSpecs {
+		args := strings.Join(append(append(append([]string(nil), flags...), shellQuote(spec.String())), pkgPaths...), " ")
+		_, err := fmt.Fprintf(w, "\ngoctxize -check %s || goctxize %s\n", args, args)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+var rxShellSafe = regexp.MustCompile(`^[\w./:=@%+-]+$`)
+
+// shellQuote quotes s to be a single word in shell scripts, if required.
+func shellQuote(s string) string {
+	if rxShellSafe.MatchString(s) {
+		return s
+	}
+
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
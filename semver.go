@@ -0,0 +1,129 @@
+package ctxize
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/xerrors"
+)
+
+// RewriteWithSemVer migrates callers of the function specified by spec, in the module of
+// major version oldVersion, eg. "v1", to the one in the module of major version newVersion,
+// eg. "v2", which takes the variable. The import path of the new version is derived by
+// the semantic import versioning, eg. "example.com/pkg/v2" for "example.com/pkg".
+// The function in the new version is rewritten to take the variable unless it already does,
+// and the files calling the function are rewritten to pass the variable and import the new version.
+// Packages of both versions must be loaded beforehand; go.mod is not updated.
+func (app *App) RewriteWithSemVer(spec FuncSpec, oldVersion, newVersion string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	oldMajor, err := majorVersion(oldVersion)
+	if err != nil {
+		return err
+	}
+	newMajor, err := majorVersion(newVersion)
+	if err != nil {
+		return err
+	}
+
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return err
+	}
+	if spec.pkg.Module == nil {
+		return xerrors.Errorf("package %s is not in a module", spec.PkgPath)
+	}
+
+	oldModPath := spec.pkg.Module.Path
+	if modulePathForMajor(oldModPath, oldMajor) != oldModPath {
+		return xerrors.Errorf("module %s is not of version %s", oldModPath, oldVersion)
+	}
+	oldPath := spec.PkgPath
+	newPath := modulePathForMajor(oldModPath, newMajor) + strings.TrimPrefix(oldPath, oldModPath)
+
+	newSpec := spec
+	newSpec.PkgPath = newPath
+	newSpec.pkg, err = app.resolvePackage(newPath)
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := app.isRewritten(newSpec)
+	if err != nil {
+		return err
+	}
+	if !rewritten {
+		err = app.rewriteFuncDecl(newSpec)
+		if err != nil {
+			return err
+		}
+		app.rewritten[newSpec.String()] = true
+		app.rewrittenSpecs = append(app.rewrittenSpecs, newSpec)
+	}
+
+	callerFiles := map[string]bool{}
+	for _, pkg := range app.pkgs {
+		for id, obj := range pkg.TypesInfo.Uses {
+			if f, ok := obj.(*types.Func); ok && spec.matches(f) && !app.isExcluded(id.Pos()) {
+				callerFiles[app.Config.Fset.Position(id.Pos()).Filename] = true
+			}
+		}
+	}
+
+	err = app.rewriteCallers(spec)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range app.pkgs {
+		for _, file := range pkg.Syntax {
+			if !callerFiles[app.Config.Fset.Position(file.Pos()).Filename] {
+				continue
+			}
+			if astutil.RewriteImport(app.Config.Fset, file, oldPath, newPath) {
+				debugf("%s: import %s rewritten to %s", app.position(file.Pos()).Filename, oldPath, newPath)
+				app.markModified(file.Pos())
+			}
+		}
+	}
+
+	return nil
+}
+
+// majorVersion returns the major version number of version, eg. 2 for "v2" or "v2.1.0".
+func majorVersion(version string) (int, error) {
+	major := strings.TrimPrefix(version, "v")
+	if i := strings.IndexByte(major, '.'); i != -1 {
+		major = major[:i]
+	}
+
+	n, err := strconv.Atoi(major)
+	if err != nil || n < 0 || !strings.HasPrefix(version, "v") {
+		return 0, xerrors.Errorf("invalid version: %q", version)
+	}
+
+	return n, nil
+}
+
+// modulePathForMajor returns modPath with its major version suffix replaced for major,
+// eg. "example.com/pkg/v2" for "example.com/pkg" and 2.
+func modulePathForMajor(modPath string, major int) string {
+	if i := strings.LastIndexByte(modPath, '/'); i != -1 {
+		if n, err := majorVersion(modPath[i+1:]); err == nil && n >= 2 && modPath[i+1:] == fmt.Sprintf("v%d", n) {
+			modPath = modPath[:i]
+		}
+	}
+
+	if major <= 1 {
+		return modPath
+	}
+
+	return fmt.Sprintf("%s/v%d", modPath, major)
+}
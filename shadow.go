@@ -0,0 +1,167 @@
+package ctxize
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/shadow"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// ShadowError is returned by Each when App.RewriteWithShadowCheck is set and the variable
+// declared or passed by the rewrite shadows, or is shadowed by, another variable of the same
+// name and type, as reported by the shadow analyzer on the rewritten files.
+// Positions of Results are of the rewritten files.
+type ShadowError struct {
+	Results []PreCheckResult
+}
+
+func (e *ShadowError) Error() string {
+	lines := make([]string, 0, len(e.Results)+1)
+	lines = append(lines, "shadowing introduced by rewrite:")
+	for _, r := range e.Results {
+		lines = append(lines, "\t"+r.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkShadows type-checks the packages having files modified as rewritten,
+// and runs the shadow analyzer on them, returning ShadowError for diagnostics about
+// the variable in the modified files.
+// Type errors, eg. caused by calls to functions in other packages rewritten, are ignored.
+func (app *App) checkShadows() error {
+	modified := map[string]bool{}
+	for file := range app.modified {
+		modified[app.Config.Fset.Position(file.Pos()).Filename] = true
+	}
+
+	var results []PreCheckResult
+	seen := map[string]bool{}
+	for _, pkg := range app.pkgs {
+		hasModified := false
+		for _, file := range pkg.Syntax {
+			if app.modified[file] {
+				hasModified = true
+				break
+			}
+		}
+		if !hasModified {
+			continue
+		}
+
+		rewritten, err := app.typeCheckRewritten(pkg)
+		if err != nil {
+			return xerrors.Errorf("type-checking rewritten %s: %w", pkg.ID, err)
+		}
+
+		diags, err := runPreChecker(rewritten, shadow.Analyzer, map[*analysis.Analyzer]interface{}{})
+		if err != nil {
+			return xerrors.Errorf("running %s on %s: %w", shadow.Analyzer.Name, pkg.ID, err)
+		}
+
+		prefix := fmt.Sprintf("declaration of %q ", app.VarSpec.Name)
+		for _, d := range diags {
+			p := rewritten.Fset.Position(d.Pos)
+			if !modified[p.Filename] || !strings.HasPrefix(d.Message, prefix) {
+				continue
+			}
+			if filename, err := filepath.Rel(app.Config.Dir, p.Filename); err == nil {
+				p.Filename = filename
+			}
+
+			r := PreCheckResult{Analyzer: shadow.Analyzer.Name, Position: p, Message: d.Message}
+			if seen[r.String()] {
+				continue
+			}
+			seen[r.String()] = true
+			results = append(results, r)
+		}
+	}
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	sort.Slice(results, func(i, j int) bool { return results[i].String() < results[j].String() })
+
+	return &ShadowError{Results: results}
+}
+
+// typeCheckRewritten returns pkg with its files as rewritten, parsed and type-checked again.
+// Imports are resolved to the loaded packages.
+func (app *App) typeCheckRewritten(pkg *packages.Package) (*packages.Package, error) {
+	fset := token.NewFileSet()
+
+	var files []*ast.File
+	for _, file := range pkg.Syntax {
+		var buf bytes.Buffer
+		err := format.Node(&buf, app.Config.Fset, file)
+		if err != nil {
+			return nil, err
+		}
+
+		f, err := parser.ParseFile(fset, app.Config.Fset.Position(file.Pos()).Filename, buf.Bytes(), parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+
+	loaded := map[string]*types.Package{}
+	packages.Visit(app.pkgs, nil, func(p *packages.Package) {
+		if p.Types != nil && loaded[p.PkgPath] == nil {
+			loaded[p.PkgPath] = p.Types
+		}
+	})
+
+	conf := types.Config{
+		Importer: importerFunc(func(path string) (*types.Package, error) {
+			if p, ok := pkg.Imports[path]; ok && p.Types != nil {
+				return p.Types, nil
+			}
+			if p := loaded[path]; p != nil {
+				return p, nil
+			}
+			return nil, xerrors.Errorf("package %q not loaded", path)
+		}),
+		Sizes: pkg.TypesSizes,
+		Error: func(err error) {
+			debugf("type-checking rewritten %s: %s", pkg.ID, err)
+		},
+	}
+	info := &types.Info{
+		Types:      map[ast.Expr]types.TypeAndValue{},
+		Defs:       map[*ast.Ident]types.Object{},
+		Uses:       map[*ast.Ident]types.Object{},
+		Implicits:  map[ast.Node]types.Object{},
+		Selections: map[*ast.SelectorExpr]*types.Selection{},
+		Scopes:     map[ast.Node]*types.Scope{},
+	}
+	typesPkg, _ := conf.Check(pkg.PkgPath, fset, files, info)
+
+	return &packages.Package{
+		ID:         pkg.ID,
+		PkgPath:    pkg.PkgPath,
+		Fset:       fset,
+		Syntax:     files,
+		OtherFiles: pkg.OtherFiles,
+		Types:      typesPkg,
+		TypesInfo:  info,
+		TypesSizes: pkg.TypesSizes,
+	}, nil
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
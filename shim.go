@@ -0,0 +1,334 @@
+package ctxize
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+const shimSuffix = "WithContext"
+
+// GenerateShim keeps the function specified by spec, which must have been rewritten by Rewrite,
+// backwards-compatible for callers outside the loaded packages.
+// The rewritten function is renamed to have "WithContext" suffix along with its callers,
+// and a new file "shim<name>.go" is generated next to the declaration, which declares
+// a deprecated function of the original name and signature calling the renamed one as below:
+//
+//	// Deprecated: use FWithContext instead.
+//	func F(n int) error {
+//		return FWithContext(context.TODO(), n)
+//	}
+//
+// The generated file is visited by Each.
+func (app *App) GenerateShim(spec FuncSpec) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var err error
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	if !app.rewritten[spec.String()] {
+		return xerrors.Errorf("func %s must be rewritten before generating its shim", spec)
+	}
+
+	return app.generateShim(spec)
+}
+
+// generateShim is GenerateShim for spec already resolved and rewritten.
+func (app *App) generateShim(spec FuncSpec) error {
+	funcDecl, err := app.findFuncDecl(spec)
+	if err != nil {
+		return err
+	}
+
+	file := app.markModified(funcDecl.Pos())
+	if file == nil {
+		return xerrors.Errorf("BUG: %s: could not find file", app.position(funcDecl.Pos()))
+	}
+
+	name := funcDecl.Name.Name
+	newName := name + shimSuffix
+
+	content, err := app.shimSource(spec.pkg, file, funcDecl, newName)
+	if err != nil {
+		return err
+	}
+
+	for _, pkg := range app.pkgs {
+		// excluded call sites are not passed the variable, so keep calling the shim
+		if app.isExcludedPackage(pkg.PkgPath) {
+			continue
+		}
+
+		for id, obj := range pkg.TypesInfo.Uses {
+			if f, ok := obj.(*types.Func); ok && spec.matches(f) && !app.isExcluded(id.Pos()) {
+				id.Name = newName
+				app.markModified(id.Pos())
+			}
+		}
+	}
+	funcDecl.Name.Name = newName
+
+	shimName := "shim" + name + ".go"
+	if spec.TypeName != "" {
+		shimName = "shim" + spec.TypeName + name + ".go"
+	}
+	filename := filepath.Join(filepath.Dir(app.position(file.Pos()).Filename), shimName)
+
+	debugf("%s: generated shim", filename)
+
+	app.generated[filename] = content
+
+	return nil
+}
+
+// findFuncDecl finds the declaration of the function specified by spec.
+func (app *App) findFuncDecl(spec FuncSpec) (*ast.FuncDecl, error) {
+	for id, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			_, funcDecl, err := app.findScope(spec.pkg, id.Pos())
+			return funcDecl, err
+		}
+	}
+
+	return nil, xerrors.Errorf("could not find declaration of func %s in package %s", spec.FuncName, spec.PkgPath)
+}
+
+// shimSource builds the content of the shim file for funcDecl, whose first parameter
+// is the variable, calling the function of newName.
+func (app *App) shimSource(pkg *packages.Package, file *ast.File, funcDecl *ast.FuncDecl, newName string) ([]byte, error) {
+	params := funcDecl.Type.Params.List
+	if len(params) == 0 || len(params[0].Names) != 1 {
+		return nil, xerrors.Errorf("%s: first parameter of func %s must be the variable", app.position(funcDecl.Pos()), funcDecl.Name.Name)
+	}
+
+	fset := app.Config.Fset
+	nodeString := func(node ast.Node) (string, error) {
+		var buf bytes.Buffer
+		err := format.Node(&buf, fset, node)
+		return buf.String(), err
+	}
+
+	var paramDecls, args []string
+	for i, field := range params[1:] {
+		typ, err := nodeString(field.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		names := make([]string, 0, len(field.Names))
+		for j, n := range field.Names {
+			name := n.Name
+			if name == "_" {
+				name = fmt.Sprintf("arg%d_%d", i, j)
+			}
+			names = append(names, name)
+		}
+		if len(names) == 0 {
+			names = []string{fmt.Sprintf("arg%d", i)}
+		}
+
+		for _, name := range names {
+			if _, ok := field.Type.(*ast.Ellipsis); ok {
+				name += "..."
+			}
+			args = append(args, name)
+		}
+		paramDecls = append(paramDecls, strings.Join(names, ", ")+" "+typ)
+	}
+
+	// type parameters are forwarded explicitly, as they may not be inferred, eg. from results only
+	var typeParamDecls, typeArgs []string
+	if funcDecl.Type.TypeParams != nil {
+		for _, field := range funcDecl.Type.TypeParams.List {
+			constraint, err := nodeString(field.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			names := make([]string, 0, len(field.Names))
+			for _, n := range field.Names {
+				names = append(names, n.Name)
+			}
+			typeArgs = append(typeArgs, names...)
+			typeParamDecls = append(typeParamDecls, strings.Join(names, ", ")+" "+constraint)
+		}
+	}
+
+	callee := newName
+	if len(typeArgs) > 0 {
+		callee += "[" + strings.Join(typeArgs, ", ") + "]"
+	}
+	typeParams := ""
+	if len(typeParamDecls) > 0 {
+		typeParams = "[" + strings.Join(typeParamDecls, ", ") + "]"
+	}
+	recv := ""
+	if funcDecl.Recv != nil && len(funcDecl.Recv.List) == 1 {
+		field := funcDecl.Recv.List[0]
+		typ, err := nodeString(field.Type)
+		if err != nil {
+			return nil, err
+		}
+
+		recvName := "recv"
+		if len(field.Names) == 1 && field.Names[0].Name != "_" {
+			recvName = field.Names[0].Name
+		}
+		recv = fmt.Sprintf("(%s %s) ", recvName, typ)
+		callee = recvName + "." + newName
+	}
+
+	results := ""
+	if funcDecl.Type.Results != nil && len(funcDecl.Type.Results.List) > 0 {
+		var resultDecls []string
+		for _, field := range funcDecl.Type.Results.List {
+			typ, err := nodeString(field.Type)
+			if err != nil {
+				return nil, err
+			}
+			names := make([]string, 0, len(field.Names))
+			for _, n := range field.Names {
+				names = append(names, n.Name)
+			}
+			if len(names) > 0 {
+				typ = strings.Join(names, ", ") + " " + typ
+			}
+			resultDecls = append(resultDecls, typ)
+		}
+		results = " (" + strings.Join(resultDecls, ", ") + ")"
+	}
+
+	var body strings.Builder
+	arg := app.VarSpec.InitExpr
+	if app.VarSpec.ResultName != "" {
+		stmts, err := app.stubStmts(app.VarSpec.InitExpr)
+		if err != nil {
+			return nil, err
+		}
+		for _, stmt := range stmts {
+			s, err := nodeString(stmt)
+			if err != nil {
+				return nil, err
+			}
+			body.WriteString(s + "\n")
+		}
+		arg = app.VarSpec.Name
+	}
+	call := fmt.Sprintf("%s(%s)", callee, strings.Join(append([]string{arg}, args...), ", "))
+	if results != "" {
+		call = "return " + call
+	}
+	body.WriteString(call)
+
+	var src bytes.Buffer
+	fmt.Fprintf(&src, "package %s\n\n", file.Name.Name)
+	fmt.Fprintf(&src, "import %q\n", app.VarSpec.pkg.PkgPath)
+	for _, path := range app.VarSpec.initPkgPaths {
+		fmt.Fprintf(&src, "import %q\n", path)
+	}
+	for _, imp := range file.Imports {
+		if imp.Name != nil {
+			fmt.Fprintf(&src, "import %s %s\n", imp.Name.Name, imp.Path.Value)
+		} else {
+			fmt.Fprintf(&src, "import %s\n", imp.Path.Value)
+		}
+	}
+	fmt.Fprintf(&src, "\n// Deprecated: use %s instead.\n", newName)
+	fmt.Fprintf(&src, "func %s%s%s(%s)%s {\n%s\n}\n", recv, funcDecl.Name.Name, typeParams, strings.Join(paramDecls, ", "), results, body.String())
+
+	shimFset := token.NewFileSet()
+	shim, err := parser.ParseFile(shimFset, "", src.Bytes(), parser.ParseComments)
+	if err != nil {
+		return nil, xerrors.Errorf("BUG: parsing shim: %w", err)
+	}
+
+	removeUnusedImports(shim, func(path string) string {
+		if path == app.VarSpec.pkg.PkgPath {
+			return app.VarSpec.pkg.Name
+		}
+		if imported := pkg.Imports[path]; imported != nil {
+			return imported.Name
+		}
+		return app.packageName(path)
+	})
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, shimFset, shim); err != nil {
+		return nil, err
+	}
+
+	return format.Source(buf.Bytes())
+}
+
+// removeUnusedImports removes imports of file not referred to, or duplicated.
+// Names of the imported packages are resolved by pkgName.
+func removeUnusedImports(file *ast.File, pkgName func(path string) string) {
+	used := map[string]bool{}
+	ast.Inspect(file, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				used[id.Name] = true
+			}
+		}
+		return true
+	})
+
+	seen := map[string]bool{}
+	decls := file.Decls[:0]
+	for _, decl := range file.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.IMPORT {
+			decls = append(decls, decl)
+			continue
+		}
+
+		spec := genDecl.Specs[0].(*ast.ImportSpec)
+		path := strings.Trim(spec.Path.Value, `"`)
+
+		name := pkgName(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+
+		key := name + " " + path
+		if seen[key] || !used[name] {
+			continue
+		}
+		seen[key] = true
+		decls = append(decls, decl)
+	}
+	file.Decls = decls
+
+	imports := file.Imports[:0]
+	for _, decl := range file.Decls {
+		if genDecl, ok := decl.(*ast.GenDecl); ok && genDecl.Tok == token.IMPORT {
+			imports = append(imports, genDecl.Specs[0].(*ast.ImportSpec))
+		}
+	}
+	file.Imports = imports
+}
+
+// packageName returns the name of the package of path among loaded packages and their dependencies.
+func (app *App) packageName(path string) string {
+	var name string
+	packages.Visit(app.pkgs, nil, func(pkg *packages.Package) {
+		if pkg.PkgPath == path {
+			name = pkg.Name
+		}
+	})
+	return name
+}
@@ -0,0 +1,66 @@
+package ctxize
+
+import (
+	"fmt"
+
+	"go/ast"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+)
+
+// CannotRewriteError is returned when a call site cannot be given the variable
+// without breaking the code around.
+type CannotRewriteError struct {
+	Filename string
+	Line     int
+	Reason   string
+}
+
+func (e *CannotRewriteError) Error() string {
+	return fmt.Sprintf("%s:%d: cannot rewrite: %s", e.Filename, e.Line, e.Reason)
+}
+
+// sortInterface is the type equivalent to sort.Interface, built here
+// as package sort is not necessarily loaded.
+var sortInterface = func() *types.Interface {
+	intVar := func(name string) *types.Var { return types.NewParam(token.NoPos, nil, name, types.Typ[types.Int]) }
+	boolVar := types.NewParam(token.NoPos, nil, "", types.Typ[types.Bool])
+
+	methods := []*types.Func{
+		types.NewFunc(token.NoPos, nil, "Len", types.NewSignatureType(nil, nil, nil, nil, types.NewTuple(intVar("")), false)),
+		types.NewFunc(token.NoPos, nil, "Less", types.NewSignatureType(nil, nil, nil, types.NewTuple(intVar("i"), intVar("j")), types.NewTuple(boolVar), false)),
+		types.NewFunc(token.NoPos, nil, "Swap", types.NewSignatureType(nil, nil, nil, types.NewTuple(intVar("i"), intVar("j")), nil, false)),
+	}
+	return types.NewInterfaceType(methods, nil).Complete()
+}()
+
+// checkSortInterfaceMethod returns CannotRewriteError if funcDecl, enclosing the call at pos,
+// is a method of sort.Interface, whose signature cannot be changed to take the variable.
+func (app *App) checkSortInterfaceMethod(pkg *packages.Package, funcDecl *ast.FuncDecl, pos token.Pos) error {
+	if funcDecl.Recv == nil {
+		return nil
+	}
+	switch funcDecl.Name.Name {
+	case "Len", "Less", "Swap":
+	default:
+		return nil
+	}
+
+	f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+	if !ok {
+		return nil
+	}
+	recv := f.Type().(*types.Signature).Recv().Type()
+	if !types.Implements(recv, sortInterface) {
+		return nil
+	}
+
+	p := app.position(pos)
+	return &CannotRewriteError{
+		Filename: p.Filename,
+		Line:     p.Line,
+		Reason:   fmt.Sprintf("called in %s.%s which implements sort.Interface; its signature cannot take %s", types.TypeString(recv, types.RelativeTo(pkg.Types)), f.Name(), app.VarSpec.Name),
+	}
+}
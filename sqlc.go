@@ -0,0 +1,92 @@
+package ctxize
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go/ast"
+	"go/token"
+)
+
+// sqlcConfigFiles are the names of configuration files of sqlc, in the order sqlc looks them up.
+var sqlcConfigFiles = []string{"sqlc.yaml", "sqlc.yml", "sqlc.json"}
+
+// SQLCWarnings returns warnings for the changes made so far in files generated by sqlc,
+// if SQLCMode is set. sqlc has no configuration or query annotation to make generated
+// functions take the variable, nor to pass it to the functions they call (query methods
+// take context.Context already), so such changes are lost when the files are regenerated.
+// The messages refer to the sqlc configuration found in the directory of the file or its ancestors.
+func (app *App) SQLCWarnings() []ValidationWarning {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	if !app.SQLCMode {
+		return nil
+	}
+
+	configs := map[string]string{}
+	for file := range app.modified {
+		if isSQLCGenerated(file) {
+			filename := app.Config.Fset.Position(file.Pos()).Filename
+			configs[app.position(file.Pos()).Filename] = findSQLCConfig(filepath.Dir(filename))
+		}
+	}
+
+	var warnings []ValidationWarning
+	for _, change := range app.changes {
+		config, ok := configs[change.File]
+		if !ok {
+			continue
+		}
+
+		message := "changed in a file generated by sqlc, which is lost on regeneration"
+		if config != "" {
+			if rel, err := filepath.Rel(app.Config.Dir, config); err == nil {
+				config = rel
+			}
+			message += "; " + config + " cannot express the change"
+		}
+
+		warnings = append(warnings, ValidationWarning{
+			Pos:      token.Position{Filename: change.File, Line: change.Line, Column: change.Column},
+			Severity: SeverityWarning,
+			Message:  message,
+		})
+	}
+
+	return warnings
+}
+
+// isSQLCGenerated reports whether file has the header comment of files generated by sqlc.
+func isSQLCGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated by sqlc") {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// findSQLCConfig returns the path of the sqlc configuration file in dir or its ancestors,
+// or "" if not found.
+func findSQLCConfig(dir string) string {
+	for d := dir; ; d = filepath.Dir(d) {
+		for _, name := range sqlcConfigFiles {
+			config := filepath.Join(d, name)
+			if _, err := os.Stat(config); err == nil {
+				return config
+			}
+		}
+
+		if filepath.Dir(d) == d {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package ctxize
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// RewriteRecursiveStruct rewrites the function-typed struct field specified by spec
+// (spec.TypeName is the struct type and spec.FuncName is the field name)
+// to take the variable specified by VarSpec, as its first parameter unless VarSpec.Position is set.
+// Function values given to the field in composite literals are updated to accept the variable:
+// function literals get the new parameter directly, and other values except nil are wrapped
+// in function literals that pass the rest of the arguments through.
+// Calls of the field are rewritten as Rewrite does.
+func (app *App) RewriteRecursiveStruct(spec FuncSpec) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var err error
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	field, err := app.lookupFuncField(spec)
+	if err != nil {
+		return err
+	}
+
+	fieldNode, ok := app.findNodeEnclosing(field.Pos(), func(n ast.Node) (ok bool) { _, ok = n.(*ast.Field); return }).(*ast.Field)
+	if !ok {
+		return xerrors.Errorf("BUG: %s: could not find field declaration", app.position(field.Pos()))
+	}
+	if len(fieldNode.Names) != 1 {
+		return xerrors.Errorf("%s: field %s must be declared solely", app.position(field.Pos()), field.Name())
+	}
+	funcType, ok := fieldNode.Type.(*ast.FuncType)
+	if !ok {
+		return xerrors.Errorf("%s: type of field %s must be a func literal type", app.position(field.Pos()), field.Name())
+	}
+
+	debugf("%s: found field", app.position(field.Pos()))
+
+	if app.VarSpec.Position != 0 {
+		defer func(argIndex int) { app.argIndex = argIndex }(app.argIndex)
+		app.argIndex, err = app.signatureArgIndex(field.Type().Underlying().(*types.Signature), field.Pos(), spec.String())
+		if err != nil {
+			return err
+		}
+	}
+
+	file := app.markModified(fieldNode.Pos())
+	if file == nil {
+		return xerrors.Errorf("BUG: %s: could not find file", app.position(field.Pos()))
+	}
+	funcType.Params.List = app.insertVarField(file, funcType.Params)
+	app.addVarImport(file)
+
+	// test variants of packages share the syntax of the files with the packages
+	seen := map[token.Pos]bool{}
+
+	for _, pkg := range app.pkgs {
+		for _, file := range pkg.Syntax {
+			var err error
+			ast.Inspect(file, func(n ast.Node) bool {
+				if err != nil {
+					return false
+				}
+				if lit, ok := n.(*ast.CompositeLit); ok && !seen[lit.Lbrace] {
+					var rewritten bool
+					rewritten, err = app.rewriteFieldInCompositeLit(pkg, file, lit, field)
+					seen[lit.Lbrace] = rewritten
+				}
+				return true
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, pkg := range app.pkgs {
+		for id, obj := range pkg.TypesInfo.Uses {
+			if obj != field || seen[id.Pos()] || !isCalled(pkg, id) {
+				continue
+			}
+			seen[id.Pos()] = true
+
+			if err := app.rewriteCallSite(pkg, id.Pos()); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookupFuncField finds the function-typed field spec.FuncName of struct type spec.TypeName.
+func (app *App) lookupFuncField(spec FuncSpec) (*types.Var, error) {
+	obj, ok := spec.pkg.Types.Scope().Lookup(spec.TypeName).(*types.TypeName)
+	if !ok {
+		return nil, xerrors.Errorf("cannot find type %s in package %s", spec.TypeName, spec.PkgPath)
+	}
+
+	st, ok := obj.Type().Underlying().(*types.Struct)
+	if !ok {
+		return nil, xerrors.Errorf("type %s.%s is not a struct", spec.PkgPath, spec.TypeName)
+	}
+
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		if f.Name() != spec.FuncName {
+			continue
+		}
+		if _, ok := f.Type().Underlying().(*types.Signature); !ok {
+			return nil, xerrors.Errorf("field %s of %s.%s is not a func", spec.FuncName, spec.PkgPath, spec.TypeName)
+		}
+		return f, nil
+	}
+
+	return nil, xerrors.Errorf("cannot find field %s in %s.%s", spec.FuncName, spec.PkgPath, spec.TypeName)
+}
+
+// isCalled reports whether id is used as the function of a call expression, eg. "x.F()".
+func isCalled(pkg *packages.Package, id *ast.Ident) bool {
+	for _, file := range pkg.Syntax {
+		if !(file.Pos() <= id.Pos() && id.Pos() < file.End()) {
+			continue
+		}
+
+		path, _ := astutil.PathEnclosingInterval(file, id.Pos(), id.End())
+		if len(path) < 3 {
+			return false
+		}
+		if sel, ok := path[1].(*ast.SelectorExpr); ok && sel.Sel == id {
+			call, ok := path[2].(*ast.CallExpr)
+			return ok && call.Fun == sel
+		}
+		return false
+	}
+
+	return false
+}
+
+// rewriteFieldInCompositeLit rewrites the value of field in lit to accept the variable,
+// and reports whether lit has the field.
+func (app *App) rewriteFieldInCompositeLit(pkg *packages.Package, file *ast.File, lit *ast.CompositeLit, field *types.Var) (bool, error) {
+	st, ok := pkg.TypesInfo.TypeOf(lit).Underlying().(*types.Struct)
+	if !ok {
+		return false, nil
+	}
+
+	found := false
+
+	for i, elt := range lit.Elts {
+		var value *ast.Expr
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if key, ok := kv.Key.(*ast.Ident); ok && pkg.TypesInfo.Uses[key] == field {
+				value = &kv.Value
+			}
+		} else if i < st.NumFields() && st.Field(i) == field {
+			value = &lit.Elts[i]
+		}
+		if value == nil {
+			continue
+		}
+		found = true
+
+		if tv, ok := pkg.TypesInfo.Types[*value]; ok && tv.IsNil() {
+			continue
+		}
+
+		debugf("%s: found field value", app.position((*value).Pos()))
+
+		if funcLit, ok := (*value).(*ast.FuncLit); ok {
+			funcLit.Type.Params.List = app.insertVarField(file, funcLit.Type.Params)
+		} else {
+			wrapper, err := app.wrapFuncValue(pkg, file, *value, field.Type().Underlying().(*types.Signature))
+			if err != nil {
+				return false, err
+			}
+			*value = wrapper
+		}
+
+		app.markModified(lit.Pos())
+		app.addVarImport(file)
+	}
+
+	return found, nil
+}
+
+// wrapFuncValue builds a function literal which takes the variable and parameters of sig,
+// and calls value with the parameters.
+func (app *App) wrapFuncValue(pkg *packages.Package, file *ast.File, value ast.Expr, sig *types.Signature) (ast.Expr, error) {
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		astutil.AddImport(app.Config.Fset, file, p.Path())
+		return p.Name()
+	}
+
+	params := []string{}
+	args := []string{}
+	for i := 0; i < sig.Params().Len(); i++ {
+		p := sig.Params().At(i)
+		name := p.Name()
+		if name == "" || name == "_" || name == app.VarSpec.Name {
+			name = fmt.Sprintf("arg%d", i)
+		}
+
+		if sig.Variadic() && i == sig.Params().Len()-1 {
+			elem := p.Type().(*types.Slice).Elem()
+			params = append(params, name+" ..."+types.TypeString(elem, qualifier))
+			args = append(args, name+"...")
+		} else {
+			params = append(params, name+" "+types.TypeString(p.Type(), qualifier))
+			args = append(args, name)
+		}
+	}
+
+	varField := app.newVarField(file, token.NoPos)
+	params = insertString(params, app.argIndex, varField.Names[0].Name+" "+app.nodeString(varField.Type))
+
+	var results string
+	if sig.Results().Len() == 1 && sig.Results().At(0).Name() == "" {
+		results = types.TypeString(sig.Results().At(0).Type(), qualifier)
+	} else if sig.Results().Len() > 0 {
+		results = types.TypeString(sig.Results(), qualifier)
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, app.Config.Fset, value); err != nil {
+		return nil, err
+	}
+
+	call := fmt.Sprintf("%s(%s)", buf.String(), strings.Join(args, ", "))
+	if sig.Results().Len() > 0 {
+		call = "return " + call
+	}
+
+	src := fmt.Sprintf("func(%s) %s { %s }", strings.Join(params, ", "), results, call)
+	expr, err := parser.ParseExpr(src)
+	if err != nil {
+		return nil, xerrors.Errorf("BUG: parsing %q: %w", src, err)
+	}
+
+	clearPos(expr)
+
+	return expr, nil
+}
+
+// insertString returns ss with s inserted at i, or appended if i is out of range.
+func insertString(ss []string, i int, s string) []string {
+	if i > len(ss) {
+		i = len(ss)
+	}
+
+	result := make([]string, 0, len(ss)+1)
+	result = append(result, ss[:i]...)
+	result = append(result, s)
+	return append(result, ss[i:]...)
+}
+
+// clearPos resets all positions in the tree rooted at node,
+// so that the node parsed from another source can be put inside existing file.
+func clearPos(node ast.Node) {
+	setPos(node, token.NoPos)
+}
+
+// setPos sets all positions in the tree rooted at node to pos.
+func setPos(node ast.Node, pos token.Pos) {
+	posType := reflect.TypeOf(token.NoPos)
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			return false
+		}
+
+		v := reflect.ValueOf(n).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.Type() == posType && f.CanSet() {
+				f.Set(reflect.ValueOf(pos))
+			}
+		}
+		return true
+	})
+}
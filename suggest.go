@@ -0,0 +1,128 @@
+package ctxize
+
+import (
+	"sort"
+
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// SuggestFuncSpec proposes functions in packages pkgPaths to rewrite, which do not accept the variable yet.
+// Functions which call any function accepting the variable without having one are the pain points,
+// and each function is scored by the number of pain points it reaches, including itself,
+// through calls of functions not accepting the variable;
+// rewriting the function and propagating the variable down to its callees resolves that many pain points.
+// Functions reaching no pain points are omitted, and the rest are sorted by the score in descending order.
+// If no pkgPaths given, the packages given to Load are scanned.
+func (app *App) SuggestFuncSpec(pkgPaths ...string) ([]FuncSpec, error) {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	type node struct {
+		spec    FuncSpec
+		pain    bool
+		callees []token.Pos
+	}
+
+	// keyed by the positions of the declarations, as test variants of packages
+	// share the syntax of the files with the packages but not the objects
+	nodes := map[token.Pos]*node{}
+	for _, pkg := range app.pkgs {
+		if !app.isScanned(pkg, pkgPaths) {
+			continue
+		}
+
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				funcDecl, ok := decl.(*ast.FuncDecl)
+				if !ok || funcDecl.Body == nil {
+					continue
+				}
+
+				f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+				if !ok || nodes[f.Pos()] != nil || app.accepts(f.Type().(*types.Signature)) {
+					continue
+				}
+
+				n := &node{
+					spec: FuncSpec{PkgPath: pkg.PkgPath, FuncName: f.Name(), pkg: pkg},
+					pain: app.callsAccepting(pkg.TypesInfo, funcDecl.Body),
+				}
+				if recv := f.Type().(*types.Signature).Recv(); recv != nil {
+					named, ok := derefType(recv.Type()).(*types.Named)
+					if !ok {
+						continue
+					}
+					n.spec.TypeName = named.Obj().Name()
+				}
+
+				ast.Inspect(funcDecl.Body, func(x ast.Node) bool {
+					call, ok := x.(*ast.CallExpr)
+					if !ok {
+						return true
+					}
+
+					var id *ast.Ident
+					switch fun := call.Fun.(type) {
+					case *ast.Ident:
+						id = fun
+					case *ast.SelectorExpr:
+						id = fun.Sel
+					}
+					if id == nil {
+						return true
+					}
+					if callee, ok := pkg.TypesInfo.Uses[id].(*types.Func); ok {
+						n.callees = append(n.callees, callee.Origin().Pos())
+					}
+					return true
+				})
+
+				nodes[f.Pos()] = n
+			}
+		}
+	}
+
+	type scored struct {
+		spec  FuncSpec
+		score int
+	}
+	var results []scored
+	for pos, n := range nodes {
+		visited := map[token.Pos]bool{pos: true}
+		stack := []*node{n}
+		score := 0
+		for len(stack) > 0 {
+			m := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if m.pain {
+				score++
+			}
+			for _, callee := range m.callees {
+				if c := nodes[callee]; c != nil && !visited[callee] {
+					visited[callee] = true
+					stack = append(stack, c)
+				}
+			}
+		}
+
+		if score > 0 {
+			results = append(results, scored{spec: n.spec, score: score})
+		}
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].score != results[j].score {
+			return results[i].score > results[j].score
+		}
+		return results[i].spec.String() < results[j].spec.String()
+	})
+
+	specs := make([]FuncSpec, len(results))
+	for i, r := range results {
+		specs[i] = r.spec
+	}
+
+	return specs, nil
+}
@@ -0,0 +1,104 @@
+package ctxize
+
+import (
+	"sort"
+
+	"go/ast"
+	"go/token"
+	"go/types"
+)
+
+// RewritePackage rewrites all the exported functions and methods of exported types
+// declared in the package pkgPath which neither take nor return the variable, along with
+// their callers. Functions are rewritten in topological order of the calls in the package,
+// callees before callers, so that the callers take the variable from their own parameters
+// instead of declaring one.
+func (app *App) RewritePackage(pkgPath string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	pkg, err := app.resolvePackage(pkgPath)
+	if err != nil {
+		return err
+	}
+
+	specs := map[*types.Func]FuncSpec{}
+	decls := map[*types.Func]*ast.FuncDecl{}
+	for _, file := range pkg.Syntax {
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok || funcDecl.Body == nil || !funcDecl.Name.IsExported() {
+				continue
+			}
+
+			f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+			// sources of the variable are left untouched
+			if !ok || app.accepts(f.Type().(*types.Signature)) || app.returnsVar(f.Type().(*types.Signature)) {
+				continue
+			}
+
+			spec := FuncSpec{PkgPath: pkgPath, FuncName: f.Name(), pkg: pkg}
+			if recv := f.Type().(*types.Signature).Recv(); recv != nil {
+				named, ok := derefType(recv.Type()).(*types.Named)
+				if !ok || !token.IsExported(named.Obj().Name()) {
+					continue
+				}
+				spec.TypeName = named.Obj().Name()
+			}
+
+			specs[f] = spec
+			decls[f] = funcDecl
+		}
+	}
+
+	funcs := make([]*types.Func, 0, len(specs))
+	for f := range specs {
+		funcs = append(funcs, f)
+	}
+	sort.Slice(funcs, func(i, j int) bool { return specs[funcs[i]].String() < specs[funcs[j]].String() })
+
+	// depth-first search on calls, which visits callees first;
+	// recursive calls are rewritten in the order of names
+	var ordered []FuncSpec
+	visited := map[*types.Func]bool{}
+	var visit func(f *types.Func)
+	visit = func(f *types.Func) {
+		if visited[f] {
+			return
+		}
+		visited[f] = true
+
+		var callees []*types.Func
+		ast.Inspect(decls[f].Body, func(node ast.Node) bool {
+			if id, ok := node.(*ast.Ident); ok {
+				if callee, ok := pkg.TypesInfo.Uses[id].(*types.Func); ok {
+					if _, ok := specs[callee.Origin()]; ok {
+						callees = append(callees, callee.Origin())
+					}
+				}
+			}
+			return true
+		})
+		sort.Slice(callees, func(i, j int) bool { return specs[callees[i]].String() < specs[callees[j]].String() })
+
+		for _, callee := range callees {
+			visit(callee)
+		}
+
+		ordered = append(ordered, specs[f])
+	}
+	for _, f := range funcs {
+		visit(f)
+	}
+
+	for _, spec := range ordered {
+		debugf("%s: rewriting as a part of package %s", spec, pkgPath)
+		if err := app.rewriteResolved(spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package ctxize
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go/token"
+)
+
+// rewriteTemplates rewrites calls to the function specified by spec in app.TemplateFiles,
+// by replacing texts like "<pkg>.<func>(" or ".<method>(" with the ones passing the variable.
+// As this is a best-effort transformation unaware of the template syntax, each replacement
+// is reported by TemplateWarnings for review.
+func (app *App) rewriteTemplates(spec FuncSpec) error {
+	if len(app.TemplateFiles) == 0 {
+		return nil
+	}
+	if app.argIndex != 0 {
+		debugf("%s: templates are not rewritten for the variable not inserted first", spec)
+		return nil
+	}
+
+	prefix := regexp.QuoteMeta(spec.pkg.Name) + `\.`
+	if spec.TypeName != "" {
+		prefix = `\.`
+	}
+	rx := regexp.MustCompile(`(` + prefix + regexp.QuoteMeta(spec.FuncName) + `)\(\s*(\))?`)
+
+	for _, filename := range app.TemplateFiles {
+		if filepath.IsAbs(filename) {
+			if rel, err := filepath.Rel(app.Config.Dir, filename); err == nil {
+				filename = rel
+			}
+		}
+
+		content, ok := app.generated[filename]
+		if !ok {
+			path := filename
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(app.Config.Dir, path)
+			}
+
+			var err error
+			content, err = os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+		}
+
+		matches := rx.FindAllSubmatchIndex(content, -1)
+		if len(matches) == 0 {
+			continue
+		}
+
+		var b strings.Builder
+		last := 0
+		for _, m := range matches {
+			b.Write(content[last:m[0]])
+			b.Write(content[m[2]:m[3]])
+			if m[4] != -1 {
+				b.WriteString("(" + app.VarSpec.Name + ")")
+			} else {
+				b.WriteString("(" + app.VarSpec.Name + ", ")
+			}
+			last = m[1]
+
+			line := 1 + strings.Count(string(content[:m[0]]), "\n")
+			app.templateWarnings = append(app.templateWarnings, ValidationWarning{
+				Pos:      token.Position{Filename: filename, Line: line},
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("call of %s rewritten by text replacement, which may be incorrect", spec),
+			})
+		}
+		b.Write(content[last:])
+
+		debugf("%s: rewritten %d calls in template", filename, len(matches))
+
+		app.generated[filename] = []byte(b.String())
+	}
+
+	return nil
+}
+
+// TemplateWarnings returns the calls in App.TemplateFiles rewritten so far,
+// which are to be reviewed.
+func (app *App) TemplateWarnings() []ValidationWarning {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	return append([]ValidationWarning(nil), app.templateWarnings...)
+}
@@ -0,0 +1,14 @@
+package a
+
+import "context"
+
+func F(n int) { // want `a.F should take ctx context.Context`
+}
+
+func G(ctx context.Context) {
+	F(1) // want `call of a.F should pass ctx`
+}
+
+func H() {
+	F(2) // want `call of a.F should pass ctx`
+}
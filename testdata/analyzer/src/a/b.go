@@ -0,0 +1,5 @@
+package a
+
+func I() {
+	F(3) // want `call of a.F should pass ctx`
+}
@@ -0,0 +1,10 @@
+package b
+
+import stdctx "context"
+
+func F(n int) { // want `b.F should take ctx stdctx.Context`
+}
+
+func G(c stdctx.Context) {
+	F(1) // want `call of b.F should pass c`
+}
@@ -0,0 +1,9 @@
+package c
+
+func F(n int) { // want `c.F should take ctx context.Context`
+}
+
+func G() {
+	F(1) // want `call of c.F should pass ctx`
+	F(2) // want `call of c.F should pass ctx`
+}
@@ -0,0 +1,4 @@
+package d
+
+func F(n int) { // want `d.F should take tr trace.Tracer`
+}
@@ -0,0 +1,16 @@
+package annotated
+
+// F does something.
+//
+//ctxize:inject
+func F() {
+}
+
+//ctxize:inject "c context.Context = context.Background()"
+func G() {
+}
+
+func H() {
+	F()
+	G()
+}
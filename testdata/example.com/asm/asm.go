@@ -0,0 +1,7 @@
+package asm
+
+func Sum(x, y int) int
+
+func Double(x int) int {
+	return Sum(x, x)
+}
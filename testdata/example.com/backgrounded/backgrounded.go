@@ -0,0 +1,17 @@
+package backgrounded
+
+func F() {
+}
+
+func worker() {
+	F()
+}
+
+func handle() {
+	F()
+}
+
+func Start() {
+	go worker()
+	handle()
+}
@@ -0,0 +1,12 @@
+package batch
+
+func F() {
+}
+
+func G() {
+	F()
+}
+
+func H() {
+	G()
+}
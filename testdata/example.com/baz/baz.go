@@ -14,3 +14,10 @@ func alreadyHasCtxInside() {
 	ctx := context.TODO()
 	_ = ctx
 }
+
+type key struct{}
+
+func derivesCtxInside(base context.Context) {
+	ctx := context.WithValue(base, key{}, 1)
+	_ = ctx
+}
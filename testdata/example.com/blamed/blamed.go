@@ -0,0 +1,8 @@
+package blamed
+
+func F() {
+}
+
+func caller() {
+	F()
+}
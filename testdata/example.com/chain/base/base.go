@@ -0,0 +1,8 @@
+package base
+
+func F() {
+}
+
+func f() {
+	F()
+}
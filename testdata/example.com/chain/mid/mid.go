@@ -0,0 +1,10 @@
+package mid
+
+import (
+	"example.com/chain/base"
+)
+
+func M() {
+	base.F()
+	base.F()
+}
@@ -0,0 +1,11 @@
+package top
+
+import (
+	"example.com/chain/base"
+	"example.com/chain/mid"
+)
+
+func T() {
+	base.F()
+	mid.M()
+}
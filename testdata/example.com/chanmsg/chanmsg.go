@@ -0,0 +1,24 @@
+package chanmsg
+
+import "context"
+
+type Message struct {
+	Ctx context.Context
+	N   int
+}
+
+func F(n int) {
+}
+
+func Loop(ch <-chan Message) {
+	for msg := range ch {
+		F(msg.N)
+	}
+}
+
+func Once(ch <-chan *Message) {
+	msg, ok := <-ch
+	if ok {
+		F(msg.N + 1)
+	}
+}
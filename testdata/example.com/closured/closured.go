@@ -0,0 +1,34 @@
+package closured
+
+import (
+	"context"
+
+	"example.com/foo"
+)
+
+func Each(items []string, f func(string)) {
+	for _, item := range items {
+		f(item)
+	}
+}
+
+func Param(items []string) {
+	handle := func(ctx context.Context) {
+		Each(items, func(item string) {
+			foo.F()
+		})
+	}
+	handle(context.Background())
+}
+
+func Local(items []string) {
+	func() {
+		ctx := context.Background()
+		Each(items, func(item string) {
+			func() {
+				foo.F()
+			}()
+		})
+		_ = ctx
+	}()
+}
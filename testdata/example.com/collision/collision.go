@@ -0,0 +1,8 @@
+package collision
+
+func F() {
+}
+
+func G(ctx int) {
+	F()
+}
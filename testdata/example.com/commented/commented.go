@@ -0,0 +1,16 @@
+package commented
+
+import (
+	"context"
+)
+
+func F(x, y int) {
+}
+
+func G(ctx context.Context) {
+	F(
+		1, // x
+		2, // y
+	)
+	F(1 /* x */, 2)
+}
@@ -0,0 +1,23 @@
+package commenting
+
+import (
+	"context"
+)
+
+func F() {
+}
+
+func a(ctx context.Context) {
+	F()
+}
+
+func b(n int) {
+	n++
+	F()
+}
+
+func c(ctx context.Context) {
+	if ctx != nil {
+		F()
+	}
+}
@@ -0,0 +1,16 @@
+package concurrent
+
+func F() {
+}
+
+func G() {
+}
+
+func H() {
+}
+
+func caller() {
+	F()
+	G()
+	H()
+}
@@ -0,0 +1,12 @@
+package conflicting
+
+func F() {
+}
+
+func G() {
+}
+
+func caller() {
+	F()
+	G()
+}
@@ -0,0 +1,21 @@
+package constrained
+
+type Runner interface {
+	Run(n int)
+}
+
+type T struct{}
+
+func (T) Run(n int) {
+}
+
+func runAll[R Runner](rs []R) {
+	for _, r := range rs {
+		r.Run(1)
+	}
+}
+
+func caller() {
+	T{}.Run(2)
+	runAll([]T{{}})
+}
@@ -0,0 +1,15 @@
+package consumer
+
+import (
+	"context"
+
+	"example.com/vendored"
+)
+
+func Load(ctx context.Context) (string, error) {
+	return vendored.Get("a")
+}
+
+func Store() {
+	vendored.Put("b")
+}
@@ -0,0 +1,7 @@
+package consumer
+
+import "testing"
+
+func TestStore(t *testing.T) {
+	Store()
+}
@@ -0,0 +1,13 @@
+package converted
+
+type T struct{}
+
+func (t *T) Method(n int) {
+}
+
+type U T
+
+func use(u *U) {
+	(*T)(u).Method(1)
+	(*T)((*T)(u)).Method(2)
+}
@@ -0,0 +1,25 @@
+package coverage
+
+import "context"
+
+func Fetch(ctx context.Context, id int) error {
+	return nil
+}
+
+func Handle(ctx context.Context) error {
+	return Fetch(ctx, 1)
+}
+
+func Run() error {
+	return Fetch(context.TODO(), 2)
+}
+
+func Batch() {
+	for i := 0; i < 3; i++ {
+		Fetch(context.TODO(), i)
+	}
+}
+
+func Noop() int {
+	return 0
+}
@@ -0,0 +1,8 @@
+package contextpkg
+
+import "context"
+
+// Current returns the context registered for the current goroutine.
+func Current() context.Context {
+	return context.Background()
+}
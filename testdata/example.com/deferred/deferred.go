@@ -0,0 +1,23 @@
+package deferred
+
+func F() {
+}
+
+func G() {
+}
+
+func A() {
+	defer F()
+}
+
+func B(x int) {
+	if x > 0 {
+		return
+	}
+	defer F()
+}
+
+func C() {
+	G()
+	defer F()
+}
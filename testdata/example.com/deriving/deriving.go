@@ -0,0 +1,27 @@
+package deriving
+
+import (
+	"context"
+	"time"
+)
+
+type key struct{}
+
+var base = context.Background()
+
+func F(n int) {
+}
+
+func G() {
+	F(1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	F(2)
+}
+
+func H() {
+	F(3)
+	ctx := context.WithValue(base, key{}, 1)
+	F(4)
+	_ = ctx
+}
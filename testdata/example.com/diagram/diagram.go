@@ -0,0 +1,18 @@
+package diagram
+
+import "context"
+
+func F() {
+}
+
+func G() {
+	F()
+}
+
+func H() {
+	G()
+}
+
+func K(ctx context.Context) {
+	F()
+}
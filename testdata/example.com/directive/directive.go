@@ -0,0 +1,16 @@
+package directive
+
+// F does something.
+//
+//go:ctxize ctx context.Context = context.Background()
+func F() {
+}
+
+//go:ctxize
+func G() {
+}
+
+func H() {
+	F()
+	G()
+}
@@ -0,0 +1,11 @@
+package donechan
+
+import "context"
+
+func G(ctx context.Context) {
+	F(1)
+}
+
+func H() {
+	F(2)
+}
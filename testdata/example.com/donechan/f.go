@@ -0,0 +1,4 @@
+package donechan
+
+func F(x int) {
+}
@@ -0,0 +1,12 @@
+package dotimport
+
+import (
+	. "context"
+)
+
+func F() {
+}
+
+func G(ctx Context) {
+	F()
+}
@@ -0,0 +1,22 @@
+package earlyreturn
+
+func F(x int) error {
+	return nil
+}
+
+func G(x int) error {
+	if err := F(x); err != nil {
+		return err
+	}
+	return nil
+}
+
+func H(x int) error {
+	if x < 0 {
+		return nil
+	}
+	if err := F(x); err != nil {
+		return err
+	}
+	return F(x + 1)
+}
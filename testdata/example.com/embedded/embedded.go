@@ -0,0 +1,25 @@
+package embedded
+
+import "context"
+
+type Fetcher interface {
+	Fetch(key string) (string, error)
+}
+
+type CachingFetcher interface {
+	Fetcher
+	Purge()
+}
+
+type Service struct {
+	Fetcher
+}
+
+func (s *Service) Get(ctx context.Context, key string) (string, error) {
+	return s.Fetch(key)
+}
+
+func purge(f CachingFetcher) {
+	f.Fetch("")
+	f.Purge()
+}
@@ -0,0 +1,16 @@
+package extracting
+
+import "context"
+
+func GetContext(x int) (context.Context, error) {
+	return context.Background(), nil
+}
+
+func caller() error {
+	ctx, err := GetContext(1)
+	if err != nil {
+		return err
+	}
+	_ = ctx
+	return nil
+}
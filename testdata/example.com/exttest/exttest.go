@@ -0,0 +1,8 @@
+package exttest
+
+func F() {
+}
+
+func caller() {
+	F()
+}
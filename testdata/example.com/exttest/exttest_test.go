@@ -0,0 +1,11 @@
+package exttest_test
+
+import (
+	"testing"
+
+	"example.com/exttest"
+)
+
+func TestF(t *testing.T) {
+	exttest.F()
+}
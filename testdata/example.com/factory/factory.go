@@ -0,0 +1,14 @@
+package factory
+
+import "context"
+
+type Job struct {
+	ctx context.Context
+}
+
+func (j *Job) Context() context.Context {
+	return j.ctx
+}
+
+func F() {
+}
@@ -0,0 +1,5 @@
+package factory
+
+func other() {
+	F()
+}
@@ -0,0 +1,5 @@
+package factory
+
+func run(job *Job) {
+	F()
+}
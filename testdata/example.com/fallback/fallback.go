@@ -0,0 +1,23 @@
+package fallback
+
+import "context"
+
+func F() int {
+	return 0
+}
+
+var initial = F()
+
+type byF []int
+
+func (s byF) Len() int           { return len(s) }
+func (s byF) Less(i, j int) bool { return F() < s[j] }
+func (s byF) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func G() int {
+	return F()
+}
+
+func H(ctx context.Context) int {
+	return F()
+}
@@ -0,0 +1,17 @@
+package fiberhandler
+
+import (
+	"github.com/gofiber/fiber/v2"
+)
+
+func F() {
+}
+
+func Handle(c *fiber.Ctx) error {
+	F()
+	return nil
+}
+
+func G() {
+	F()
+}
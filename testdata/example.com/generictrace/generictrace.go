@@ -0,0 +1,14 @@
+package generictrace
+
+import "example.com/generictrace/tracer"
+
+func F() {
+}
+
+func handle(t tracer.Tracer[int]) {
+	F()
+}
+
+func other(t tracer.Tracer[string]) {
+	F()
+}
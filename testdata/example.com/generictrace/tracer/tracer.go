@@ -0,0 +1,9 @@
+package tracer
+
+type Tracer[T any] interface {
+	Span() T
+}
+
+func New() Tracer[int] {
+	return nil
+}
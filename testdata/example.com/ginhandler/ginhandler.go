@@ -0,0 +1,16 @@
+package ginhandler
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+func F() {
+}
+
+func Handle(c *gin.Context) {
+	F()
+}
+
+func G() {
+	F()
+}
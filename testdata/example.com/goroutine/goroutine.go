@@ -0,0 +1,27 @@
+package goroutine
+
+import (
+	"context"
+
+	"example.com/foo"
+)
+
+func Outer(ctx context.Context) {
+	go func() {
+		foo.F()
+	}()
+}
+
+func Own() {
+	go func(ctx context.Context) {
+		foo.F()
+	}(context.Background())
+}
+
+func Nested(ctx context.Context) {
+	go func() {
+		func() {
+			foo.F()
+		}()
+	}()
+}
@@ -0,0 +1,19 @@
+package grouped
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+func F(n int) error {
+	return nil
+}
+
+func G(ctx context.Context) error {
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return F(1)
+	})
+	return g.Wait()
+}
@@ -0,0 +1,14 @@
+package consumer
+
+import "example.com/handler"
+
+func Run(h handler.Handler) error {
+	return h.Process(2)
+}
+
+func Handlers() []handler.Handler {
+	return []handler.Handler{
+		{Name: "consumer", Process: func(n int) error { return nil }},
+		{Name: "none", Process: nil},
+	}
+}
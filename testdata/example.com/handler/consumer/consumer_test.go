@@ -0,0 +1,7 @@
+package consumer
+
+import "testing"
+
+func TestRun(t *testing.T) {
+	Run(Handlers()[0])
+}
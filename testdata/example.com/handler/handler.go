@@ -0,0 +1,22 @@
+package handler
+
+type Handler struct {
+	Name    string
+	Process func(n int) error
+}
+
+func process(n int) error {
+	return nil
+}
+
+func (h Handler) Run() error {
+	return h.Process(1)
+}
+
+func handlers() []Handler {
+	return []Handler{
+		{Name: "named", Process: process},
+		{Name: "literal", Process: func(n int) error { return nil }},
+		{"positional", process},
+	}
+}
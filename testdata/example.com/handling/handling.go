@@ -0,0 +1,22 @@
+package handling
+
+import (
+	"net/http"
+)
+
+func F() {
+}
+
+func Handle(w http.ResponseWriter, r *http.Request) {
+	F()
+}
+
+func Register(mux *http.ServeMux) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		F()
+	})
+}
+
+func NotHandler(r *http.Request) {
+	F()
+}
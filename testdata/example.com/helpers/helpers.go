@@ -0,0 +1,7 @@
+package helpers
+
+import "context"
+
+func Fetch(ctx context.Context, id int) (string, error) {
+	return "", nil
+}
@@ -0,0 +1,26 @@
+package helpers
+
+import (
+	"context"
+	"testing"
+)
+
+func mustFetch(t *testing.T, id int) string {
+	t.Helper()
+
+	s, err := Fetch(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func fail(t testing.TB) {
+	t.Fatal("fail")
+}
+
+func TestFetch(t *testing.T) {
+	if mustFetch(t, 1) != "" {
+		fail(t)
+	}
+}
@@ -0,0 +1,28 @@
+package inferred
+
+import (
+	"context"
+	"time"
+)
+
+func F(n int) {
+}
+
+func G(withDeadline bool) {
+	c := context.Background()
+	if withDeadline {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, time.Second)
+		defer cancel()
+	}
+	F(1)
+}
+
+func H(withDeadline bool) {
+	var c context.Context
+	if withDeadline {
+		c = context.Background()
+	}
+	F(2)
+	_ = c
+}
@@ -0,0 +1,23 @@
+package intercepted
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+func F() {
+}
+
+func dialOption() grpc.DialOption {
+	return grpc.WithUnaryInterceptor(func(_ context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		F()
+		F()
+		return nil
+	})
+}
+
+func logging(c context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	F()
+	return invoker(c, method, req, reply, cc, opts...)
+}
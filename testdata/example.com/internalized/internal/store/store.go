@@ -0,0 +1,5 @@
+package store
+
+func Get(key string) string {
+	return key
+}
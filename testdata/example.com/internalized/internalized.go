@@ -0,0 +1,9 @@
+package internalized
+
+import (
+	"example.com/internalized/internal/store"
+)
+
+func Lookup(key string) string {
+	return store.Get(key)
+}
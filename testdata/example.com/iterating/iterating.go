@@ -0,0 +1,35 @@
+package iterating
+
+import (
+	"context"
+	"iter"
+)
+
+func F(n int) {
+}
+
+func Items() iter.Seq[int] {
+	return func(yield func(int) bool) {
+		for i := range 3 {
+			F(i)
+			if !yield(i) {
+				return
+			}
+		}
+	}
+}
+
+func Consume(ctx context.Context) {
+	for i := range Items() {
+		F(i)
+	}
+}
+
+func ConsumeFunc(ctx context.Context) {
+	for i := range func(yield func(int) bool) {
+		F(0)
+		yield(1)
+	} {
+		F(i)
+	}
+}
@@ -0,0 +1,27 @@
+package leaking
+
+import (
+	"context"
+)
+
+var background = context.Background()
+
+func work(ctx context.Context) {
+}
+
+func Handle(ctx context.Context) {
+	go func() {
+		work(ctx)
+		work(ctx)
+	}()
+
+	go func(ctx context.Context) {
+		work(ctx)
+	}(ctx)
+
+	go func() {
+		work(background)
+	}()
+
+	go work(ctx)
+}
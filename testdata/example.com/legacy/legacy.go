@@ -0,0 +1,8 @@
+package legacy
+
+func F() {
+}
+
+func G() {
+	F()
+}
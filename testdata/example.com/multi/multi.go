@@ -0,0 +1,4 @@
+package multi
+
+func F() {
+}
@@ -0,0 +1,9 @@
+package sub
+
+import (
+	"example.com/multi"
+)
+
+func G() {
+	multi.F()
+}
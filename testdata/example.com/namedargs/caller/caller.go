@@ -0,0 +1,7 @@
+package caller
+
+import "example.com/namedargs"
+
+func H() string {
+	return namedargs.F("h", 2)
+}
@@ -0,0 +1,11 @@
+package namedargs
+
+import "fmt"
+
+func F(name string, count int) string {
+	return fmt.Sprintf("%s:%d", name, count)
+}
+
+func G(name string) string {
+	return F(name, 1)
+}
@@ -0,0 +1,32 @@
+package nestedclosure
+
+import (
+	"context"
+)
+
+func F() {
+}
+
+func A(ctx context.Context) {
+	go func() {
+		func() {
+			F()
+		}()
+	}()
+}
+
+var handler = func(ctx context.Context) {
+	go func() {
+		func() {
+			F()
+		}()
+	}()
+}
+
+func B() {
+	go func() {
+		func() {
+			F()
+		}()
+	}()
+}
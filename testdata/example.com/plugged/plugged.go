@@ -0,0 +1,10 @@
+//go:generate go build -buildmode=plugin -o plugged.so .
+
+package main
+
+func F() {
+}
+
+func caller() {
+	F()
+}
@@ -0,0 +1,26 @@
+package pooled
+
+import (
+	"context"
+	"sync"
+)
+
+type Buffer struct{}
+
+func NewBuffer() *Buffer {
+	return &Buffer{}
+}
+
+func Setup(ctx context.Context) *sync.Pool {
+	return &sync.Pool{
+		New: func() any {
+			return NewBuffer()
+		},
+	}
+}
+
+func Assign(ctx context.Context, pool *sync.Pool) {
+	pool.New = func() any { return NewBuffer() }
+}
+
+var global = sync.Pool{New: func() any { return NewBuffer() }}
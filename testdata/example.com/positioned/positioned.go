@@ -0,0 +1,9 @@
+package positioned
+
+func F(a, b int) int {
+	return a + b
+}
+
+func G() int {
+	return F(1, 2)
+}
@@ -0,0 +1,11 @@
+package prechecked
+
+func F(n int) int {
+	return n
+}
+
+func G() {
+	n := 1
+	n = n
+	F(n)
+}
@@ -0,0 +1,21 @@
+package private
+
+type store struct{}
+
+func (s *store) get(key string) string {
+	return key
+}
+
+type cache[K comparable] struct{}
+
+func (c cache[K]) lookup(key K) bool {
+	return false
+}
+
+func use() {
+	s := &store{}
+	s.get("a")
+
+	c := cache[string]{}
+	c.lookup("b")
+}
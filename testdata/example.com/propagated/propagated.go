@@ -0,0 +1,24 @@
+package propagated
+
+type Getter interface {
+	Get(key string) string
+}
+
+type ReadCloser interface {
+	Getter
+	Close() error
+}
+
+type store struct{}
+
+func (s *store) Get(key string) string {
+	return key
+}
+
+func (s *store) Close() error {
+	return nil
+}
+
+func Use(g Getter) string {
+	return g.Get("x")
+}
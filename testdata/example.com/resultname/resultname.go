@@ -0,0 +1,15 @@
+package resultname
+
+import "errors"
+
+func F() (context error) {
+	return errors.New("F")
+}
+
+func G() (context error) {
+	context = F()
+	if context != nil {
+		return
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package selecting
+
+import "context"
+
+func F(n int) {
+}
+
+func G(ctx context.Context, ch chan int) {
+	select {
+	case n := <-ch:
+		F(n)
+	case <-ctx.Done():
+		F(0)
+	}
+}
+
+func H(ch chan int) {
+	for {
+		select {
+		case n := <-ch:
+			F(n)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package caller
+
+import (
+	"context"
+
+	"example.com/semver"
+)
+
+func Call(ctx context.Context) int {
+	return semver.F(1)
+}
@@ -0,0 +1,5 @@
+package semver
+
+func F(x int) int {
+	return x * 2
+}
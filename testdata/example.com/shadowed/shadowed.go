@@ -0,0 +1,20 @@
+package shadowed
+
+import "context"
+
+type key struct{}
+
+func F() {
+}
+
+func caller(ok bool) {
+	if ok {
+		ctx := context.WithValue(context.Background(), key{}, 1)
+		_ = ctx
+	}
+	F()
+}
+
+func other() {
+	F()
+}
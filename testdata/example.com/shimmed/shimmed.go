@@ -0,0 +1,27 @@
+package shimmed
+
+import (
+	"strings"
+)
+
+func F(n int, opts ...string) (string, error) {
+	return strings.Repeat("x", n), nil
+}
+
+func G() {
+	F(1, "a")
+}
+
+func H() {
+	F(2)
+}
+
+func Zero[T any, U comparable](n int) (T, U) {
+	var t T
+	var u U
+	return t, u
+}
+
+func I() {
+	Zero[string, int](1)
+}
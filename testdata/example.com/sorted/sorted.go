@@ -0,0 +1,15 @@
+package sorted
+
+type Item struct {
+	Key string
+}
+
+func Less(x, y Item) bool {
+	return x.Key < y.Key
+}
+
+type byKey []Item
+
+func (s byKey) Len() int           { return len(s) }
+func (s byKey) Less(i, j int) bool { return Less(s[i], s[j]) }
+func (s byKey) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,11 @@
+package sqlcgen
+
+type Queries struct{}
+
+func (q *Queries) logQuery(query string) error {
+	return nil
+}
+
+func (q *Queries) Ping() error {
+	return q.logQuery("SELECT 1")
+}
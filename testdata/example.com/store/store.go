@@ -0,0 +1,19 @@
+package store
+
+func Get(key string) string {
+	return ""
+}
+
+func Set(key, value string) {
+}
+
+func Delete(key string) {
+}
+
+func List() []string {
+	return nil
+}
+
+func Lookup(key string) bool {
+	return Get(key) != ""
+}
@@ -0,0 +1,30 @@
+package suggested
+
+import "context"
+
+func Fetch(ctx context.Context, id int) error {
+	return nil
+}
+
+func loadUser(id int) error {
+	return Fetch(context.TODO(), id)
+}
+
+func loadGroup(id int) error {
+	return Fetch(context.TODO(), id)
+}
+
+func loadAll() error {
+	if err := loadUser(1); err != nil {
+		return err
+	}
+	return loadGroup(1)
+}
+
+func Handle() error {
+	return loadAll()
+}
+
+func helper() int {
+	return 1
+}
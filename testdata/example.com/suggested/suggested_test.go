@@ -0,0 +1,9 @@
+package suggested
+
+import "testing"
+
+func TestHelper(t *testing.T) {
+	if helper() != 1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,29 @@
+package surface
+
+import "context"
+
+type Client struct{}
+
+func (c *Client) Do() {
+	Fetch()
+}
+
+func Fetch() {
+	helper()
+}
+
+func helper() {
+}
+
+func Already(ctx context.Context) {
+	Fetch()
+}
+
+func Run() {
+	c := &Client{}
+	c.Do()
+}
+
+func caller() {
+	Run()
+}
@@ -0,0 +1,24 @@
+package switching
+
+import "context"
+
+func F(n int) {
+}
+
+func G(mode string) {
+	switch mode {
+	case "background":
+		c := context.Background()
+		F(1)
+		_ = c
+	default:
+		F(2)
+	}
+}
+
+func H(ctx context.Context, n int) {
+	switch {
+	case n > 0:
+		F(n)
+	}
+}
@@ -0,0 +1,4 @@
+package templated
+
+func F(args ...int) {
+}
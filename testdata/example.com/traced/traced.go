@@ -0,0 +1,19 @@
+package traced
+
+import "context"
+
+type Span struct{}
+
+func (s *Span) End() {
+}
+
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	return ctx, &Span{}
+}
+
+func F() {
+}
+
+func G() {
+	F()
+}
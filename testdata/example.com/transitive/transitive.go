@@ -0,0 +1,21 @@
+package transitive
+
+func C() {
+}
+
+func B() {
+	C()
+}
+
+func A() {
+	B()
+}
+
+func D() {
+	E()
+	C()
+}
+
+func E() {
+	D()
+}
@@ -0,0 +1,7 @@
+package transitive
+
+import "testing"
+
+func TestA(t *testing.T) {
+	A()
+}
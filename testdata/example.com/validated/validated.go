@@ -0,0 +1,14 @@
+package validated
+
+import "context"
+
+func F() {
+}
+
+func handle(ctx context.Context) {
+	F()
+}
+
+func run() {
+	F()
+}
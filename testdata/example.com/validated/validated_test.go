@@ -0,0 +1,7 @@
+package validated
+
+import "testing"
+
+func TestF(t *testing.T) {
+	F()
+}
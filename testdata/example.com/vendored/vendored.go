@@ -0,0 +1,8 @@
+package vendored
+
+func Get(key string) (string, error) {
+	return key, nil
+}
+
+func Put(key string) {
+}
@@ -0,0 +1,16 @@
+package versioned
+
+import (
+	"context"
+)
+
+func FuncV1(x int) {
+}
+
+func FuncV2(ctx context.Context, x int) {
+}
+
+func call(ctx context.Context) {
+	FuncV1(1)
+	FuncV2(ctx, 2)
+}
@@ -0,0 +1,17 @@
+//go:build wireinject
+
+package wired
+
+import (
+	"github.com/google/wire"
+)
+
+func InitializeBar() *Bar {
+	wire.Build(Set)
+	return nil
+}
+
+func InitializeNothing() *Bar {
+	wire.Build(NewBar)
+	return nil
+}
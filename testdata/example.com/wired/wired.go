@@ -0,0 +1,23 @@
+package wired
+
+import (
+	"github.com/google/wire"
+)
+
+type Foo struct{}
+
+func NewFoo() *Foo {
+	return &Foo{}
+}
+
+type Bar struct {
+	Foo *Foo
+}
+
+func NewBar(foo *Foo) *Bar {
+	return &Bar{Foo: foo}
+}
+
+var FooSet = wire.NewSet(NewFoo)
+
+var Set = wire.NewSet(FooSet, NewBar)
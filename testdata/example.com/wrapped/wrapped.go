@@ -0,0 +1,31 @@
+package wrapped
+
+import (
+	"context"
+)
+
+func F(x int) (int, error) {
+	return x, nil
+}
+
+func Check(x int) error {
+	return nil
+}
+
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+func mustOK(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+func G(ctx context.Context) int {
+	mustOK(Check(1))
+	return must(F(2))
+}
@@ -0,0 +1,24 @@
+// Package gin is a minimal stub of github.com/gin-gonic/gin for tests.
+package gin
+
+import "time"
+
+type Context struct{}
+
+func (c *Context) Deadline() (deadline time.Time, ok bool) {
+	return
+}
+
+func (c *Context) Done() <-chan struct{} {
+	return nil
+}
+
+func (c *Context) Err() error {
+	return nil
+}
+
+func (c *Context) Value(key any) any {
+	return nil
+}
+
+type HandlerFunc func(*Context)
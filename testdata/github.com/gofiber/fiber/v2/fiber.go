@@ -0,0 +1,12 @@
+// Package fiber is a minimal stub of github.com/gofiber/fiber/v2 for tests.
+package fiber
+
+import "context"
+
+type Ctx struct{}
+
+func (c *Ctx) UserContext() context.Context {
+	return context.Background()
+}
+
+type Handler = func(*Ctx) error
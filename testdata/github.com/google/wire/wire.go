@@ -0,0 +1,12 @@
+// Package wire is a minimal stub of github.com/google/wire for tests.
+package wire
+
+type ProviderSet struct{}
+
+func NewSet(...interface{}) ProviderSet {
+	return ProviderSet{}
+}
+
+func Build(...interface{}) string {
+	return ""
+}
@@ -0,0 +1,17 @@
+// Package errgroup is a minimal stub of golang.org/x/sync/errgroup for tests.
+package errgroup
+
+import "context"
+
+type Group struct{}
+
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	return &Group{}, ctx
+}
+
+func (g *Group) Go(f func() error) {
+}
+
+func (g *Group) Wait() error {
+	return nil
+}
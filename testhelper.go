@@ -0,0 +1,148 @@
+package ctxize
+
+import (
+	"go/ast"
+	"go/types"
+	"strings"
+)
+
+// RewriteTestHelpers adds the variable to test helper functions in packages pkgPaths,
+// that is, functions taking *testing.T, *testing.B or testing.TB as the first parameter,
+// which call any function accepting the variable but do not accept it themselves.
+// The variable is inserted after the first parameter, and callers of the helpers are rewritten as Rewrite does.
+// If the variable is a context.Context, the callers declare it by "context.Background()".
+// In the helpers, arguments which are newly made variables, eg. "context.Background()", are replaced by the parameter.
+// If no pkgPaths given, all loaded packages are scanned.
+func (app *App) RewriteTestHelpers(pkgPaths ...string) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var specs []FuncSpec
+	var funcDecls []*ast.FuncDecl
+	seen := map[string]bool{}
+
+	for _, pkg := range app.pkgs {
+		if len(pkgPaths) > 0 && !containsString(pkgPaths, pkg.PkgPath) {
+			continue
+		}
+
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				funcDecl, ok := decl.(*ast.FuncDecl)
+				if !ok || funcDecl.Recv != nil || funcDecl.Body == nil {
+					continue
+				}
+
+				f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+				if !ok || !isTestHelper(f) {
+					continue
+				}
+
+				pos := app.position(funcDecl.Pos())
+				if seen[pos.String()] {
+					continue
+				}
+				seen[pos.String()] = true
+
+				if app.accepts(f.Type().(*types.Signature)) || !app.callsAccepting(pkg.TypesInfo, funcDecl.Body) {
+					continue
+				}
+
+				debugf("%s: found test helper %s", pos, f.Name())
+
+				specs = append(specs, FuncSpec{PkgPath: pkg.PkgPath, FuncName: f.Name(), pkg: pkg})
+				funcDecls = append(funcDecls, funcDecl)
+			}
+		}
+	}
+
+	defaultVarSpec := app.VarSpec
+	defer func() {
+		app.VarSpec = defaultVarSpec
+		app.argIndex = 0
+	}()
+
+	if app.VarSpec.PkgPath == "context" && app.VarSpec.TypeName == "Context" {
+		varSpec := *app.VarSpec
+		varSpec.InitExpr = "context.Background()"
+		varSpec.ResultName, varSpec.ResultExpr = "", ""
+		app.VarSpec = &varSpec
+	}
+	app.argIndex = 1
+
+	initExprs := []string{defaultVarSpec.InitExpr, app.VarSpec.InitExpr}
+
+	for i, spec := range specs {
+		if err := app.rewriteResolved(spec); err != nil {
+			return err
+		}
+
+		if app.declRewritten[spec.String()] {
+			app.passVarParam(spec.pkg.TypesInfo, funcDecls[i], initExprs)
+		}
+	}
+
+	return nil
+}
+
+// passVarParam replaces the arguments in the body of funcDecl passed as the variable
+// and made by any of initExprs, eg. "Fetch(context.Background(), id)", by the parameter of the variable.
+func (app *App) passVarParam(typesInfo *types.Info, funcDecl *ast.FuncDecl, initExprs []string) {
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
+
+		sig, ok := typesInfo.TypeOf(call.Fun).(*types.Signature)
+		if !ok {
+			return true
+		}
+
+		before := app.nodeString(call)
+		for i, arg := range call.Args {
+			if i >= sig.Params().Len() || !types.Identical(sig.Params().At(i).Type(), app.VarSpec.varType()) {
+				continue
+			}
+			if !containsString(initExprs, app.nodeString(arg)) {
+				continue
+			}
+
+			debugf("%s: passing %s instead of %s", app.position(arg.Pos()), app.VarSpec.Name, app.nodeString(arg))
+			call.Args[i] = &ast.Ident{Name: app.VarSpec.Name, NamePos: arg.Pos()}
+		}
+
+		if after := app.nodeString(call); after != before {
+			app.recordChange(ChangeCall, call.Pos(), before, after)
+			app.markModified(call.Pos())
+		}
+
+		return true
+	})
+}
+
+// isTestHelper reports whether f is a function whose first parameter is *testing.T, *testing.B or testing.TB,
+// excluding tests and benchmarks themselves.
+func isTestHelper(f *types.Func) bool {
+	params := f.Type().(*types.Signature).Params()
+	if params.Len() == 0 {
+		return false
+	}
+
+	named, ok := derefType(params.At(0).Type()).(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	if obj.Pkg() == nil || obj.Pkg().Path() != "testing" || (obj.Name() != "T" && obj.Name() != "B" && obj.Name() != "TB") {
+		return false
+	}
+
+	if params.Len() == 1 && (strings.HasPrefix(f.Name(), "Test") || strings.HasPrefix(f.Name(), "Benchmark")) {
+		return false
+	}
+
+	return true
+}
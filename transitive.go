@@ -0,0 +1,100 @@
+package ctxize
+
+import (
+	"sort"
+	"strings"
+
+	"go/ast"
+	"go/types"
+)
+
+// RewriteTransitive rewrites the function specified by spec, and then the callers which the variable
+// is declared in by the rewrite, up through the call chains, so that the variable is passed
+// from the outermost callers. maxDepth limits the levels of callers rewritten, or is -1 for no limit;
+// RewriteTransitive(spec, 0) is the same as Rewrite(spec).
+// Functions in test files, main and init functions are not rewritten, as they are the roots of calls.
+// Each function is rewritten once, so mutually recursive functions are rewritten in turn.
+func (app *App) RewriteTransitive(spec FuncSpec, maxDepth int) error {
+	defer app.runAfterRewrite()
+
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	// specs are not resolved yet, so are keyed by their fields
+	key := func(spec FuncSpec) string { return spec.PkgPath + "." + spec.TypeName + "." + spec.FuncName }
+
+	visited := map[string]bool{}
+	queue := []FuncSpec{spec}
+
+	for depth := 0; len(queue) > 0; depth++ {
+		var next []FuncSpec
+		for _, spec := range queue {
+			stubbed := map[*ast.FuncDecl]bool{}
+			for funcDecl := range app.stubs {
+				stubbed[funcDecl] = true
+			}
+
+			if err := app.rewrite(spec); err != nil {
+				return err
+			}
+			visited[key(spec)] = true
+
+			if maxDepth >= 0 && depth >= maxDepth {
+				continue
+			}
+
+			for funcDecl := range app.stubs {
+				if stubbed[funcDecl] {
+					continue
+				}
+
+				caller, ok := app.callerSpec(funcDecl)
+				if !ok || visited[key(caller)] {
+					continue
+				}
+				visited[key(caller)] = true
+
+				debugf("%s: rewriting as a caller of %s", key(caller), spec)
+				next = append(next, caller)
+			}
+		}
+
+		sort.Slice(next, func(i, j int) bool { return key(next[i]) < key(next[j]) })
+		queue = next
+	}
+
+	return nil
+}
+
+// callerSpec returns the FuncSpec of funcDecl, or false if it should not take the variable,
+// ie. it is in a test file, or is a main or init function.
+func (app *App) callerSpec(funcDecl *ast.FuncDecl) (FuncSpec, bool) {
+	if strings.HasSuffix(app.position(funcDecl.Pos()).Filename, "_test.go") {
+		return FuncSpec{}, false
+	}
+
+	for _, pkg := range app.pkgs {
+		f, ok := pkg.TypesInfo.Defs[funcDecl.Name].(*types.Func)
+		if !ok {
+			continue
+		}
+
+		sig := f.Type().(*types.Signature)
+		if sig.Recv() == nil && (f.Name() == "init" || f.Name() == "main" && pkg.Name == "main") {
+			return FuncSpec{}, false
+		}
+
+		spec := FuncSpec{PkgPath: pkg.PkgPath, FuncName: f.Name()}
+		if recv := sig.Recv(); recv != nil {
+			named, ok := derefType(recv.Type()).(*types.Named)
+			if !ok {
+				return FuncSpec{}, false
+			}
+			spec.TypeName = named.Obj().Name()
+		}
+
+		return spec, true
+	}
+
+	return FuncSpec{}, false
+}
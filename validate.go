@@ -0,0 +1,95 @@
+package ctxize
+
+import (
+	"fmt"
+	"strings"
+
+	"go/ast"
+	"go/token"
+)
+
+// ValidationWarning is a call site given a context not derived from any other,
+// reported by ValidateCallSiteContexts.
+type ValidationWarning struct {
+	Pos      token.Position
+	Severity string // one of SeverityWarning and SeverityInfo
+	Message  string
+}
+
+// Severities of ValidationWarning.
+const (
+	SeverityWarning = "warning" // context.TODO(), which is a placeholder to be replaced
+	SeverityInfo    = "info"    // context.Background(), which may be intended
+)
+
+// insertedArg is an argument inserted to a call by rewriteCallExpr.
+type insertedArg struct {
+	call *ast.CallExpr
+	arg  ast.Expr
+}
+
+// ValidateCallSiteContexts reports arguments inserted by the rewrites so far which are
+// context.TODO() or context.Background(), either directly or by the variables declared
+// by the rewrites, in files other than tests. Such contexts are not cancelled along with
+// the requests or jobs the calls are made for.
+func (app *App) ValidateCallSiteContexts() []ValidationWarning {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	var warnings []ValidationWarning
+	seen := map[string]bool{}
+
+	for _, inserted := range app.insertedArgs {
+		p := app.position(inserted.call.Pos())
+		if strings.HasSuffix(p.Filename, "_test.go") || seen[p.String()] {
+			continue
+		}
+		seen[p.String()] = true
+
+		expr := inserted.arg
+		if id, ok := expr.(*ast.Ident); ok {
+			assign := app.enclosingStub(inserted.call.Pos(), id.Name)
+			if assign == nil {
+				continue
+			}
+			expr = assign.Rhs[0]
+		}
+
+		var severity string
+		switch app.nodeString(expr) {
+		case "context.TODO()":
+			severity = SeverityWarning
+		case "context.Background()":
+			severity = SeverityInfo
+		default:
+			continue
+		}
+
+		warnings = append(warnings, ValidationWarning{
+			Pos:      p,
+			Severity: severity,
+			Message:  fmt.Sprintf("%s is given %s, which is not derived from any context", app.nodeString(inserted.call.Fun), app.nodeString(expr)),
+		})
+	}
+
+	return warnings
+}
+
+// enclosingStub returns the declaration of the variable name added by a rewrite
+// to the function enclosing pos, if any.
+func (app *App) enclosingStub(pos token.Pos, name string) *ast.AssignStmt {
+	funcDecl, ok := app.findNodeEnclosing(pos, func(n ast.Node) (ok bool) { _, ok = n.(*ast.FuncDecl); return }).(*ast.FuncDecl)
+	if !ok {
+		return nil
+	}
+
+	assign := app.stubs[funcDecl]
+	if assign == nil {
+		return nil
+	}
+	if id, ok := assign.Lhs[0].(*ast.Ident); !ok || id.Name != name {
+		return nil
+	}
+
+	return assign
+}
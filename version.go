@@ -0,0 +1,34 @@
+package ctxize
+
+import (
+	"go/types"
+
+	"golang.org/x/xerrors"
+)
+
+// resolveVersion makes spec with Version point to the function of that version,
+// named FuncName followed by Version, eg. "FuncV1" for FuncName "Func" and Version "V1".
+// It fails if the function of the version already takes the variable,
+// which means it has been migrated already.
+func (app *App) resolveVersion(spec FuncSpec) (FuncSpec, error) {
+	spec.FuncName += spec.Version
+	spec.Version = ""
+
+	var found *types.Func
+	for _, obj := range spec.pkg.TypesInfo.Defs {
+		if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+			found = f
+			break
+		}
+	}
+	if found == nil {
+		return spec, xerrors.Errorf("could not find declaration of func %s in package %s", spec.FuncName, spec.PkgPath)
+	}
+
+	params := found.Type().(*types.Signature).Params()
+	if params.Len() > app.argIndex && types.Identical(params.At(app.argIndex).Type(), app.VarSpec.varType()) {
+		return spec, xerrors.Errorf("%s: func %s already takes %s", app.position(found.Pos()), spec, app.VarSpec.varTypeObj.Name())
+	}
+
+	return spec, nil
+}
@@ -0,0 +1,328 @@
+package ctxize
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go/ast"
+	"go/build/constraint"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+const wirePkgPath = "github.com/google/wire"
+
+// rewriteWireInjectors adds the variable to the parameters of Wire injectors building the function
+// of spec, directly or by provider sets declared by wire.NewSet, so that Wire provides the variable
+// to the function. Injectors are functions calling wire.Build in files constrained by "wireinject",
+// which are not loaded, so they are parsed from the directories of the packages loaded.
+func (app *App) rewriteWireInjectors(spec FuncSpec) error {
+	if !app.WireMode || spec.TypeName != "" {
+		return nil
+	}
+
+	sets := app.wireProviderSets(spec)
+
+	// the packages loaded, without dependencies, except those of the standard library
+	dirs := map[string]string{}
+	for _, pkg := range app.pkgs {
+		if pkg.Module == nil || len(pkg.GoFiles) == 0 {
+			continue
+		}
+		dirs[filepath.Dir(pkg.GoFiles[0])] = pkg.PkgPath
+	}
+
+	for dir, pkgPath := range dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".go") {
+				continue
+			}
+
+			err := app.rewriteWireInjectorFile(filepath.Join(dir, entry.Name()), pkgPath, spec, sets)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// rewriteWireInjectorFile rewrites the injectors in filename, of package pkgPath, if it is
+// constrained by "wireinject". The contents rewritten are visited by Each.
+func (app *App) rewriteWireInjectorFile(filename, pkgPath string, spec FuncSpec, sets map[string]bool) error {
+	key := filename
+	if rel, err := filepath.Rel(app.Config.Dir, filename); err == nil {
+		key = rel
+	}
+
+	content, ok := app.generated[key]
+	if !ok {
+		var err error
+		content, err = os.ReadFile(filename)
+		if err != nil {
+			return err
+		}
+	}
+	if !bytes.Contains(content, []byte("wireinject")) {
+		return nil
+	}
+
+	file, err := parser.ParseFile(app.Config.Fset, filename, content, parser.ParseComments)
+	if err != nil {
+		return err
+	}
+	if !isWireInjectorFile(file) {
+		return nil
+	}
+
+	// import paths by the names in file
+	imports := map[string]string{}
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := pathBase(path)
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		packages.Visit(app.pkgs, nil, func(pkg *packages.Package) {
+			if pkg.PkgPath == path && spec.Name == nil {
+				name = pkg.Name
+			}
+		})
+		imports[name] = path
+	}
+
+	// qualified name of the provider or the set referred to by expr
+	qualify := func(expr ast.Expr) string {
+		switch expr := expr.(type) {
+		case *ast.Ident:
+			return pkgPath + "." + expr.Name
+		case *ast.SelectorExpr:
+			if x, ok := expr.X.(*ast.Ident); ok {
+				return imports[x.Name] + "." + expr.Sel.Name
+			}
+		}
+		return ""
+	}
+
+	modified := false
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Body == nil || hasParamNamed(funcDecl, app.VarSpec.Name) {
+			continue
+		}
+
+		builds := false
+		ast.Inspect(funcDecl.Body, func(node ast.Node) bool {
+			call, ok := node.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			if sel, ok := call.Fun.(*ast.SelectorExpr); !ok || sel.Sel.Name != "Build" || qualify(sel) != wirePkgPath+".Build" {
+				return true
+			}
+			for _, arg := range call.Args {
+				if name := qualify(arg); name == spec.PkgPath+"."+spec.FuncName || sets[name] {
+					builds = true
+				}
+			}
+			return true
+		})
+		if !builds {
+			continue
+		}
+
+		debugf("%s: wire injector %s builds %s", app.position(funcDecl.Pos()), funcDecl.Name.Name, spec)
+
+		funcDecl.Type.Params.List = app.insertVarField(file, funcDecl.Type.Params)
+		app.addVarImport(file)
+		modified = true
+	}
+	if !modified {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := format.Node(&buf, app.Config.Fset, file); err != nil {
+		return err
+	}
+
+	app.generated[key] = buf.Bytes()
+	if app.wireDirs == nil {
+		app.wireDirs = map[string]bool{}
+	}
+	app.wireDirs[filepath.Dir(filename)] = true
+
+	return nil
+}
+
+// wireProviderSets returns the qualified names of package-level variables declared by wire.NewSet
+// which include the function of spec, directly or by other sets.
+func (app *App) wireProviderSets(spec FuncSpec) map[string]bool {
+	type set struct {
+		name string
+		args []types.Object
+	}
+
+	var all []set
+	packages.Visit(app.pkgs, nil, func(pkg *packages.Package) {
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				genDecl, ok := decl.(*ast.GenDecl)
+				if !ok {
+					continue
+				}
+				for _, s := range genDecl.Specs {
+					valueSpec, ok := s.(*ast.ValueSpec)
+					if !ok || len(valueSpec.Names) != len(valueSpec.Values) {
+						continue
+					}
+					for i, value := range valueSpec.Values {
+						call, ok := value.(*ast.CallExpr)
+						if !ok || !isWireFunc(pkg.TypesInfo, call.Fun, "NewSet") {
+							continue
+						}
+						s := set{name: pkg.PkgPath + "." + valueSpec.Names[i].Name}
+						for _, arg := range call.Args {
+							s.args = append(s.args, usedObject(pkg.TypesInfo, arg))
+						}
+						all = append(all, s)
+					}
+				}
+			}
+		}
+	})
+
+	sets := map[string]bool{}
+	for changed := true; changed; {
+		changed = false
+		for _, s := range all {
+			if sets[s.name] {
+				continue
+			}
+			for _, obj := range s.args {
+				if obj == nil || obj.Pkg() == nil {
+					continue
+				}
+				f, ok := obj.(*types.Func)
+				if ok && spec.matches(f) || sets[obj.Pkg().Path()+"."+obj.Name()] {
+					sets[s.name] = true
+					changed = true
+					break
+				}
+			}
+		}
+	}
+
+	return sets
+}
+
+// isWireProvider reports whether pos is an argument of wire.NewSet or wire.Build, which
+// registers the function as a provider and needs no change.
+func (app *App) isWireProvider(pkg *packages.Package, pos token.Pos) bool {
+	call, ok := app.findNodeEnclosing(pos, func(n ast.Node) bool { _, ok := n.(*ast.CallExpr); return ok }).(*ast.CallExpr)
+	if !ok || call.Fun.Pos() <= pos && pos < call.Fun.End() {
+		return false
+	}
+
+	return isWireFunc(pkg.TypesInfo, call.Fun, "NewSet") || isWireFunc(pkg.TypesInfo, call.Fun, "Build")
+}
+
+// RegenerateWire runs "wire gen" in the directories of the injectors rewritten by WireMode,
+// to regenerate wire_gen.go. It must be called after the files visited by Each are written.
+// It does nothing if DryRun is set.
+func (app *App) RegenerateWire() error {
+	app.mu.RLock()
+	defer app.mu.RUnlock()
+
+	if app.DryRun {
+		return nil
+	}
+
+	dirs := make([]string, 0, len(app.wireDirs))
+	for dir := range app.wireDirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		cmd := exec.Command("wire", "gen")
+		cmd.Dir = dir
+		cmd.Env = app.Config.Env
+
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return xerrors.Errorf("wire gen in %s: %w: %s", dir, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
+
+// isWireInjectorFile reports whether file is constrained by "wireinject",
+// ie. excluded from builds without the tag.
+func isWireInjectorFile(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() > file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			expr, err := constraint.Parse(comment.Text)
+			if err != nil {
+				continue
+			}
+			return expr.Eval(func(tag string) bool { return tag == "wireinject" }) && !expr.Eval(func(string) bool { return false })
+		}
+	}
+
+	return false
+}
+
+// isWireFunc reports whether fun refers to the function name of package github.com/google/wire.
+func isWireFunc(info *types.Info, fun ast.Expr, name string) bool {
+	f, ok := usedObject(info, fun).(*types.Func)
+	return ok && f.Pkg() != nil && f.Pkg().Path() == wirePkgPath && f.Name() == name
+}
+
+// usedObject returns the object referred to by the identifier or the qualified identifier expr, if any.
+func usedObject(info *types.Info, expr ast.Expr) types.Object {
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		return info.Uses[expr]
+	case *ast.SelectorExpr:
+		return info.Uses[expr.Sel]
+	}
+	return nil
+}
+
+// hasParamNamed reports whether funcDecl has a parameter of name.
+func hasParamNamed(funcDecl *ast.FuncDecl, name string) bool {
+	for _, field := range funcDecl.Type.Params.List {
+		for _, n := range field.Names {
+			if n.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathBase returns the last element of import path, which is usually the package name.
+func pathBase(path string) string {
+	return path[strings.LastIndex(path, "/")+1:]
+}
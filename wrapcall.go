@@ -0,0 +1,138 @@
+package ctxize
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+
+	"golang.org/x/tools/go/ast/astutil"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/xerrors"
+)
+
+// RewriteOnlyCallSites makes the callers of the function specified by spec have the variable
+// without touching the declaration, for functions in libraries which cannot be modified.
+// Each call is wrapped in a function literal taking the variable, eg.
+//
+//	func(ctx context.Context) error { return pkg.F(x) }(ctx)
+//
+// so that the callers are ready to pass the variable to the function once it accepts one.
+func (app *App) RewriteOnlyCallSites(spec FuncSpec) error {
+	app.mu.Lock()
+	defer app.mu.Unlock()
+
+	var err error
+	spec.pkg, err = app.resolvePackage(spec.PkgPath)
+	if err != nil {
+		return err
+	}
+
+	defer func(current FuncSpec) { app.current = current }(app.current)
+	app.current = spec
+
+	// test variants of packages share the syntax of the files with the packages
+	seen := map[token.Pos]bool{}
+
+	for _, pkg := range app.pkgs {
+		if app.isExcludedPackage(pkg.PkgPath) {
+			debugf("%s: excluded", pkg.PkgPath)
+			continue
+		}
+
+		for id, obj := range pkg.TypesInfo.Uses {
+			if f, ok := obj.(*types.Func); ok && spec.matches(f) {
+				if seen[id.Pos()] {
+					continue
+				}
+				seen[id.Pos()] = true
+
+				if err := app.wrapCallSite(pkg, id.Pos(), f); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// wrapCallSite wraps the call of f at pos in a function literal taking the variable,
+// declaring the variable in the enclosing function if required.
+func (app *App) wrapCallSite(pkg *packages.Package, pos token.Pos, f *types.Func) error {
+	if app.isExcluded(pos) || !app.isSelected(pos) {
+		debugf("%s: skipped", app.position(pos))
+		return nil
+	}
+
+	scope, funcDecl, err := app.findScope(pkg, pos)
+	if err != nil {
+		return err
+	}
+
+	callExpr, ok := app.findNodeEnclosing(pos, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		return ok && call.Fun.Pos() <= pos && pos < call.Fun.End()
+	}).(*ast.CallExpr)
+	if !ok {
+		return xerrors.Errorf("BUG: %s: could not find function call expression", app.position(pos))
+	}
+
+	file := app.markModified(callExpr.Pos())
+	if file == nil {
+		return xerrors.Errorf("BUG: %s: could not find file", app.position(pos))
+	}
+
+	qualifier := func(p *types.Package) string {
+		if p == pkg.Types {
+			return ""
+		}
+		astutil.AddImport(app.Config.Fset, file, p.Path())
+		return p.Name()
+	}
+
+	sig := f.Type().(*types.Signature)
+	var results string
+	if sig.Results().Len() == 1 && sig.Results().At(0).Name() == "" {
+		results = types.TypeString(sig.Results().At(0).Type(), qualifier)
+	} else if sig.Results().Len() > 0 {
+		results = types.TypeString(sig.Results(), qualifier)
+	}
+
+	before := app.nodeString(callExpr)
+	body := before
+	if sig.Results().Len() > 0 {
+		body = "return " + body
+	}
+
+	src := fmt.Sprintf("func(%s %s) %s { %s }", app.VarSpec.Name, types.TypeString(app.VarSpec.varType(), qualifier), results, body)
+	lit, err := parser.ParseExpr(src)
+	if err != nil {
+		return xerrors.Errorf("BUG: parsing %q: %w", src, err)
+	}
+	clearPos(lit)
+
+	arg := app.existingVarExpr(pkg, scope, pos)
+	usedExisting := arg != nil
+	if !usedExisting {
+		arg = ast.NewIdent(app.VarSpec.Name)
+	}
+
+	*callExpr = ast.CallExpr{
+		Fun:    lit,
+		Lparen: callExpr.Lparen,
+		Args:   []ast.Expr{arg},
+		Rparen: callExpr.Rparen,
+	}
+
+	app.recordChange(ChangeCall, callExpr.Pos(), before, app.nodeString(callExpr))
+
+	app.hoistStub(funcDecl, pos)
+
+	if !usedExisting {
+		return app.ensureVar(pkg, scope, funcDecl, pos)
+	}
+
+	return nil
+}